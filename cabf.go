@@ -0,0 +1,87 @@
+package eidas
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+// CABFOrganizationIdentifierExt is the OID of the CA/Browser Forum
+// cabfOrganizationIdentifier extension, defined in the EV Guidelines section
+// 9.8.2.
+var CABFOrganizationIdentifierExt = asn1.ObjectIdentifier{2, 23, 140, 3, 1}
+
+// CABFOrganizationIdentifier is the content of a cabfOrganizationIdentifier
+// extension.
+type CABFOrganizationIdentifier struct {
+	// Scheme is the three letter registration scheme, e.g. "PSD".
+	Scheme string
+	// Country is the ISO 3166-1 alpha-2 code of the registration, e.g. "GB".
+	Country string
+	// StateOrProvince is the optional state or province of the registration.
+	StateOrProvince string
+	// Reference is the registration reference, e.g. "FCA-123456".
+	Reference string
+}
+
+type cabfOrganizationIdentifier struct {
+	Scheme          string `asn1:"printable"`
+	Country         string `asn1:"printable"`
+	StateOrProvince string `asn1:"optional,tag:0,printable"`
+	Reference       string `asn1:"utf8"`
+}
+
+// CABFOrganizationIdentifier returns the cabfOrganizationIdentifier
+// equivalent of a PSD2 organizationIdentifier.
+func (id OrganizationID) CABFOrganizationIdentifier() CABFOrganizationIdentifier {
+	return CABFOrganizationIdentifier{
+		Scheme:    "PSD",
+		Country:   id.CountryCode,
+		Reference: id.NCA + "-" + id.Reference,
+	}
+}
+
+func (id CABFOrganizationIdentifier) validate() error {
+	if len(id.Scheme) != 3 || !isPrintableString(id.Scheme) {
+		return fmt.Errorf("registration scheme %q must be 3 printable characters", id.Scheme)
+	}
+	if len(id.Country) != countryCodeLength || !isPrintableString(id.Country) {
+		return fmt.Errorf("registration country %q must be %d printable characters", id.Country, countryCodeLength)
+	}
+	if len(id.StateOrProvince) > 128 || !isPrintableString(id.StateOrProvince) {
+		return fmt.Errorf("registration state or province %q must be at most 128 printable characters", id.StateOrProvince)
+	}
+	if id.Reference == "" {
+		return fmt.Errorf("registration reference is required")
+	}
+	return nil
+}
+
+func cabfOrganizationIdentifierExtension(id CABFOrganizationIdentifier) (pkix.Extension, error) {
+	if err := id.validate(); err != nil {
+		return pkix.Extension{}, err
+	}
+	d, err := asn1.Marshal(cabfOrganizationIdentifier(id))
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("failed to marshal cabfOrganizationIdentifier: %v", err)
+	}
+	return pkix.Extension{
+		Id:    CABFOrganizationIdentifierExt,
+		Value: d,
+	}, nil
+}
+
+// ParseCABFOrganizationIdentifier decodes the value of a
+// cabfOrganizationIdentifier extension.
+func ParseCABFOrganizationIdentifier(data []byte) (*CABFOrganizationIdentifier, error) {
+	var id cabfOrganizationIdentifier
+	rest, err := asn1.Unmarshal(data, &id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode cabfOrganizationIdentifier: %v", err)
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after cabfOrganizationIdentifier")
+	}
+	parsed := CABFOrganizationIdentifier(id)
+	return &parsed, nil
+}
@@ -0,0 +1,66 @@
+package eidas
+
+import (
+	"testing"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCABFOrganizationIdentifier(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+
+	Convey("CSR without the extension by default", t, func() {
+		req, err := NewCSRTemplate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		for _, ext := range req.ExtraExtensions {
+			So(ext.Id.Equal(CABFOrganizationIdentifierExt), ShouldBeFalse)
+		}
+	})
+
+	Convey("CSR with the extension derived from the organization ID", t, func() {
+		orgID, err := ParseOrganizationID("PSDGB-FCA-123456")
+		So(err, ShouldBeNil)
+		req, err := NewCSRTemplate("GB", "Foo Org", orgID.String(), "Foo Name", roles, qcstatements.QWACType,
+			WithCABFOrganizationIdentifier(orgID.CABFOrganizationIdentifier()))
+		So(err, ShouldBeNil)
+
+		var value []byte
+		for _, ext := range req.ExtraExtensions {
+			if ext.Id.Equal(CABFOrganizationIdentifierExt) {
+				So(ext.Critical, ShouldBeFalse)
+				value = ext.Value
+			}
+		}
+		So(value, ShouldNotBeNil)
+		id, err := ParseCABFOrganizationIdentifier(value)
+		So(err, ShouldBeNil)
+		So(*id, ShouldResemble, CABFOrganizationIdentifier{Scheme: "PSD", Country: "GB", Reference: "FCA-123456"})
+	})
+
+	Convey("extension with a state or province round trips", t, func() {
+		in := CABFOrganizationIdentifier{Scheme: "NTR", Country: "US", StateOrProvince: "DE", Reference: "1234567"}
+		ext, err := cabfOrganizationIdentifierExtension(in)
+		So(err, ShouldBeNil)
+		out, err := ParseCABFOrganizationIdentifier(ext.Value)
+		So(err, ShouldBeNil)
+		So(*out, ShouldResemble, in)
+	})
+
+	Convey("invalid values", t, func() {
+		for _, id := range []CABFOrganizationIdentifier{
+			{Scheme: "PSD2", Country: "GB", Reference: "FCA-123456"},
+			{Scheme: "PSD", Country: "GBR", Reference: "FCA-123456"},
+			{Scheme: "PSD", Country: "GB"},
+		} {
+			_, err := NewCSRTemplate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+				WithCABFOrganizationIdentifier(id))
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("malformed extension", t, func() {
+		_, err := ParseCABFOrganizationIdentifier([]byte{0x30, 0x01})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,422 @@
+package eidas
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+func findQCStatements(exts []pkix.Extension) []byte {
+	for _, ext := range exts {
+		if ext.Id.Equal(QCStatementsExt) {
+			return ext.Value
+		}
+	}
+	return nil
+}
+
+// CertificateQCTypes returns the QC types declared by the QcType statement of
+// a certificate, e.g. qcstatements.QWACType, in the order they are declared.
+// Use qcstatements.QCTypeForOID to recognize them.
+func CertificateQCTypes(cert *x509.Certificate) ([]asn1.ObjectIdentifier, error) {
+	qc := findQCStatements(cert.Extensions)
+	if qc == nil {
+		return nil, fmt.Errorf("eidas: certificate has no QCStatements extension")
+	}
+	types, err := qcstatements.ExtractTypes(qc)
+	if err != nil {
+		return nil, fmt.Errorf("eidas: %v", err)
+	}
+	return types, nil
+}
+
+// IsQWAC reports whether a certificate declares itself a QWAC, for website
+// authentication over TLS. A certificate declaring several types may be both
+// a QWAC and a QSEAL.
+func IsQWAC(cert *x509.Certificate) bool {
+	return hasQCType(cert, qcstatements.QWACType)
+}
+
+// IsQSEAL reports whether a certificate declares itself a QSEAL, for sealing
+// data such as signed requests.
+func IsQSEAL(cert *x509.Certificate) bool {
+	return hasQCType(cert, qcstatements.QSEALType)
+}
+
+func hasQCType(cert *x509.Certificate, qcType asn1.ObjectIdentifier) bool {
+	types, err := CertificateQCTypes(cert)
+	if err != nil {
+		return false
+	}
+	return containsOID(types, qcType)
+}
+
+// Confidence is how far a QC type returned by GuessQCType can be relied on.
+type Confidence int
+
+const (
+	// ConfidenceNone means no type could be guessed.
+	ConfidenceNone Confidence = iota
+	// ConfidenceLow means the key usages only loosely suggest the type.
+	ConfidenceLow
+	// ConfidenceHigh means the key usages clearly suggest the type, though
+	// it is still a guess.
+	ConfidenceHigh
+	// ConfidenceDeclared means the type was read from the certificate's
+	// QcType statement and is not a guess.
+	ConfidenceDeclared
+)
+
+func (c Confidence) String() string {
+	switch c {
+	case ConfidenceNone:
+		return "none"
+	case ConfidenceLow:
+		return "low"
+	case ConfidenceHigh:
+		return "high"
+	case ConfidenceDeclared:
+		return "declared"
+	}
+	return fmt.Sprintf("Confidence(%d)", int(c))
+}
+
+// GuessQCType returns the QC type of a certificate. If it has a QcType
+// statement the first declared type is returned with ConfidenceDeclared.
+// Otherwise the type is guessed from the key usages, as a fallback for
+// certificates lacking the statement, and the result is heuristic:
+//
+//   - serverAuth and digitalSignature without nonRepudiation suggest a QWAC;
+//   - digitalSignature and nonRepudiation without serverAuth or clientAuth
+//     weakly suggest a QSEAL, as an ESIGN certificate may have them too;
+//   - clientAuth alone, with digitalSignature, weakly suggests a QWAC.
+//
+// Anything else is ambiguous and returns nil with ConfidenceNone. That
+// includes serverAuth with nonRepudiation, and nonRepudiation without
+// digitalSignature, the key usage of an ESIGN certificate.
+func GuessQCType(cert *x509.Certificate) (asn1.ObjectIdentifier, Confidence) {
+	if types, err := CertificateQCTypes(cert); err == nil {
+		return types[0], ConfidenceDeclared
+	}
+
+	var serverAuth, clientAuth bool
+	for _, usage := range cert.ExtKeyUsage {
+		switch usage {
+		case x509.ExtKeyUsageServerAuth:
+			serverAuth = true
+		case x509.ExtKeyUsageClientAuth:
+			clientAuth = true
+		}
+	}
+	digitalSignature := cert.KeyUsage&x509.KeyUsageDigitalSignature != 0
+	nonRepudiation := cert.KeyUsage&x509.KeyUsageContentCommitment != 0
+
+	switch {
+	case serverAuth && digitalSignature && !nonRepudiation:
+		return qcstatements.QWACType, ConfidenceHigh
+	case digitalSignature && nonRepudiation && !serverAuth && !clientAuth:
+		return qcstatements.QSEALType, ConfidenceLow
+	case clientAuth && !serverAuth && digitalSignature && !nonRepudiation:
+		return qcstatements.QWACType, ConfidenceLow
+	}
+	return nil, ConfidenceNone
+}
+
+var keyUsageNames = map[x509.KeyUsage]string{
+	x509.KeyUsageDigitalSignature:  "digitalSignature",
+	x509.KeyUsageContentCommitment: "nonRepudiation",
+	x509.KeyUsageKeyEncipherment:   "keyEncipherment",
+	x509.KeyUsageDataEncipherment:  "dataEncipherment",
+	x509.KeyUsageKeyAgreement:      "keyAgreement",
+	x509.KeyUsageCertSign:          "keyCertSign",
+	x509.KeyUsageCRLSign:           "cRLSign",
+	x509.KeyUsageEncipherOnly:      "encipherOnly",
+	x509.KeyUsageDecipherOnly:      "decipherOnly",
+}
+
+// ValidateKeyUsage checks that the KeyUsage of a certificate includes every
+// usage its QC type demands: digitalSignature for QWAC, and digitalSignature
+// and nonRepudiation for QSEAL. The QC type is read from the certificate's
+// QCStatements extension.
+func ValidateKeyUsage(cert *x509.Certificate) error {
+	qc := findQCStatements(cert.Extensions)
+	if qc == nil {
+		return fmt.Errorf("eidas: certificate has no QCStatements extension")
+	}
+
+	qcType, err := qcstatements.ExtractType(qc)
+	if err != nil {
+		return fmt.Errorf("eidas: %v", err)
+	}
+	if err := checkKeyUsageForType(cert.KeyUsage, qcType); err != nil {
+		return fmt.Errorf("eidas: %v", err)
+	}
+	return nil
+}
+
+func checkKeyUsageForType(keyUsage x509.KeyUsage, qcType asn1.ObjectIdentifier) error {
+	expected, err := keyUsageForType(qcType)
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, usage := range expected {
+		if keyUsage&usage == 0 {
+			missing = append(missing, keyUsageNames[usage])
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("key usage for QC type %v is missing %s", qcType, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// VerifyIssuedCertificate checks that a PEM encoded certificate returned by a
+// CA matches the DER encoded CSR it was issued for: it must have the same
+// public key and subject, and its QCStatements must carry the same QC type
+// and, if the CSR has a PSD2 statement, the same roles and competent
+// authority. A certificate for a CSR made WithoutPSD2 must not have a PSD2
+// statement. CAs may add further statements, such as QcCompliance, without
+// failing verification.
+func VerifyIssuedCertificate(csrDER, certPEM []byte) error {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return fmt.Errorf("failed to parse csr: %v", err)
+	}
+	cert, err := ReadCertificate(certPEM)
+	if err != nil {
+		return err
+	}
+
+	pub, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(csr.PublicKey) {
+		return fmt.Errorf("eidas: certificate public key does not match csr")
+	}
+
+	if err := compareNames(csr.Subject.Names, cert.Subject.Names); err != nil {
+		return fmt.Errorf("eidas: certificate subject does not match csr: %v", err)
+	}
+
+	if err := compareQCStatements(findQCStatements(csr.Extensions), findQCStatements(cert.Extensions)); err != nil {
+		return fmt.Errorf("eidas: certificate QCStatements do not match csr: %v", err)
+	}
+	return nil
+}
+
+// compareNames compares subject attributes in order, ignoring differences in
+// string encoding.
+func compareNames(requested, issued []pkix.AttributeTypeAndValue) error {
+	if len(requested) != len(issued) {
+		return fmt.Errorf("expected %d attributes, got %d", len(requested), len(issued))
+	}
+	for i := range requested {
+		if !requested[i].Type.Equal(issued[i].Type) {
+			return fmt.Errorf("expected attribute %v, got %v", requested[i].Type, issued[i].Type)
+		}
+		if fmt.Sprint(requested[i].Value) != fmt.Sprint(issued[i].Value) {
+			return fmt.Errorf("expected %v to be %q, got %q", requested[i].Type, requested[i].Value, issued[i].Value)
+		}
+	}
+	return nil
+}
+
+func compareQCStatements(requested, issued []byte) error {
+	if requested == nil {
+		return nil
+	}
+	if issued == nil {
+		return fmt.Errorf("missing QCStatements extension")
+	}
+
+	requestedType, err := qcstatements.ExtractType(requested)
+	if err != nil {
+		return err
+	}
+	issuedType, err := qcstatements.ExtractType(issued)
+	if err != nil {
+		return err
+	}
+	if !requestedType.Equal(issuedType) {
+		return fmt.Errorf("expected QC type %v, got %v", requestedType, issuedType)
+	}
+
+	requestedPSD2, err := hasPSD2Statement(requested)
+	if err != nil {
+		return err
+	}
+	issuedPSD2, err := hasPSD2Statement(issued)
+	if err != nil {
+		return err
+	}
+	if requestedPSD2 != issuedPSD2 {
+		if requestedPSD2 {
+			return fmt.Errorf("missing PSD2 statement")
+		}
+		return fmt.Errorf("unexpected PSD2 statement")
+	}
+	if !requestedPSD2 {
+		return nil
+	}
+
+	requestedRoles, requestedName, requestedID, err := qcstatements.Extract(requested)
+	if err != nil {
+		return err
+	}
+	issuedRoles, issuedName, issuedID, err := qcstatements.Extract(issued)
+	if err != nil {
+		return err
+	}
+	if added, removed := qcstatements.RolesDiff(requestedRoles, issuedRoles); len(added) != 0 || len(removed) != 0 {
+		return fmt.Errorf("roles added: %v, removed: %v", added, removed)
+	}
+	if requestedName != issuedName || requestedID != issuedID {
+		return fmt.Errorf("expected CA %s (%s), got %s (%s)", requestedName, requestedID, issuedName, issuedID)
+	}
+	return nil
+}
+
+// hasPSD2Statement reports whether the encoded QCStatements qc include a PSD2
+// statement.
+func hasPSD2Statement(qc []byte) (bool, error) {
+	statements, err := qcstatements.ExtractRaw(qc)
+	if err != nil {
+		return false, err
+	}
+	for _, st := range statements {
+		if st.OID.Equal(qcstatements.PSD2OID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// BundlePEM concatenates the PEM encodings of the given certificates, which
+// should be ordered from the leaf certificate to the root.
+func BundlePEM(certs ...*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		// Writing to a bytes.Buffer cannot fail.
+		_ = pem.Encode(&buf, &pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: cert.Raw,
+		})
+	}
+	return buf.Bytes()
+}
+
+var (
+	oidPKCS7Data       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      asn1.RawValue
+}
+
+// BundlePKCS7 returns a DER encoded, certificate-only PKCS#7 SignedData
+// structure (a "p7b" file) containing the given certificates.
+func BundlePKCS7(certs ...*x509.Certificate) ([]byte, error) {
+	var raw []byte
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw...)
+	}
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+	sd, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidPKCS7Data},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      emptySet,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal PKCS#7 signed data: %v", err)
+	}
+	d, err := asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidPKCS7SignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal PKCS#7 content info: %v", err)
+	}
+	return d, nil
+}
+
+// issuedExtensions lists the CSR extensions CertificateTemplate copies into a
+// certificate. Extensions that depend on the issuer, such as the
+// authorityKeyIdentifier and cRLDistributionPoints, are left to the CA.
+var issuedExtensions = []asn1.ObjectIdentifier{
+	{2, 5, 29, 14}, // subjectKeyIdentifier
+	{2, 5, 29, 15}, // keyUsage
+	{2, 5, 29, 17}, // subjectAltName
+	{2, 5, 29, 32}, // certificatePolicies
+	{2, 5, 29, 37}, // extKeyUsage
+	QCStatementsExt,
+	CABFOrganizationIdentifierExt,
+	SubjectDirectoryAttributesExt,
+}
+
+// CertificateTemplate returns a template for issuing a certificate for csr,
+// valid from now for the given duration. The subject and the
+// subjectKeyIdentifier, keyUsage, subjectAltName, certificatePolicies,
+// extKeyUsage, QCStatements, cabfOrganizationIdentifier and
+// subjectDirectoryAttributes extensions are copied from the CSR, and the
+// serial number is random. The CSR's signature is checked first.
+func CertificateTemplate(csr *x509.CertificateRequest, validity time.Duration) (*x509.Certificate, error) {
+	if validity <= 0 {
+		return nil, fmt.Errorf("eidas: validity must be positive, got %v", validity)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("eidas: invalid csr signature: %v", err)
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, fmt.Errorf("eidas: %v", err)
+	}
+
+	var exts []pkix.Extension
+	for _, ext := range csr.Extensions {
+		for _, id := range issuedExtensions {
+			if ext.Id.Equal(id) {
+				exts = append(exts, ext)
+				break
+			}
+		}
+	}
+
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber:    serial,
+		RawSubject:      csr.RawSubject,
+		NotBefore:       now,
+		NotAfter:        now.Add(validity),
+		ExtraExtensions: exts,
+	}, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	return serial, nil
+}
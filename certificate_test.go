@@ -0,0 +1,314 @@
+package eidas
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func selfSignedCertificate(t *testing.T, qcType asn1.ObjectIdentifier, usage x509.KeyUsage) *x509.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := qcstatements.CompetentAuthorityForCountryCode("GB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	qc, err := qcstatements.Serialize([]qcstatements.Role{qcstatements.RoleAccountInformation}, *ca, qcType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: "Foo Name"},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		KeyUsage:        usage,
+		ExtraExtensions: []pkix.Extension{qcStatementsExtension(qc)},
+	}
+	d, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestValidateKeyUsage(t *testing.T) {
+	Convey("QWAC with digitalSignature", t, func() {
+		cert := selfSignedCertificate(t, qcstatements.QWACType, x509.KeyUsageDigitalSignature)
+		So(ValidateKeyUsage(cert), ShouldBeNil)
+	})
+
+	Convey("QSEAL with digitalSignature and nonRepudiation", t, func() {
+		cert := selfSignedCertificate(t, qcstatements.QSEALType, x509.KeyUsageDigitalSignature|x509.KeyUsageContentCommitment)
+		So(ValidateKeyUsage(cert), ShouldBeNil)
+	})
+
+	Convey("QSEAL without nonRepudiation", t, func() {
+		cert := selfSignedCertificate(t, qcstatements.QSEALType, x509.KeyUsageDigitalSignature)
+		err := ValidateKeyUsage(cert)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "nonRepudiation")
+	})
+
+	Convey("certificate without QCStatements", t, func() {
+		So(ValidateKeyUsage(&x509.Certificate{}), ShouldNotBeNil)
+	})
+}
+
+func TestCertificateQCTypes(t *testing.T) {
+	withStatements := func(statements ...interface{}) *x509.Certificate {
+		d, err := asn1.Marshal(statements)
+		So(err, ShouldBeNil)
+		return &x509.Certificate{Extensions: []pkix.Extension{qcStatementsExtension(d)}}
+	}
+	type qcTypeStatement struct {
+		OID    asn1.ObjectIdentifier
+		Detail []asn1.ObjectIdentifier
+	}
+	type compliance struct {
+		OID asn1.ObjectIdentifier
+	}
+
+	Convey("QWAC", t, func() {
+		cert := selfSignedCertificate(t, qcstatements.QWACType, x509.KeyUsageDigitalSignature)
+		types, err := CertificateQCTypes(cert)
+		So(err, ShouldBeNil)
+		So(types, ShouldResemble, []asn1.ObjectIdentifier{qcstatements.QWACType})
+		So(IsQWAC(cert), ShouldBeTrue)
+		So(IsQSEAL(cert), ShouldBeFalse)
+	})
+
+	Convey("QSEAL", t, func() {
+		cert := selfSignedCertificate(t, qcstatements.QSEALType, x509.KeyUsageDigitalSignature|x509.KeyUsageContentCommitment)
+		So(IsQWAC(cert), ShouldBeFalse)
+		So(IsQSEAL(cert), ShouldBeTrue)
+	})
+
+	Convey("several types", t, func() {
+		cert := withStatements(qcTypeStatement{qcstatements.QcTypeOID, []asn1.ObjectIdentifier{qcstatements.QSEALType, qcstatements.QWACType}})
+		types, err := CertificateQCTypes(cert)
+		So(err, ShouldBeNil)
+		So(types, ShouldResemble, []asn1.ObjectIdentifier{qcstatements.QSEALType, qcstatements.QWACType})
+		So(IsQWAC(cert), ShouldBeTrue)
+		So(IsQSEAL(cert), ShouldBeTrue)
+	})
+
+	Convey("no QcType statement", t, func() {
+		cert := withStatements(compliance{qcstatements.QcComplianceOID})
+		_, err := CertificateQCTypes(cert)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "no QcType statement")
+		So(IsQWAC(cert), ShouldBeFalse)
+	})
+
+	Convey("certificate without QCStatements", t, func() {
+		_, err := CertificateQCTypes(&x509.Certificate{})
+		So(err, ShouldNotBeNil)
+		So(IsQSEAL(&x509.Certificate{}), ShouldBeFalse)
+	})
+}
+
+func TestGuessQCType(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		keyUsage   x509.KeyUsage
+		extUsage   []x509.ExtKeyUsage
+		expected   asn1.ObjectIdentifier
+		confidence Confidence
+	}{
+		{"QWAC", x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, qcstatements.QWACType, ConfidenceHigh},
+		{"QSEAL", x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment, nil, qcstatements.QSEALType, ConfidenceLow},
+		{"ESIGN", x509.KeyUsageContentCommitment, nil, nil, ConfidenceNone},
+		{"client only", x509.KeyUsageDigitalSignature, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, qcstatements.QWACType, ConfidenceLow},
+		{"ambiguous", x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, nil, ConfidenceNone},
+		{"no usages", x509.KeyUsageDigitalSignature, nil, nil, ConfidenceNone},
+	} {
+		Convey("heuristic for "+tc.name, t, func() {
+			cert := &x509.Certificate{KeyUsage: tc.keyUsage, ExtKeyUsage: tc.extUsage}
+			qcType, confidence := GuessQCType(cert)
+			So(qcType, ShouldResemble, tc.expected)
+			So(confidence, ShouldEqual, tc.confidence)
+		})
+	}
+
+	Convey("declared type wins over key usages", t, func() {
+		cert := selfSignedCertificate(t, qcstatements.QSEALType, x509.KeyUsageDigitalSignature)
+		cert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		qcType, confidence := GuessQCType(cert)
+		So(qcType, ShouldResemble, qcstatements.QSEALType)
+		So(confidence, ShouldEqual, ConfidenceDeclared)
+		So(confidence.String(), ShouldEqual, "declared")
+	})
+}
+
+// issueCertificate signs a certificate for the CSR with a throwaway CA,
+// replacing the CSR's extensions with exts if given.
+func issueCertificate(t *testing.T, csrDER []byte, exts []pkix.Extension) []byte {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exts == nil {
+		exts = csr.Extensions
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		RawSubject:      csr.RawSubject,
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: exts,
+	}
+	d := newTestCA(t, "Test CA", nil).sign(t, tmpl, csr.PublicKey)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: d})
+}
+
+func TestVerifyIssuedCertificate(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	csrDER, _, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("certificate matching the CSR", t, func() {
+		certPEM := issueCertificate(t, csrDER, nil)
+		So(VerifyIssuedCertificate(csrDER, certPEM), ShouldBeNil)
+	})
+
+	Convey("certificate with altered roles", t, func() {
+		ca, err := qcstatements.CompetentAuthorityForCountryCode("GB")
+		So(err, ShouldBeNil)
+		qc, err := qcstatements.Serialize([]qcstatements.Role{qcstatements.RolePaymentInitiation}, *ca, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		certPEM := issueCertificate(t, csrDER, []pkix.Extension{qcStatementsExtension(qc)})
+		err = VerifyIssuedCertificate(csrDER, certPEM)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "roles")
+	})
+
+	Convey("certificate for a different key", t, func() {
+		otherDER, _, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		certPEM := issueCertificate(t, otherDER, nil)
+		So(VerifyIssuedCertificate(csrDER, certPEM), ShouldNotBeNil)
+	})
+
+	Convey("non-PSD2 certificate matching the CSR", t, func() {
+		nonPSD2, _, err := GenerateCSR("GB", "Foo Org", "", "Foo Name", nil, qcstatements.QSEALType, WithoutPSD2())
+		So(err, ShouldBeNil)
+		So(VerifyIssuedCertificate(nonPSD2, issueCertificate(t, nonPSD2, nil)), ShouldBeNil)
+	})
+
+	Convey("PSD2 statement added to a non-PSD2 CSR", t, func() {
+		nonPSD2, _, err := GenerateCSR("GB", "Foo Org", "", "Foo Name", nil, qcstatements.QSEALType, WithoutPSD2())
+		So(err, ShouldBeNil)
+		ca, err := qcstatements.CompetentAuthorityForCountryCode("GB")
+		So(err, ShouldBeNil)
+		qc, err := qcstatements.Serialize(roles, *ca, qcstatements.QSEALType)
+		So(err, ShouldBeNil)
+		err = VerifyIssuedCertificate(nonPSD2, issueCertificate(t, nonPSD2, []pkix.Extension{qcStatementsExtension(qc)}))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "unexpected PSD2 statement")
+	})
+
+	Convey("PSD2 statement dropped from the certificate", t, func() {
+		qc, err := qcstatements.Serialize(nil, qcstatements.CompetentAuthority{}, qcstatements.QWACType, qcstatements.OmitPSD2())
+		So(err, ShouldBeNil)
+		err = VerifyIssuedCertificate(csrDER, issueCertificate(t, csrDER, []pkix.Extension{qcStatementsExtension(qc)}))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "missing PSD2 statement")
+	})
+}
+
+func TestBundle(t *testing.T) {
+	leaf := selfSignedCertificate(t, qcstatements.QWACType, x509.KeyUsageDigitalSignature)
+	intermediate := selfSignedCertificate(t, qcstatements.QSEALType, x509.KeyUsageDigitalSignature)
+
+	Convey("PEM bundle", t, func() {
+		d := BundlePEM(leaf, intermediate)
+		var parsed []*x509.Certificate
+		for {
+			var block *pem.Block
+			block, d = pem.Decode(d)
+			if block == nil {
+				break
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			So(err, ShouldBeNil)
+			parsed = append(parsed, cert)
+		}
+		So(parsed, ShouldHaveLength, 2)
+		So(parsed[0].Raw, ShouldResemble, leaf.Raw)
+		So(parsed[1].Raw, ShouldResemble, intermediate.Raw)
+	})
+
+	Convey("PKCS#7 bundle", t, func() {
+		d, err := BundlePKCS7(leaf, intermediate)
+		So(err, ShouldBeNil)
+
+		var ci pkcs7ContentInfo
+		_, err = asn1.Unmarshal(d, &ci)
+		So(err, ShouldBeNil)
+		So(ci.ContentType, ShouldResemble, oidPKCS7SignedData)
+		var sd pkcs7SignedData
+		_, err = asn1.Unmarshal(ci.Content.Bytes, &sd)
+		So(err, ShouldBeNil)
+		certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+		So(err, ShouldBeNil)
+		So(certs, ShouldHaveLength, 2)
+		So(certs[0].Raw, ShouldResemble, leaf.Raw)
+	})
+}
+
+func TestCertificateTemplate(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	csrDER, _, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QSEALType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("issued certificate matches the CSR", t, func() {
+		tmpl, err := CertificateTemplate(csr, 24*time.Hour)
+		So(err, ShouldBeNil)
+		So(tmpl.NotAfter.Sub(tmpl.NotBefore), ShouldEqual, 24*time.Hour)
+
+		ca := newTestCA(t, "Test CA", nil)
+		cert, err := x509.ParseCertificate(ca.sign(t, tmpl, csr.PublicKey))
+		So(err, ShouldBeNil)
+		So(ValidateKeyUsage(cert), ShouldBeNil)
+		So(VerifyIssuedCertificate(csrDER, BundlePEM(cert)), ShouldBeNil)
+		So(cert.SubjectKeyId, ShouldNotBeEmpty)
+		So(cert.AuthorityKeyId, ShouldResemble, ca.cert.SubjectKeyId)
+	})
+
+	Convey("serial numbers are random", t, func() {
+		a, err := CertificateTemplate(csr, time.Hour)
+		So(err, ShouldBeNil)
+		b, err := CertificateTemplate(csr, time.Hour)
+		So(err, ShouldBeNil)
+		So(a.SerialNumber.Cmp(b.SerialNumber), ShouldNotEqual, 0)
+	})
+
+	Convey("non-positive validity", t, func() {
+		_, err := CertificateTemplate(csr, 0)
+		So(err, ShouldNotBeNil)
+	})
+}
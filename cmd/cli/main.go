@@ -1,16 +1,21 @@
 package main
 
 import (
+	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/asn1"
-	"encoding/pem"
+	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/creditkudos/eidas"
 	"github.com/creditkudos/eidas/qcstatements"
@@ -19,19 +24,25 @@ import (
 var countryCode = flag.String("country-code", "", "ISO-3166-1 Alpha 2 country code")
 var orgName = flag.String("organization-name", "", "Organization name")
 var orgID = flag.String("organization-id", "", "Organization ID")
-var commonName = flag.String("common-name", "", "Common Name")
+var commonName = flag.String("common-name", "", "Common Name; defaults to the authorization number from -organization-id")
 var roles = flag.String("roles", string(qcstatements.RoleAccountInformation), "eIDAS roles; comma-separated list from [PSP_AS, PSP_PI, PSP_AI, PSP_IC]")
-var qcType = flag.String("type", "QWAC", "Certificate type; one of QWAC or QSEAL")
+var qcType = flag.String("type", "QWAC", "Certificate type; one of QWAC, QSEAL or ESIGN")
 
 var outCSR = flag.String("csr", "out.csr", "Output file for CSR")
 var outKey = flag.String("key", "out.key", "Output file for private key")
+var csrFormat = flag.String("csr-format", "pem", "Output format for CSR; one of pem or der")
+var keyFormat = flag.String("key-format", "pem", "Output format for the private key; one of pem or der")
+var keyPKCS1 = flag.Bool("key-pkcs1", false, "Write the private key in PKCS#1 'RSA PRIVATE KEY' format instead of PKCS#8, for legacy tools")
+var csrBlockType = flag.String("csr-pem-type", eidas.CSRBlockType, "PEM block type for the CSR, e.g. 'NEW CERTIFICATE REQUEST' for legacy tools")
 
 var dnsNames = flag.String("dns-names", "", "Comma separated list of domain names to add as Subject Alternate Names")
+var dryRun = flag.Bool("dry-run", false, "Print a summary of the CSR that would be generated without generating a key or writing any files")
+var serverAuthOnly = flag.Bool("server-auth-only", false, "Only request the serverAuth extended key usage for a QWAC, omitting clientAuth")
+var jsonSummary = flag.Bool("json-summary", false, "Print a JSON summary of the generated CSR, for recording its provenance, instead of just its fingerprint")
+var orgUnits = flag.String("organizational-units", "", "Comma separated list of organizational unit names to add to the subject")
 
-func writeCSR(path string, data []byte) (err error) {
-	fmt.Printf("%x\n", sha256.Sum256(data))
-
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+func writeFile(path string, data []byte, perm os.FileMode) (err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 	if err != nil {
 		return err
 	}
@@ -42,45 +53,344 @@ func writeCSR(path string, data []byte) (err error) {
 			}
 		}
 	}()
-	return pem.Encode(f, &pem.Block{
-		Type:  "CERTIFICATE REQUEST",
-		Bytes: data,
-	})
+	_, err = f.Write(data)
+	return err
+}
+
+func writeCSR(path string, data []byte) error {
+	if *csrFormat == "der" {
+		return writeFile(path, data, 0644)
+	}
+	return writeFile(path, eidas.EncodeCSRPEMWithType(data, *csrBlockType), 0644)
 }
 
-func writeKey(path string, key *rsa.PrivateKey) (err error) {
-	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+func writeKey(path string, key *rsa.PrivateKey) error {
+	encode := eidas.EncodePrivateKeyPEM
+	if *keyFormat == "der" {
+		encode = eidas.EncodePrivateKeyDER
+	}
+	format := eidas.KeyFormatPKCS8
+	if *keyPKCS1 {
+		format = eidas.KeyFormatPKCS1
+	}
+	d, err := encode(key, format)
 	if err != nil {
 		return err
 	}
+	return writePrivateFile(path, d)
+}
 
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+// writePrivateFile writes data to a file readable by its owner only. The data
+// is written to a new temporary file created with those permissions, which
+// then replaces path, so the data is never readable by others, and nothing is
+// left behind on failure.
+func writePrivateFile(path string, data []byte) (err error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.%x.tmp", path, suffix)
+	f, err := createPrivateFile(tmp)
 	if err != nil {
 		return err
 	}
 	defer func() {
-		if err2 := f.Close(); err2 != nil {
-			if err == nil {
-				err = err2
-			}
+		if err != nil {
+			os.Remove(tmp)
 		}
 	}()
-	return pem.Encode(f, &pem.Block{
-		Type:  "PRIVATE KEY",
-		Bytes: pkcs8,
-	})
+	_, err = f.Write(data)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+var extensionNames = map[string]string{
+	"2.5.29.14":                    "subjectKeyIdentifier",
+	"2.5.29.15":                    "keyUsage",
+	"2.5.29.17":                    "subjectAltName",
+	"2.5.29.37":                    "extKeyUsage",
+	eidas.QCStatementsExt.String(): "qcStatements",
+}
+
+// printTemplate prints a human-readable summary of a CSR template to stdout.
+func printTemplate(req *x509.CertificateRequest) error {
+	var subject pkix.RDNSequence
+	if _, err := asn1.Unmarshal(req.RawSubject, &subject); err != nil {
+		return fmt.Errorf("Failed to decode subject: %v", err)
+	}
+	fmt.Printf("Subject: %s\n", subject)
+	if len(req.DNSNames) != 0 {
+		fmt.Printf("DNS names: %s\n", strings.Join(req.DNSNames, ", "))
+	}
+	for _, ext := range req.ExtraExtensions {
+		name, ok := extensionNames[ext.Id.String()]
+		if !ok {
+			name = ext.Id.String()
+		}
+		fmt.Printf("Extension: %s (critical: %t)\n", name, ext.Critical)
+		if ext.Id.Equal(eidas.QCStatementsExt) {
+			if err := qcstatements.DumpVerbose(ext.Value); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Println("No key was generated, so there is no CSR fingerprint or subjectKeyIdentifier.")
+	return nil
+}
+
+// rolesFromFlag parses a comma-separated list of roles, ignoring empty
+// entries, and requires at least one.
+func rolesFromFlag(in string) ([]qcstatements.Role, error) {
+	var valid []string
+	for _, role := range qcstatements.AllRoles() {
+		valid = append(valid, string(role))
+	}
+	var names []string
+	for _, name := range strings.Split(in, ",") {
+		if strings.TrimSpace(name) != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("-roles must list at least one of %s", strings.Join(valid, ", "))
+	}
+	r, err := qcstatements.ParseRoles(names)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid -roles: %v; valid roles are %s", err, strings.Join(valid, ", "))
+	}
+	return r, nil
 }
 
 func typeFromFlag(in string) (asn1.ObjectIdentifier, error) {
-	if in == "QWAC" {
-		return qcstatements.QWACType, nil
-	} else if in == "QSEAL" {
-		return qcstatements.QSEALType, nil
+	return eidas.QCTypeByName(in)
+}
+
+// checkOutputFormats exits if -csr-format or -key-format is invalid, so that
+// no key is generated for output that cannot be written.
+func checkOutputFormats() {
+	if *csrFormat != "pem" && *csrFormat != "der" {
+		log.Fatalf("-csr-format must be one of pem or der, got %q", *csrFormat)
+	}
+	if *keyFormat != "pem" && *keyFormat != "der" {
+		log.Fatalf("-key-format must be one of pem or der, got %q", *keyFormat)
+	}
+}
+
+// rotate implements the rotate subcommand, which writes a new key and a CSR
+// for it with the same subject and extensions as an existing CSR.
+func rotate(args []string) {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	from := fs.String("from", "", "Existing CSR to copy, PEM or DER encoded")
+	fs.StringVar(outCSR, "csr", *outCSR, "Output file for the new CSR")
+	fs.StringVar(outKey, "key", *outKey, "Output file for the new private key")
+	fs.StringVar(csrFormat, "csr-format", *csrFormat, "Output format for CSR; one of pem or der")
+	fs.StringVar(keyFormat, "key-format", *keyFormat, "Output format for the private key; one of pem or der")
+	fs.BoolVar(keyPKCS1, "key-pkcs1", *keyPKCS1, "Write the private key in PKCS#1 'RSA PRIVATE KEY' format instead of PKCS#8")
+	fs.StringVar(csrBlockType, "csr-pem-type", *csrBlockType, "PEM block type for the CSR")
+	// ExitOnError means Parse does not return errors.
+	_ = fs.Parse(args)
+
+	if *from == "" {
+		log.Fatal("-from is required, e.g., 'old.csr'")
+	}
+	if *from == *outCSR {
+		log.Fatalf("-csr must differ from -from to keep the existing CSR")
+	}
+	checkOutputFormats()
+	data, err := ioutil.ReadFile(*from)
+	if err != nil {
+		log.Fatalf("Failed to read CSR from %s: %v", *from, err)
+	}
+	old, err := eidas.ReadCSR(data)
+	if err != nil {
+		log.Fatalf("Failed to parse CSR from %s: %v", *from, err)
+	}
+
+	d, key, err := eidas.RekeyCSR(old)
+	if err != nil {
+		log.Fatalf(":-( %v", err)
+	}
+	fmt.Printf("Old CSR fingerprint: %s\n", eidas.Fingerprint(old.Raw))
+	fmt.Printf("New CSR fingerprint: %s\n", eidas.Fingerprint(d))
+	if err := writeCSR(*outCSR, d); err != nil {
+		log.Fatalf("Failed to write CSR to %s: %v", *outCSR, err)
+	}
+	if err := writeKey(*outKey, key); err != nil {
+		log.Fatalf("Failed to write key to %s: %v", *outKey, err)
+	}
+}
+
+// readCertificate reads a PEM or DER encoded certificate from a file.
+func readCertificate(path string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate from %s: %v", path, err)
+	}
+	cert, err := eidas.ReadCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate from %s: %v", path, err)
+	}
+	return cert, nil
+}
+
+// doctor reports the conformance problems of an existing certificate, and
+// exits with status 1 if any is an error.
+func doctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	in := fs.String("cert", "", "Certificate to check, PEM or DER encoded")
+	asJSON := fs.Bool("json", false, "Print the findings as JSON")
+	// ExitOnError means Parse does not return errors.
+	_ = fs.Parse(args)
+
+	if *in == "" {
+		log.Fatal("-cert is required, e.g., 'qwac.pem'")
+	}
+	cert, err := readCertificate(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	findings := eidas.LintCertificate(cert, eidas.LintOptions{})
+	if *asJSON {
+		if findings == nil {
+			findings = []eidas.Finding{}
+		}
+		out, err := json.MarshalIndent(findings, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+	} else if len(findings) == 0 {
+		fmt.Println("No problems found.")
+	}
+	for _, f := range findings {
+		if !*asJSON {
+			fmt.Printf("%s: %s\n", f.Severity, f.Message)
+		}
+	}
+	if eidas.HasErrors(findings) {
+		os.Exit(1)
+	}
+}
+
+// inspectRow is a line of the inspect subcommand's output. Error is set
+// instead of the other fields if the file could not be inspected.
+type inspectRow struct {
+	File string `json:"file"`
+	*eidas.CertificateSummary
+	Error string `json:"error,omitempty"`
+}
+
+var inspectColumns = []string{"file", "organization", "organization_id", "roles", "types", "ca_id", "ca_name", "not_after", "error"}
+
+func (r inspectRow) columns() []string {
+	if r.CertificateSummary == nil {
+		return []string{r.File, "", "", "", "", "", "", "", r.Error}
+	}
+	roles := make([]string, len(r.Roles))
+	for i, role := range r.Roles {
+		roles[i] = string(role)
+	}
+	return []string{
+		r.File,
+		r.Organization,
+		r.OrganizationID,
+		strings.Join(roles, ";"),
+		strings.Join(r.Types, ";"),
+		r.CAID,
+		r.CAName,
+		r.NotAfter.UTC().Format(time.RFC3339),
+		r.Error,
+	}
+}
+
+// inspect summarizes every certificate in a directory tree, writing a row per
+// file as it goes. Files that cannot be inspected are reported in the error
+// column, and make it exit with status 1 once all files have been inspected.
+func inspect(args []string) {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	dir := fs.String("dir", "", "Directory of PEM or DER encoded certificates to inspect")
+	format := fs.String("format", "csv", "Output format; one of csv, or json for a JSON object per line")
+	// ExitOnError means Parse does not return errors.
+	_ = fs.Parse(args)
+
+	if *dir == "" {
+		log.Fatal("-dir is required, e.g., 'certs'")
+	}
+	var write func(inspectRow) error
+	switch *format {
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		if err := w.Write(inspectColumns); err != nil {
+			log.Fatal(err)
+		}
+		write = func(r inspectRow) error {
+			if err := w.Write(r.columns()); err != nil {
+				return err
+			}
+			w.Flush()
+			return w.Error()
+		}
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		write = func(r inspectRow) error { return enc.Encode(r) }
+	default:
+		log.Fatalf("Unknown output format: %s", *format)
+	}
+
+	failed := false
+	err := filepath.Walk(*dir, func(path string, info os.FileInfo, err error) error {
+		row := inspectRow{File: path}
+		switch {
+		case err != nil:
+			row.Error = err.Error()
+		case info.IsDir():
+			if path != *dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		case !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), "."):
+			return nil
+		default:
+			cert, err := readCertificate(path)
+			if err == nil {
+				row.CertificateSummary, err = eidas.SummarizeCertificate(cert)
+			}
+			if err != nil {
+				row.Error = err.Error()
+			}
+		}
+		failed = failed || row.Error != ""
+		return write(row)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if failed {
+		os.Exit(1)
 	}
-	return nil, fmt.Errorf("Unknown QC type: %s", in)
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "rotate" {
+		rotate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		doctor(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		inspect(os.Args[2:])
+		return
+	}
+
 	flag.Parse()
 
 	if *countryCode == "" {
@@ -96,20 +406,31 @@ func main() {
 	}
 
 	if *commonName == "" {
-		log.Fatal("-common-name is required, e.g., '0123456789abcdef'")
+		id, err := eidas.ParseOrganizationID(*orgID)
+		if err != nil {
+			log.Fatalf("-common-name is required as it cannot be derived from -organization-id: %v", err)
+		}
+		*commonName = id.Reference
+		log.Printf("Using common name %q derived from organization ID %q", *commonName, *orgID)
 	}
 
+	checkOutputFormats()
+
 	t, err := typeFromFlag(*qcType)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	var r []qcstatements.Role
-	for _, role := range strings.Split(*roles, ",") {
-		r = append(r, qcstatements.Role(role))
+	r, err := rolesFromFlag(*roles)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	var opts []eidas.CertificateOption
+	opts := []eidas.CertificateOption{
+		eidas.WithWarningHandler(func(w string) {
+			log.Printf("Warning: %s", w)
+		}),
+	}
 	if *dnsNames != "" {
 		names := strings.Split(*dnsNames, ",")
 		for _, name := range names {
@@ -117,11 +438,46 @@ func main() {
 		}
 	}
 
+	if *orgUnits != "" {
+		for _, ou := range strings.Split(*orgUnits, ",") {
+			opts = append(opts, eidas.WithOrganizationalUnit(strings.TrimSpace(ou)))
+		}
+	}
+
+	if *serverAuthOnly {
+		opts = append(opts, eidas.WithServerAuthOnly())
+	}
+
+	if *dryRun {
+		req, err := eidas.NewCSRTemplate(
+			*countryCode, *orgName, *orgID, *commonName, r, t, opts...)
+		if err != nil {
+			log.Fatalf(":-( %v", err)
+		}
+		if err := printTemplate(req); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	d, key, err := eidas.GenerateCSR(
 		*countryCode, *orgName, *orgID, *commonName, r, t, opts...)
 	if err != nil {
 		log.Fatalf(":-( %v", err)
 	}
+	if *jsonSummary {
+		summary, err := eidas.SummarizeCSR(d, key)
+		if err != nil {
+			log.Fatalf(":-( %v", err)
+		}
+		out, err := json.Marshal(summary)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+	} else {
+		fmt.Println(eidas.Fingerprint(d))
+	}
 	if err := writeCSR(*outCSR, d); err != nil {
 		log.Fatalf("Failed to write CSR to %s: %v", *outCSR, err)
 	}
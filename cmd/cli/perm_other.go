@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "os"
+
+// createPrivateFile creates a new file at path readable and writable by its
+// owner only. It fails if the file already exists.
+func createPrivateFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+}
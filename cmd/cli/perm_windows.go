@@ -0,0 +1,63 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+	procConvertStringSecurityDescriptorToSecurityDescriptorW = advapi32.NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+)
+
+const sddlRevision1 = 1
+
+// createPrivateFile creates a new file at path whose ACL grants full access to
+// the current user only. File modes passed to os.OpenFile are largely ignored
+// on Windows, so the file is created with the ACL by CreateFile rather than
+// inheriting that of its directory. It fails if the file already exists.
+func createPrivateFile(path string) (*os.File, error) {
+	token, err := syscall.OpenCurrentProcessToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open process token: %v", err)
+	}
+	defer token.Close()
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token user: %v", err)
+	}
+	sid, err := user.User.Sid.String()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user SID: %v", err)
+	}
+
+	// Protected DACL with a single entry allowing the owner full access.
+	sddl, err := syscall.UTF16PtrFromString(fmt.Sprintf("D:P(A;;FA;;;%s)", sid))
+	if err != nil {
+		return nil, err
+	}
+	var sd uintptr
+	if r, _, err := procConvertStringSecurityDescriptorToSecurityDescriptorW.Call(
+		uintptr(unsafe.Pointer(sddl)), sddlRevision1, uintptr(unsafe.Pointer(&sd)), 0); r == 0 {
+		return nil, fmt.Errorf("failed to build security descriptor: %v", err)
+	}
+	defer syscall.LocalFree(syscall.Handle(sd))
+
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	sa := syscall.SecurityAttributes{SecurityDescriptor: sd}
+	sa.Length = uint32(unsafe.Sizeof(sa))
+	h, err := syscall.CreateFile(p, syscall.GENERIC_WRITE, 0, &sa, syscall.CREATE_NEW, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
@@ -3,55 +3,674 @@
 package eidas
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
-	"encoding/binary"
 	"fmt"
-	"log"
+	"math/bits"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/creditkudos/eidas/qcstatements"
 )
 
-type CertificateOption func(*x509.CertificateRequest)
+// CertificateOption configures optional behaviour of GenerateCSR.
+type CertificateOption func(*csrConfig)
+
+type csrConfig struct {
+	resolver   qcstatements.CompetentAuthorityResolver
+	nonPSD2    bool
+	strict     bool
+	requirePDS bool
+	strictRole bool
+	deriveCA   bool
+	warn       func(string)
+	keyPin     func(KeyPin)
+	qcOptions  []qcstatements.Option
+	reqOptions []func(*x509.CertificateRequest)
+
+	organizationalUnits []string
+	orgNameEncoding     StringEncoding
+	serialNumber        string
+	subjectOrder        []asn1.ObjectIdentifier
+	cabfOrgID           *CABFOrganizationIdentifier
+	directoryAttributes []DirectoryAttribute
+	policies            []Policy
+
+	notBefore, notAfter time.Time
+	seed                []byte
+	skipSelfCheck       bool
+	skiMethod           SKIMethod
+	keyEncipherment     bool
+	allowNonWebSANs     bool
+	critical            []criticalOverride
+
+	extKeyUsage    []asn1.ObjectIdentifier
+	extKeyUsageSet bool
+}
+
+func newCSRConfig(opts []CertificateOption) *csrConfig {
+	cfg := &csrConfig{
+		resolver: qcstatements.DefaultResolver,
+		warn:     func(string) {},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
 
 // WithDNSName adds the given domain as a Subject Alternate Name to the CSR.
 func WithDNSName(domain string) CertificateOption {
-	return func(req *x509.CertificateRequest) {
-		req.DNSNames = append(req.DNSNames, domain)
+	return func(c *csrConfig) {
+		c.reqOptions = append(c.reqOptions, func(req *x509.CertificateRequest) {
+			req.DNSNames = append(req.DNSNames, domain)
+		})
+	}
+}
+
+// WithIPAddress adds the given IP address as a Subject Alternate Name to the
+// CSR, e.g. for a QWAC on a gateway with a fixed IP. IPv4 addresses are
+// encoded in 4 bytes and IPv6 addresses in 16.
+func WithIPAddress(ip net.IP) CertificateOption {
+	return func(c *csrConfig) {
+		c.reqOptions = append(c.reqOptions, func(req *x509.CertificateRequest) {
+			req.IPAddresses = append(req.IPAddresses, ip)
+		})
+	}
+}
+
+// WithURI adds the given URI as a Subject Alternate Name to the CSR, e.g. to
+// identify the service endpoint of a QSEAL. A QWAC may only have URIs if
+// AllowNonWebSANs is also given.
+func WithURI(uri *url.URL) CertificateOption {
+	return func(c *csrConfig) {
+		c.reqOptions = append(c.reqOptions, func(req *x509.CertificateRequest) {
+			req.URIs = append(req.URIs, uri)
+		})
+	}
+}
+
+// WithEmailAddress adds the given address as an rfc822Name Subject Alternate
+// Name to the CSR. A QWAC may only have email addresses if AllowNonWebSANs is
+// also given.
+func WithEmailAddress(address string) CertificateOption {
+	return func(c *csrConfig) {
+		c.reqOptions = append(c.reqOptions, func(req *x509.CertificateRequest) {
+			req.EmailAddresses = append(req.EmailAddresses, address)
+		})
+	}
+}
+
+// AllowNonWebSANs allows URI and email Subject Alternate Names on a QWAC,
+// which otherwise only identifies websites by DNS name or IP address.
+func AllowNonWebSANs() CertificateOption {
+	return func(c *csrConfig) {
+		c.allowNonWebSANs = true
+	}
+}
+
+// WithExtendedKeyUsage replaces the extended key usages chosen for the QC
+// type, which for a QWAC are serverAuth and clientAuth, as CAs disagree on
+// what a QWAC should carry. Giving no usages omits the extendedKeyUsage
+// extension. Each usage must be one defined in RFC 5280.
+func WithExtendedKeyUsage(usages ...asn1.ObjectIdentifier) CertificateOption {
+	return func(c *csrConfig) {
+		c.extKeyUsage = usages
+		c.extKeyUsageSet = true
+	}
+}
+
+// WithServerAuthOnly limits the extended key usage to serverAuth.
+func WithServerAuthOnly() CertificateOption {
+	return WithExtendedKeyUsage(tLSWWWServerAuthUsage)
+}
+
+// WithCABFOrganizationIdentifier adds a cabfOrganizationIdentifier extension
+// to the CSR, as used by certificates following the CA/Browser Forum EV
+// profile. OrganizationID.CABFOrganizationIdentifier gives the value
+// equivalent to a PSD2 organization ID.
+func WithCABFOrganizationIdentifier(id CABFOrganizationIdentifier) CertificateOption {
+	return func(c *csrConfig) {
+		c.cabfOrgID = &id
+	}
+}
+
+// WithoutSelfCheck stops GenerateCSR from parsing the CSR it generates and
+// checking its signature before returning it.
+func WithoutSelfCheck() CertificateOption {
+	return func(c *csrConfig) {
+		c.skipSelfCheck = true
+	}
+}
+
+// WithKeyEncipherment adds keyEncipherment to the KeyUsage of a QWAC, for CAs
+// that still expect it. It is only needed for the RSA key exchange of TLS 1.2
+// and earlier, which lacks forward secrecy; TLS 1.3 and ECDHE cipher suites
+// only use digitalSignature, which is the default. It may only be used with
+// RSA keys.
+func WithKeyEncipherment() CertificateOption {
+	return func(c *csrConfig) {
+		c.keyEncipherment = true
+	}
+}
+
+// WithExtensionCritical overrides whether the requested extension identified
+// by oid is marked critical, for CAs that disagree on the defaults: only
+// KeyUsage is critical by default. KeyUsage may not be made non-critical, and
+// the subjectDirectoryAttributes extension may not be made critical, as RFC
+// 5280 forbids both. It is an error to override an extension the CSR does not
+// request; the subjectKeyIdentifier and any subjectAltName may be overridden.
+// Overrides are applied in the order given, and a later override of the same
+// extension replaces an earlier one.
+func WithExtensionCritical(oid asn1.ObjectIdentifier, critical bool) CertificateOption {
+	return func(c *csrConfig) {
+		for i := range c.critical {
+			if c.critical[i].id.Equal(oid) {
+				c.critical[i].critical = critical
+				return
+			}
+		}
+		c.critical = append(c.critical, criticalOverride{id: oid, critical: critical})
+	}
+}
+
+// criticalOverride is an override set by WithExtensionCritical.
+type criticalOverride struct {
+	id       asn1.ObjectIdentifier
+	critical bool
+}
+
+// criticalFor returns whether the extension identified by id is overridden
+// to be critical, or def if it is not overridden.
+func (c *csrConfig) criticalFor(id asn1.ObjectIdentifier, def bool) bool {
+	for _, o := range c.critical {
+		if o.id.Equal(id) {
+			return o.critical
+		}
+	}
+	return def
+}
+
+// WithSubjectKeyIdentifierMethod selects how the subjectKeyIdentifier is
+// derived from the public key. The default is SKIMethodSHA1.
+func WithSubjectKeyIdentifierMethod(m SKIMethod) CertificateOption {
+	return func(c *csrConfig) {
+		c.skiMethod = m
+	}
+}
+
+// WithSubjectDirectoryAttributes adds a subjectDirectoryAttributes extension
+// holding the given attributes to the CSR. It may be given several times, the
+// attributes being combined in the order given.
+func WithSubjectDirectoryAttributes(attrs ...DirectoryAttribute) CertificateOption {
+	return func(c *csrConfig) {
+		c.directoryAttributes = append(c.directoryAttributes, attrs...)
+	}
+}
+
+// WithOrganizationalUnit adds an organizationalUnitName to the CSR subject
+// after the organizationName. It may be given several times, each value being
+// added as a separate RDN in the order given.
+func WithOrganizationalUnit(ou string) CertificateOption {
+	return func(c *csrConfig) {
+		c.organizationalUnits = append(c.organizationalUnits, ou)
+	}
+}
+
+// StringEncoding selects the ASN.1 string type of a subject attribute.
+type StringEncoding int
+
+const (
+	// StringEncodingAuto uses PrintableString if the value only contains
+	// characters it allows, and UTF8String otherwise.
+	StringEncodingAuto StringEncoding = iota
+	// StringEncodingPrintable always uses PrintableString, so the value may
+	// only contain letters, digits, spaces and the characters '()+,-./:=?.
+	StringEncodingPrintable
+	// StringEncodingUTF8 always uses UTF8String.
+	StringEncodingUTF8
+)
+
+// WithOrganizationNameEncoding sets the string type of the subject
+// organizationName, for CAs that expect one type regardless of content. The
+// default is StringEncodingAuto, so that a name with accented characters is
+// a UTF8String and an ASCII name a PrintableString.
+func WithOrganizationNameEncoding(e StringEncoding) CertificateOption {
+	return func(c *csrConfig) {
+		c.orgNameEncoding = e
+	}
+}
+
+// encodeString returns the value of a subject attribute holding s with the
+// string type e.
+func encodeString(s string, e StringEncoding) (asn1.RawValue, error) {
+	switch e {
+	case StringEncodingAuto:
+		if isPrintableString(s) {
+			return encodeString(s, StringEncodingPrintable)
+		}
+		return encodeString(s, StringEncodingUTF8)
+	case StringEncodingPrintable:
+		if !isPrintableString(s) {
+			return asn1.RawValue{}, fmt.Errorf("%q is not a valid PrintableString", s)
+		}
+		return asn1.RawValue{Tag: asn1.TagPrintableString, Bytes: []byte(s)}, nil
+	case StringEncodingUTF8:
+		if !utf8.ValidString(s) {
+			return asn1.RawValue{}, fmt.Errorf("%q is not valid UTF-8", s)
+		}
+		return asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte(s)}, nil
+	}
+	return asn1.RawValue{}, fmt.Errorf("unknown string encoding %d", e)
+}
+
+// WithSerialNumber sets the subject serialNumber, e.g. to identify the device
+// holding the key of a QSEAL. It is encoded as a PrintableString so may only
+// contain letters, digits, spaces and the characters '()+,-./:=?.
+func WithSerialNumber(serial string) CertificateOption {
+	return func(c *csrConfig) {
+		c.serialNumber = serial
+	}
+}
+
+// WithSubjectOrder sets the order of the subject attributes by their type,
+// e.g. {2.5.4.3, 2.5.4.6, 2.5.4.10, 2.5.4.97} for a CA whose template puts
+// the commonName first. The order must include the type of every attribute in
+// the subject; types it includes that the subject lacks, such as
+// serialNumber (2.5.4.5), are ignored. Several organizationalUnitNames keep
+// the order they were given in. By default the order is countryName,
+// organizationName, organizationalUnitName, organizationIdentifier,
+// commonName, serialNumber.
+func WithSubjectOrder(order ...asn1.ObjectIdentifier) CertificateOption {
+	return func(c *csrConfig) {
+		c.subjectOrder = order
+	}
+}
+
+// WithDerivedCompetentAuthority falls back to deriving the competent
+// authority from the NCA in the organization ID when the resolver does not
+// know the country. The derived authority has no name and is reported to the
+// warning handler as unverified.
+func WithDerivedCompetentAuthority() CertificateOption {
+	return func(c *csrConfig) {
+		c.deriveCA = true
+	}
+}
+
+// deriveCompetentAuthority builds an unverified competent authority from a
+// PSD2 organization ID, returning lookupErr if the ID cannot be parsed.
+func deriveCompetentAuthority(orgID string, lookupErr error) (*qcstatements.CompetentAuthority, error) {
+	id, err := ParseOrganizationID(orgID)
+	if err != nil {
+		return nil, lookupErr
+	}
+	return qcstatements.DeriveCompetentAuthority(id.CountryCode, id.NCA)
+}
+
+// WithCompetentAuthorityResolver looks up the competent authority for the
+// country code using r rather than the built-in list.
+func WithCompetentAuthorityResolver(r qcstatements.CompetentAuthorityResolver) CertificateOption {
+	return func(c *csrConfig) {
+		c.resolver = r
+	}
+}
+
+// WithLegalPersonSemantics adds a statement declaring that the subject is a
+// legal person, as PSD2 subjects always are.
+func WithLegalPersonSemantics() CertificateOption {
+	return func(c *csrConfig) {
+		c.qcOptions = append(c.qcOptions, qcstatements.WithLegalPersonSemantics())
+	}
+}
+
+// WithRoleOIDArc identifies the PSD2 roles by OIDs under arc rather than the
+// standard 0.4.0.19495.1, as qcstatements.WithRoleOIDArc.
+func WithRoleOIDArc(arc asn1.ObjectIdentifier) CertificateOption {
+	return func(c *csrConfig) {
+		c.qcOptions = append(c.qcOptions, qcstatements.WithRoleOIDArc(arc))
+	}
+}
+
+// WithQcCompliance adds the QcCompliance statement to the CSR.
+func WithQcCompliance() CertificateOption {
+	return func(c *csrConfig) {
+		c.qcOptions = append(c.qcOptions, qcstatements.WithCompliance())
+	}
+}
+
+// WithQcPDS adds a QcPDS statement pointing at the given PKI Disclosure
+// Statements to the CSR.
+func WithQcPDS(locations ...qcstatements.PDSLocation) CertificateOption {
+	return func(c *csrConfig) {
+		c.qcOptions = append(c.qcOptions, qcstatements.WithPDS(locations...))
+	}
+}
+
+// WithAdditionalQCStatements appends pre-encoded statements to the
+// QCStatements extension of the CSR. See qcstatements.WithAdditionalStatements.
+func WithAdditionalQCStatements(statements ...[]byte) CertificateOption {
+	return func(c *csrConfig) {
+		c.qcOptions = append(c.qcOptions, qcstatements.WithAdditionalStatements(statements...))
+	}
+}
+
+// WithoutPSD2 produces a qualified CSR for use outside PSD2. The QCStatements
+// carry QcCompliance and QcType but no PSD2 RolesInfo, and the subject has no
+// organizationIdentifier, so GenerateCSR must be given no roles and an empty
+// orgID.
+func WithoutPSD2() CertificateOption {
+	return func(c *csrConfig) {
+		c.nonPSD2 = true
+		c.qcOptions = append(c.qcOptions, qcstatements.WithCompliance(), qcstatements.OmitPSD2())
+	}
+}
+
+// WithWarningHandler passes warnings about questionable but permitted input to
+// h, e.g. log.Print. Warnings are discarded by default.
+func WithWarningHandler(h func(warning string)) CertificateOption {
+	return func(c *csrConfig) {
+		c.warn = h
+	}
+}
+
+// StrictRoles makes GenerateCSR fail if the roles are inappropriate for the QC
+// type, rather than passing a warning to the WithWarningHandler; see
+// ValidateRolesForType for the rules.
+func StrictRoles() CertificateOption {
+	return func(c *csrConfig) {
+		c.strictRole = true
+	}
+}
+
+// Strict makes GenerateCSR fail if the assembled QCStatements are missing any
+// statement that ETSI makes mandatory; see qcstatements.CheckMandatory.
+func Strict() CertificateOption {
+	return func(c *csrConfig) {
+		c.strict = true
+	}
+}
+
+// RequireQcPDS is like Strict but additionally requires a QcPDS statement,
+// as some certificate profiles do.
+func RequireQcPDS() CertificateOption {
+	return func(c *csrConfig) {
+		c.strict = true
+		c.requirePDS = true
 	}
 }
 
 // GenerateCSR builds a certificate signing request for an organization.
-// qcType should be one of qcstatements.QSEALType or qcstatements.QWACType.
+// qcType should be one of qcstatements.QSEALType or qcstatements.QWACType, or
+// a type added with RegisterQCType.
+// Roles given as strings can be converted with qcstatements.ParseRoles.
+// If orgID is a PSD2 organization ID, e.g. "PSDGB-FCA-123456", its country
+// must be countryCode.
+// Invalid arguments and options are reported together as InputErrors.
 func GenerateCSR(
 	countryCode string, orgName string, orgID string, commonName string, roles []qcstatements.Role, qcType asn1.ObjectIdentifier, opts ...CertificateOption) ([]byte, *rsa.PrivateKey, error) {
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	req, err := NewCSRTemplate(countryCode, orgName, orgID, commonName, roles, qcType, opts...)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to generate key pair: %v", err)
+		return nil, nil, err
 	}
+	return signCSR(req, newCSRConfig(opts))
+}
 
-	ca, err := qcstatements.CompetentAuthorityForCountryCode(countryCode)
+// GenerateCSRWithSigner is like GenerateCSR but signs the CSR with an
+// existing key rather than generating one. The key may be held remotely, e.g.
+// in an HSM, as only its Public and Sign methods are used. RSA, ECDSA and
+// Ed25519 keys are supported.
+func GenerateCSRWithSigner(signer crypto.Signer,
+	countryCode string, orgName string, orgID string, commonName string, roles []qcstatements.Role, qcType asn1.ObjectIdentifier, opts ...CertificateOption) ([]byte, error) {
+	req, err := NewCSRTemplate(countryCode, orgName, orgID, commonName, roles, qcType, opts...)
 	if err != nil {
-		return nil, nil, fmt.Errorf("eidas: %v", err)
+		return nil, err
 	}
+	cfg := newCSRConfig(opts)
+	if _, ok := signer.Public().(*rsa.PublicKey); cfg.keyEncipherment && !ok {
+		return nil, fmt.Errorf("eidas: keyEncipherment requires an RSA key, got %T", signer.Public())
+	}
+	return signCSRWithKey(req, signer, cfg)
+}
 
-	qc, err := qcstatements.Serialize(roles, *ca, qcType)
+// GenerateCSRWithRawSubject is like GenerateCSR but uses a DER encoded
+// subject verbatim, e.g. the RawSubject of a prior certificate, so that the
+// subject is byte-identical across renewals. The country code, organization
+// name, organizationIdentifier and common name are read from the subject to
+// resolve the competent authority and validate the request. Options that
+// change the subject, such as WithOrganizationalUnit, have no effect.
+func GenerateCSRWithRawSubject(rawSubject []byte, roles []qcstatements.Role, qcType asn1.ObjectIdentifier, opts ...CertificateOption) ([]byte, *rsa.PrivateKey, error) {
+	attrs, err := ParseSubject(rawSubject)
 	if err != nil {
 		return nil, nil, fmt.Errorf("eidas: %v", err)
 	}
+	fields := map[string]string{}
+	for _, attr := range attrs {
+		if _, ok := fields[attr.Type.String()]; !ok {
+			fields[attr.Type.String()] = attr.Value
+		}
+	}
+	req, err := NewCSRTemplate(
+		fields[oidCountryCode.String()], fields[oidOrganizationName.String()], fields[oidOrganizationID.String()], fields[oidCommonName.String()],
+		roles, qcType, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.RawSubject = rawSubject
+	return signCSR(req, newCSRConfig(opts))
+}
 
-	keyUsage, err := keyUsageForType(qcType)
+// RekeyCSR builds a certificate signing request for a new key with the same
+// subject and extensions as csr, for rotating the key of an existing
+// certificate. Only the key and the subjectKeyIdentifier differ: the new
+// subjectKeyIdentifier is derived with the SKIMethod that derived the old one
+// and keeps its position among the extensions.
+func RekeyCSR(csr *x509.CertificateRequest) ([]byte, *rsa.PrivateKey, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, nil, fmt.Errorf("invalid csr signature: %v", err)
+	}
+	cfg := newCSRConfig(nil)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key pair: %v", err)
+	}
+	exts := append([]pkix.Extension{}, csr.Extensions...)
+	for i, ext := range exts {
+		if !ext.Id.Equal(oidSubjectKeyIdentifier) {
+			continue
+		}
+		ski, err := subjectKeyIdentifier(key.Public(), detectSKIMethod(csr.PublicKey, ext.Value))
+		if err != nil {
+			return nil, nil, err
+		}
+		ski.Critical = ext.Critical
+		exts[i] = ski
+	}
+	d, err := signCSRWithKey(&x509.CertificateRequest{
+		RawSubject:         csr.RawSubject,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+		PublicKeyAlgorithm: x509.RSA,
+		ExtraExtensions:    exts,
+	}, key, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d, key, nil
+}
+
+// detectSKIMethod returns the SKIMethod that derives the encoded
+// subjectKeyIdentifier value from pub. Both methods give 20 bytes, so the
+// value is recomputed with each; SKIMethodSHA1 is returned if neither matches.
+func detectSKIMethod(pub crypto.PublicKey, value []byte) SKIMethod {
+	var id []byte
+	if rest, err := asn1.Unmarshal(value, &id); err != nil || len(rest) != 0 || len(id) != sha1.Size {
+		return SKIMethodSHA1
+	}
+	if sha256ID, err := keyIdentifier(pub, SKIMethodSHA256); err == nil && bytes.Equal(id, sha256ID) {
+		return SKIMethodSHA256
+	}
+	return SKIMethodSHA1
+}
+
+// RenewCSR builds a certificate signing request to renew cert, carrying
+// forward its subject and the extensions CertificateTemplate would copy, such
+// as its QCStatements. If key is nil a new key is generated. Otherwise key
+// must be the certificate's key, and the CSR then keeps the
+// subjectKeyIdentifier of cert, which some CAs use to match a renewal to the
+// certificate it replaces.
+func RenewCSR(cert *x509.Certificate, key *rsa.PrivateKey) ([]byte, *rsa.PrivateKey, error) {
+	if key != nil && !key.PublicKey.Equal(cert.PublicKey) {
+		return nil, nil, fmt.Errorf("eidas: key does not match certificate")
+	}
+	var exts []pkix.Extension
+	for _, ext := range cert.Extensions {
+		for _, id := range issuedExtensions {
+			// A new key needs a new subjectKeyIdentifier.
+			if ext.Id.Equal(id) && (key != nil || !ext.Id.Equal(oidSubjectKeyIdentifier)) {
+				exts = append(exts, ext)
+				break
+			}
+		}
+	}
+	req := &x509.CertificateRequest{
+		RawSubject:         cert.RawSubject,
+		SignatureAlgorithm: x509.SHA256WithRSA,
+		PublicKeyAlgorithm: x509.RSA,
+		ExtraExtensions:    exts,
+	}
+	if key == nil {
+		return signCSR(req, newCSRConfig(nil))
+	}
+	d, err := signCSRWithKey(req, key, newCSRConfig(nil))
 	if err != nil {
 		return nil, nil, err
 	}
-	extendedKeyUsage, err := extendedKeyUsageForType(qcType)
+	return d, key, nil
+}
+
+// signCSR generates a key and signs req with it, adding the
+// subjectKeyIdentifier extension. Unless cfg skips the self-check the result
+// is parsed and its signature checked before it is returned.
+func signCSR(req *x509.CertificateRequest, cfg *csrConfig) ([]byte, *rsa.PrivateKey, error) {
+	if cfg.seed != nil {
+		return nil, nil, errReproducibleSeed
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key pair: %v", err)
+	}
+	csr, err := signCSRWithKey(req, key, cfg)
 	if err != nil {
 		return nil, nil, err
 	}
+	return csr, key, nil
+}
+
+// signCSRWithKey is like signCSR but uses the given key. The
+// subjectKeyIdentifier extension is only added if req does not have one; see
+// insertSKI for where.
+func signCSRWithKey(req *x509.CertificateRequest, key crypto.Signer, cfg *csrConfig) ([]byte, error) {
+	if cfg.seed != nil {
+		return nil, errReproducibleSeed
+	}
+	alg, err := signatureAlgorithmForKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	req.SignatureAlgorithm = alg
+
+	hasSKI := false
+	for _, ext := range req.ExtraExtensions {
+		hasSKI = hasSKI || ext.Id.Equal(oidSubjectKeyIdentifier)
+	}
+	if !hasSKI {
+		ski, err := cfg.subjectKeyIdentifier(key.Public())
+		if err != nil {
+			return nil, err
+		}
+		req.ExtraExtensions = insertSKI(req.ExtraExtensions, ski)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, req, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate csr: %v", err)
+	}
+	if !cfg.skipSelfCheck {
+		if err := checkCSR(csr); err != nil {
+			return nil, fmt.Errorf("eidas: generated csr failed self-check: %v", err)
+		}
+	}
+	if err := cfg.reportKeyPin(key.Public(), req.ExtraExtensions); err != nil {
+		return nil, fmt.Errorf("eidas: %v", err)
+	}
+	return csr, nil
+}
+
+// checkCSR checks that a DER encoded CSR parses and is correctly signed.
+func checkCSR(der []byte) error {
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return err
+	}
+	return csr.CheckSignature()
+}
+
+// NewCSRTemplate validates its arguments and builds the template GenerateCSR
+// signs, without generating a key. The template lacks the
+// subjectKeyIdentifier extension, which depends on the key and is inserted
+// before the QCStatements extension when the CSR is signed.
+func NewCSRTemplate(
+	countryCode string, orgName string, orgID string, commonName string, roles []qcstatements.Role, qcType asn1.ObjectIdentifier, opts ...CertificateOption) (*x509.CertificateRequest, error) {
+	cfg := newCSRConfig(opts)
+	ca, inputErrs := validateCSRInput(countryCode, orgName, orgID, commonName, roles, qcType, cfg)
+	if len(inputErrs) != 0 {
+		return nil, inputErrs
+	}
+
+	qc, err := qcstatements.Serialize(roles, *ca, qcType, cfg.qcOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("eidas: %v", err)
+	}
+	if cfg.strict {
+		check := qcstatements.CheckMandatory
+		if cfg.nonPSD2 {
+			check = qcstatements.CheckMandatoryQualified
+		}
+		if err := check(qc, cfg.requirePDS); err != nil {
+			return nil, fmt.Errorf("eidas: %v", err)
+		}
+	}
+
+	keyUsage, err := keyUsageForType(qcType)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.keyEncipherment {
+		keyUsage = append(keyUsage, x509.KeyUsageKeyEncipherment)
+	}
+	extendedKeyUsage, err := cfg.extendedKeyUsage(qcType)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.strictRole {
+		roleProblems, usageProblems := cfg.roleProblems(roles, qcType, extendedKeyUsage)
+		for _, problem := range append(roleProblems, usageProblems...) {
+			cfg.warn(problem)
+		}
+	}
 
 	extensions := []pkix.Extension{
 		keyUsageExtension(keyUsage),
@@ -59,11 +678,32 @@ func GenerateCSR(
 	if len(extendedKeyUsage) != 0 {
 		extensions = append(extensions, extendedKeyUsageExtension(extendedKeyUsage))
 	}
-	extensions = append(extensions, subjectKeyIdentifier(key.PublicKey), qcStatementsExtension(qc))
+	extensions = append(extensions, qcStatementsExtension(qc))
+	if cfg.cabfOrgID != nil {
+		ext, err := cabfOrganizationIdentifierExtension(*cfg.cabfOrgID)
+		if err != nil {
+			return nil, fmt.Errorf("eidas: %v", err)
+		}
+		extensions = append(extensions, ext)
+	}
+	if len(cfg.directoryAttributes) != 0 {
+		ext, err := subjectDirectoryAttributesExtension(cfg.directoryAttributes)
+		if err != nil {
+			return nil, fmt.Errorf("eidas: %v", err)
+		}
+		extensions = append(extensions, ext)
+	}
+	if len(cfg.policies) != 0 {
+		ext, err := certificatePoliciesExtension(cfg.policies)
+		if err != nil {
+			return nil, fmt.Errorf("eidas: %v", err)
+		}
+		extensions = append(extensions, ext)
+	}
 
-	subject, err := buildSubject(countryCode, orgName, commonName, orgID)
+	subject, err := buildSubject(countryCode, orgName, cfg.orgNameEncoding, cfg.organizationalUnits, commonName, orgID, cfg.serialNumber, cfg.subjectOrder)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to build CSR subject: %v", err)
+		return nil, fmt.Errorf("failed to build CSR subject: %v", err)
 	}
 	req := &x509.CertificateRequest{
 		Version:            0,
@@ -72,88 +712,389 @@ func GenerateCSR(
 		PublicKeyAlgorithm: x509.RSA,
 		ExtraExtensions:    extensions,
 	}
-	for _, opt := range opts {
+	for _, opt := range cfg.reqOptions {
 		opt(req)
 	}
-	csr, err := x509.CreateCertificateRequest(rand.Reader, req, key)
+	if hasSANs(req) && cfg.criticalFor(oidSubjectAltName, false) {
+		// crypto/x509 only marks the subjectAltName extension it builds
+		// non-critical, so build it here, first as crypto/x509 would.
+		san, err := subjectAltNameExtension(req)
+		if err != nil {
+			return nil, fmt.Errorf("eidas: %v", err)
+		}
+		req.ExtraExtensions = append([]pkix.Extension{san}, req.ExtraExtensions...)
+	}
+	if err := overrideCritical(req.ExtraExtensions, cfg.critical); err != nil {
+		return nil, fmt.Errorf("eidas: %v", err)
+	}
+	return req, nil
+}
+
+// extendedKeyUsage returns the extended key usages to request for qcType:
+// those set by WithExtendedKeyUsage, or else the defaults for the type.
+func (c *csrConfig) extendedKeyUsage(qcType asn1.ObjectIdentifier) ([]asn1.ObjectIdentifier, error) {
+	if c.extKeyUsageSet {
+		return c.extKeyUsage, nil
+	}
+	return extendedKeyUsageForType(qcType)
+}
+
+// roleProblems returns the problems ValidateRolesForType finds with roles,
+// and those with the extended key usages for qcType. Roles are not checked
+// for a non-PSD2 certificate.
+func (c *csrConfig) roleProblems(roles []qcstatements.Role, qcType asn1.ObjectIdentifier, extKeyUsage []asn1.ObjectIdentifier) ([]string, []string) {
+	var roleProblems []string
+	if !c.nonPSD2 {
+		roleProblems = validateRoles(roles)
+	}
+	return roleProblems, validateUsagesForType(qcType, extKeyUsage)
+}
+
+// validateCSRInput checks the arguments and options of NewCSRTemplate,
+// returning every problem found, and resolves the competent authority of a
+// PSD2 certificate.
+func validateCSRInput(
+	countryCode string, orgName string, orgID string, commonName string, roles []qcstatements.Role, qcType asn1.ObjectIdentifier, cfg *csrConfig) (*qcstatements.CompetentAuthority, InputErrors) {
+	problems := validateSubject(countryCode, orgName, cfg.organizationalUnits, commonName)
+	if _, err := encodeString(orgName, cfg.orgNameEncoding); err != nil {
+		problems.add(FieldOrganizationName, "%v", err)
+	}
+	if !isPrintableString(cfg.serialNumber) {
+		problems.add(FieldSerialNumber, "%q is not a valid PrintableString", cfg.serialNumber)
+	}
+	for _, usage := range cfg.extKeyUsage {
+		if !isKnownExtKeyUsage(usage) {
+			problems.add(FieldExtendedKeyUsage, "unknown extended key usage: %v", usage)
+		}
+	}
+	if _, err := lookupQCType(qcType); err != nil {
+		problems.add(FieldQCType, "%v", err)
+	} else {
+		if cfg.keyEncipherment && !qcType.Equal(qcstatements.QWACType) {
+			problems.add(FieldKeyUsage, "keyEncipherment is only allowed for QWACs")
+		}
+		if cfg.strictRole {
+			extendedKeyUsage, err := cfg.extendedKeyUsage(qcType)
+			if err != nil {
+				problems.add(FieldExtendedKeyUsage, "%v", err)
+			}
+			roleProblems, usageProblems := cfg.roleProblems(roles, qcType, extendedKeyUsage)
+			for _, problem := range roleProblems {
+				problems.add(FieldRoles, "%s", problem)
+			}
+			for _, problem := range usageProblems {
+				problems.add(FieldExtendedKeyUsage, "%s", problem)
+			}
+		}
+	}
+	sans := &x509.CertificateRequest{}
+	for _, opt := range cfg.reqOptions {
+		opt(sans)
+	}
+	for _, problem := range validateSANs(sans, qcType, cfg.allowNonWebSANs) {
+		problems.add(FieldSubjectAltName, "%s", problem)
+	}
+
+	ca := &qcstatements.CompetentAuthority{}
+	if cfg.nonPSD2 {
+		if len(roles) != 0 {
+			problems.add(FieldRoles, "roles given for a non-PSD2 certificate: %v", roles)
+		}
+		if orgID != "" {
+			problems.add(FieldOrganizationID, "organization ID given for a non-PSD2 certificate: %s", orgID)
+		}
+		return ca, problems
+	}
+
+	for _, r := range roles {
+		if r.Code() == 0 {
+			problems.add(FieldRoles, "unknown role: %s", r)
+		}
+	}
+	if id, err := ParseOrganizationID(orgID); err == nil && id.CountryCode != countryCode {
+		problems.add(FieldOrganizationID, "organization ID %s is for country %s, not %s", orgID, id.CountryCode, countryCode)
+	}
+	caField := FieldCountryCode
+	ca, err := cfg.resolver.For(countryCode)
+	if err != nil && cfg.deriveCA {
+		caField = FieldOrganizationID
+		ca, err = deriveCompetentAuthority(orgID, err)
+		if err == nil {
+			cfg.warn(fmt.Sprintf("competent authority %s is not known and has not been verified", ca.ID))
+		}
+	}
+	if err == nil {
+		err = ca.Validate()
+	}
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to generate csr: %v", err)
+		problems.add(caField, "%v", err)
 	}
-	return csr, key, nil
+	return ca, problems
+}
+
+// overrideCritical sets the critical flag of each of exts named in critical,
+// in order. The subjectKeyIdentifier is not in exts, as it is added when the
+// CSR is signed, so its override is applied then.
+func overrideCritical(exts []pkix.Extension, critical []criticalOverride) error {
+	for _, o := range critical {
+		switch {
+		case o.id.Equal(oidKeyUsage) && !o.critical:
+			return fmt.Errorf("KeyUsage must be critical")
+		case o.id.Equal(SubjectDirectoryAttributesExt) && o.critical:
+			return fmt.Errorf("subjectDirectoryAttributes must not be critical")
+		case o.id.Equal(oidSubjectKeyIdentifier):
+			continue
+		}
+		found := false
+		for i := range exts {
+			if exts[i].Id.Equal(o.id) {
+				exts[i].Critical = o.critical
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("cannot override critical flag of extension %v which is not requested", o.id)
+		}
+	}
+	return nil
 }
 
-func keyUsageForType(t asn1.ObjectIdentifier) ([]x509.KeyUsage, error) {
-	if t.Equal(qcstatements.QWACType) {
-		return []x509.KeyUsage{
-			x509.KeyUsageDigitalSignature,
-		}, nil
-	} else if t.Equal(qcstatements.QSEALType) {
-		return []x509.KeyUsage{
-			x509.KeyUsageDigitalSignature,
-			x509.KeyUsageContentCommitment, // Also known as NonRepudiation.
-		}, nil
+func hasSANs(req *x509.CertificateRequest) bool {
+	return len(req.DNSNames) != 0 || len(req.EmailAddresses) != 0 || len(req.IPAddresses) != 0 || len(req.URIs) != 0
+}
+
+// subjectAltNameExtension builds the subjectAltName extension for the SANs of
+// req, encoded as crypto/x509 would: DNS names, then email addresses, IP
+// addresses and URIs.
+func subjectAltNameExtension(req *x509.CertificateRequest) (pkix.Extension, error) {
+	var names []asn1.RawValue
+	add := func(tag int, b []byte) {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, Bytes: b})
+	}
+	for _, name := range req.DNSNames {
+		add(2, []byte(name))
+	}
+	for _, address := range req.EmailAddresses {
+		add(1, []byte(address))
+	}
+	for _, ip := range req.IPAddresses {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		add(7, ip)
+	}
+	for _, uri := range req.URIs {
+		add(6, []byte(uri.String()))
+	}
+	d, err := asn1.Marshal(names)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("failed to marshal subject alternative names: %v", err)
+	}
+	return pkix.Extension{Id: oidSubjectAltName, Value: d}, nil
+}
+
+// validateSANs checks the Subject Alternate Names of req, which may only
+// include URIs and email addresses on a QWAC if allowNonWeb is set, returning
+// a description of each problem found.
+func validateSANs(req *x509.CertificateRequest, qcType asn1.ObjectIdentifier, allowNonWeb bool) []string {
+	var problems []string
+	for _, ip := range req.IPAddresses {
+		if len(ip) != net.IPv4len && len(ip) != net.IPv6len {
+			problems = append(problems, fmt.Sprintf("invalid IP address SAN: %v", ip))
+		} else if ip.IsUnspecified() || ip.IsMulticast() {
+			problems = append(problems, fmt.Sprintf("IP address SAN %v does not identify a host", ip))
+		}
+	}
+	for _, uri := range req.URIs {
+		if uri == nil || !uri.IsAbs() || uri.Host == "" {
+			problems = append(problems, fmt.Sprintf("URI SAN %v must be absolute with a host", uri))
+		}
+	}
+	for _, address := range req.EmailAddresses {
+		if a, err := mail.ParseAddress(address); err != nil || a.Address != address {
+			problems = append(problems, fmt.Sprintf("invalid email address SAN: %q", address))
+		}
+	}
+	if qcType.Equal(qcstatements.QWACType) && !allowNonWeb {
+		if len(req.URIs) != 0 {
+			problems = append(problems, "URI SANs on a QWAC require AllowNonWebSANs")
+		}
+		if len(req.EmailAddresses) != 0 {
+			problems = append(problems, "email address SANs on a QWAC require AllowNonWebSANs")
+		}
 	}
-	return nil, fmt.Errorf("unknown QC type: %v", t)
+	return problems
 }
 
 func keyUsageExtension(usages []x509.KeyUsage) pkix.Extension {
-	x := uint16(0)
+	// Each usage is a flag whose bit index is its named bit in KeyUsage,
+	// with bit 0 the most significant bit of the first byte. DER requires a
+	// named bit list to end at its highest set bit.
+	bitLength := 0
 	for _, usage := range usages {
-		x |= (uint16(1) << (8 - uint(usage)))
+		if i := bits.TrailingZeros(uint(usage)); i+1 > bitLength {
+			bitLength = i + 1
+		}
 	}
-	b := make([]byte, 2)
-	binary.LittleEndian.PutUint16(b, x)
-	bits := asn1.BitString{
+	b := make([]byte, (bitLength+7)/8)
+	for _, usage := range usages {
+		i := bits.TrailingZeros(uint(usage))
+		b[i/8] |= 0x80 >> uint(i%8)
+	}
+	bitString := asn1.BitString{
 		Bytes:     b,
-		BitLength: int(x509.KeyUsageDecipherOnly),
+		BitLength: bitLength,
 	}
-	d, _ := asn1.Marshal(bits)
+	d, _ := asn1.Marshal(bitString)
 	return pkix.Extension{
-		Id:       asn1.ObjectIdentifier{2, 5, 29, 15},
+		Id:       oidKeyUsage,
 		Critical: true,
 		Value:    d,
 	}
 }
 
-func extendedKeyUsageForType(t asn1.ObjectIdentifier) ([]asn1.ObjectIdentifier, error) {
-	if t.Equal(qcstatements.QWACType) {
-		return []asn1.ObjectIdentifier{
-			tLSWWWServerAuthUsage,
-			tLSWWWClientAuthUsage,
-		}, nil
-	} else if t.Equal(qcstatements.QSEALType) {
-		return []asn1.ObjectIdentifier{}, nil
-	}
-	return nil, fmt.Errorf("unknown QC type: %v", t)
-}
-
 var (
 	tLSWWWServerAuthUsage = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}
 	tLSWWWClientAuthUsage = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 2}
 )
 
+// knownExtKeyUsages are the key purposes defined in RFC 5280 section 4.2.1.12.
+var knownExtKeyUsages = []asn1.ObjectIdentifier{
+	{2, 5, 29, 37, 0}, // anyExtendedKeyUsage
+	tLSWWWServerAuthUsage,
+	tLSWWWClientAuthUsage,
+	{1, 3, 6, 1, 5, 5, 7, 3, 3}, // codeSigning
+	{1, 3, 6, 1, 5, 5, 7, 3, 4}, // emailProtection
+	{1, 3, 6, 1, 5, 5, 7, 3, 8}, // timeStamping
+	{1, 3, 6, 1, 5, 5, 7, 3, 9}, // OCSPSigning
+}
+
+func isKnownExtKeyUsage(usage asn1.ObjectIdentifier) bool {
+	return containsOID(knownExtKeyUsages, usage)
+}
+
 func extendedKeyUsageExtension(usages []asn1.ObjectIdentifier) pkix.Extension {
 	d, _ := asn1.Marshal(usages)
 
 	return pkix.Extension{
-		Id:       asn1.ObjectIdentifier{2, 5, 29, 37},
+		Id:       oidExtKeyUsage,
 		Critical: false,
 		Value:    d,
 	}
 }
 
-func subjectKeyIdentifier(key rsa.PublicKey) pkix.Extension {
-	b := sha1.Sum(x509.MarshalPKCS1PublicKey(&key))
-	d, err := asn1.Marshal(b[:])
+var oidSubjectKeyIdentifier = asn1.ObjectIdentifier{2, 5, 29, 14}
+var oidKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 15}
+var oidExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+// signatureAlgorithmForKey chooses the signature algorithm for a CSR signed
+// by a key with the given public key.
+func signatureAlgorithmForKey(pub crypto.PublicKey) (x509.SignatureAlgorithm, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return x509.SHA256WithRSA, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return x509.ECDSAWithSHA256, nil
+		case elliptic.P384():
+			return x509.ECDSAWithSHA384, nil
+		case elliptic.P521():
+			return x509.ECDSAWithSHA512, nil
+		}
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported ECDSA curve: %s", pub.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return x509.PureEd25519, nil
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported key type: %T", pub)
+}
+
+// SKIMethod selects how a subjectKeyIdentifier is derived from a public key.
+// Each method hashes the value of the subjectPublicKey BIT STRING.
+type SKIMethod int
+
+const (
+	// SKIMethodSHA1 is the SHA-1 hash, method (1) of RFC 5280 section
+	// 4.2.1.2. It is the most widely supported.
+	SKIMethodSHA1 SKIMethod = iota
+	// SKIMethodSHA256 is the leftmost 160 bits of the SHA-256 hash, method
+	// (1) of RFC 7093 section 2, for CAs that reject SHA-1.
+	SKIMethodSHA256
+)
+
+// subjectKeyIdentifier builds the subjectKeyIdentifier extension for pub
+// using method m.
+func subjectKeyIdentifier(pub crypto.PublicKey, m SKIMethod) (pkix.Extension, error) {
+	id, err := keyIdentifier(pub, m)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	d, err := asn1.Marshal(id)
 	if err != nil {
-		log.Fatalf("failed to marshal subject key identifier: %v", err)
+		return pkix.Extension{}, fmt.Errorf("failed to marshal subject key identifier: %v", err)
 	}
 
 	return pkix.Extension{
-		Id:       asn1.ObjectIdentifier{2, 5, 29, 14},
+		Id:       oidSubjectKeyIdentifier,
 		Critical: false,
 		Value:    d,
+	}, nil
+}
+
+// insertSKI returns exts with the subjectKeyIdentifier extension ski inserted
+// before the QCStatements extension, giving the order KeyUsage, extended key
+// usage, subjectKeyIdentifier, QCStatements that GenerateCSR has always used.
+// Without a QCStatements extension ski is appended.
+func insertSKI(exts []pkix.Extension, ski pkix.Extension) []pkix.Extension {
+	out := make([]pkix.Extension, 0, len(exts)+1)
+	inserted := false
+	for _, ext := range exts {
+		if !inserted && ext.Id.Equal(QCStatementsExt) {
+			out = append(out, ski)
+			inserted = true
+		}
+		out = append(out, ext)
 	}
+	if !inserted {
+		out = append(out, ski)
+	}
+	return out
+}
+
+// subjectKeyIdentifier builds the subjectKeyIdentifier extension for pub with
+// the configured SKIMethod and critical flag.
+func (c *csrConfig) subjectKeyIdentifier(pub crypto.PublicKey) (pkix.Extension, error) {
+	ski, err := subjectKeyIdentifier(pub, c.skiMethod)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	ski.Critical = c.criticalFor(oidSubjectKeyIdentifier, false)
+	return ski, nil
+}
+
+// keyIdentifier derives a key identifier from the subjectPublicKey bit string
+// of pub.
+func keyIdentifier(pub crypto.PublicKey, m SKIMethod) ([]byte, error) {
+	spki, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %v", err)
+	}
+	var info struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(spki, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode public key: %v", err)
+	}
+	switch m {
+	case SKIMethodSHA1:
+		b := sha1.Sum(info.PublicKey.Bytes)
+		return b[:], nil
+	case SKIMethodSHA256:
+		b := sha256.Sum256(info.PublicKey.Bytes)
+		return b[:20], nil
+	}
+	return nil, fmt.Errorf("unknown subject key identifier method: %d", m)
 }
 
 // QCStatementsExt represents the qcstatements x509 extension id.
@@ -169,30 +1110,198 @@ func qcStatementsExtension(data []byte) pkix.Extension {
 
 var oidCountryCode = asn1.ObjectIdentifier{2, 5, 4, 6}
 var oidOrganizationName = asn1.ObjectIdentifier{2, 5, 4, 10}
+var oidOrganizationalUnit = asn1.ObjectIdentifier{2, 5, 4, 11}
 var oidOrganizationID = asn1.ObjectIdentifier{2, 5, 4, 97}
 var oidCommonName = asn1.ObjectIdentifier{2, 5, 4, 3}
+var oidSerialNumber = asn1.ObjectIdentifier{2, 5, 4, 5}
 
-// Explicitly build subject from attributes to keep ordering.
-func buildSubject(countryCode string, orgName string, commonName string, orgID string) ([]byte, error) {
-	s := pkix.Name{
-		ExtraNames: []pkix.AttributeTypeAndValue{
-			{
-				Type:  oidCountryCode,
-				Value: countryCode,
-			},
-			{
-				Type:  oidOrganizationName,
-				Value: orgName,
-			},
-			{
-				Type:  oidOrganizationID,
-				Value: orgID,
-			},
-			{
-				Type:  oidCommonName,
-				Value: commonName,
-			},
+// Upper bounds on subject attribute lengths, in characters, from X.520.
+const (
+	ubOrganizationName   = 64
+	ubOrganizationalUnit = 64
+	ubCommonName         = 64
+	countryCodeLength    = 2
+)
+
+func validateSubject(countryCode string, orgName string, orgUnits []string, commonName string) InputErrors {
+	var problems InputErrors
+	if n := utf8.RuneCountInString(countryCode); n != countryCodeLength {
+		problems.add(FieldCountryCode, "country code %q must be exactly %d characters, got %d", countryCode, countryCodeLength, n)
+	}
+	if n := utf8.RuneCountInString(orgName); n > ubOrganizationName {
+		problems.add(FieldOrganizationName, "organization name must be at most %d characters, got %d", ubOrganizationName, n)
+	}
+	for _, ou := range orgUnits {
+		if n := utf8.RuneCountInString(ou); n > ubOrganizationalUnit {
+			problems.add(FieldOrganizationalUnit, "organizational unit %q must be at most %d characters, got %d", ou, ubOrganizationalUnit, n)
+		}
+	}
+	if n := utf8.RuneCountInString(commonName); n > ubCommonName {
+		problems.add(FieldCommonName, "common name must be at most %d characters, got %d", ubCommonName, n)
+	}
+	return problems
+}
+
+// isPrintableString reports whether s only contains characters allowed in an
+// ASN.1 PrintableString.
+func isPrintableString(s string) bool {
+	for _, c := range s {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.ContainsRune(" '()+,-./:=?", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Explicitly build subject from attributes to keep ordering. Each
+// organizationalUnitName is a separate RDN following the organizationName. The
+// organizationIdentifier is left out if orgID is empty, and the serialNumber
+// follows the commonName if set. The organizationName has the string type
+// orgNameEncoding. If order is set the attributes are sorted into it.
+func buildSubject(countryCode string, orgName string, orgNameEncoding StringEncoding, orgUnits []string, commonName string, orgID string, serialNumber string, order []asn1.ObjectIdentifier) ([]byte, error) {
+	orgNameValue, err := encodeString(orgName, orgNameEncoding)
+	if err != nil {
+		return nil, fmt.Errorf("organization name: %v", err)
+	}
+	names := []pkix.AttributeTypeAndValue{
+		{
+			Type:  oidCountryCode,
+			Value: countryCode,
+		},
+		{
+			Type:  oidOrganizationName,
+			Value: orgNameValue,
 		},
 	}
+	for _, ou := range orgUnits {
+		names = append(names, pkix.AttributeTypeAndValue{
+			Type:  oidOrganizationalUnit,
+			Value: ou,
+		})
+	}
+	if orgID != "" {
+		names = append(names, pkix.AttributeTypeAndValue{
+			Type:  oidOrganizationID,
+			Value: orgID,
+		})
+	}
+	names = append(names, pkix.AttributeTypeAndValue{
+		Type:  oidCommonName,
+		Value: commonName,
+	})
+	if serialNumber != "" {
+		names = append(names, pkix.AttributeTypeAndValue{
+			Type:  oidSerialNumber,
+			Value: serialNumber,
+		})
+	}
+	if order != nil {
+		if names, err = orderNames(names, order); err != nil {
+			return nil, err
+		}
+	}
+	s := pkix.Name{
+		ExtraNames: names,
+	}
 	return asn1.Marshal(s.ToRDNSequence())
 }
+
+// orderNames sorts names by the position of their type in order, keeping the
+// order of names of the same type.
+func orderNames(names []pkix.AttributeTypeAndValue, order []asn1.ObjectIdentifier) ([]pkix.AttributeTypeAndValue, error) {
+	for i, t := range order {
+		if containsOID(order[:i], t) {
+			return nil, fmt.Errorf("subject order has %v more than once", t)
+		}
+	}
+	ordered := make([]pkix.AttributeTypeAndValue, 0, len(names))
+	for _, t := range order {
+		for _, n := range names {
+			if n.Type.Equal(t) {
+				ordered = append(ordered, n)
+			}
+		}
+	}
+	if len(ordered) != len(names) {
+		for _, n := range names {
+			if !containsOID(order, n.Type) {
+				return nil, fmt.Errorf("subject order does not include %v", n.Type)
+			}
+		}
+	}
+	return ordered, nil
+}
+
+// VerifyCSRKey checks that a DER encoded CSR was signed by key: its signature
+// must be valid and its public key must match that of key.
+func VerifyCSRKey(csrDER []byte, key crypto.Signer) error {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return fmt.Errorf("failed to parse csr: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return fmt.Errorf("invalid csr signature: %v", err)
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return fmt.Errorf("unsupported key type: %T", key)
+	}
+	if !pub.Equal(csr.PublicKey) {
+		return fmt.Errorf("csr public key does not match private key")
+	}
+	return nil
+}
+
+// Attribute types that can carry requested extensions in a CSR: the PKCS#9
+// extensionRequest, which crypto/x509 parses into Extensions, and Microsoft's
+// certificate extensions attribute, which it does not.
+var (
+	oidExtensionRequest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 14}
+	oidMSCertExtensions = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 14}
+)
+
+type csrAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// FindCSRQCStatements returns the value of the QCStatements extension of a
+// CSR, wherever the software that built it put it: in the requested
+// extensions, in a Microsoft certificate extensions attribute, or directly in
+// an attribute of type QCStatementsExt.
+func FindCSRQCStatements(csr *x509.CertificateRequest) ([]byte, error) {
+	if qc := findQCStatements(csr.Extensions); qc != nil {
+		return qc, nil
+	}
+
+	var tbs struct {
+		Version    int
+		Subject    asn1.RawValue
+		PublicKey  asn1.RawValue
+		Attributes []csrAttribute `asn1:"tag:0"`
+	}
+	if _, err := asn1.Unmarshal(csr.RawTBSCertificateRequest, &tbs); err != nil {
+		return nil, fmt.Errorf("eidas: failed to decode csr attributes: %v", err)
+	}
+	for _, attr := range tbs.Attributes {
+		if len(attr.Values) == 0 {
+			continue
+		}
+		switch {
+		case attr.Type.Equal(QCStatementsExt):
+			return attr.Values[0].FullBytes, nil
+		case attr.Type.Equal(oidExtensionRequest), attr.Type.Equal(oidMSCertExtensions):
+			var exts []pkix.Extension
+			if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &exts); err != nil {
+				return nil, fmt.Errorf("eidas: failed to decode %v attribute: %v", attr.Type, err)
+			}
+			if qc := findQCStatements(exts); qc != nil {
+				return qc, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("eidas: no QCStatements in csr extensions or attributes")
+}
@@ -1,10 +1,22 @@
 package eidas
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/creditkudos/eidas/qcstatements"
@@ -29,6 +41,188 @@ func TestKeyUsage(t *testing.T) {
 	})
 }
 
+func TestExtensionCritical(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	critical := func(csr []byte) map[string]bool {
+		req, err := x509.ParseCertificateRequest(csr)
+		So(err, ShouldBeNil)
+		m := make(map[string]bool)
+		for _, ext := range req.Extensions {
+			m[ext.Id.String()] = ext.Critical
+		}
+		return m
+	}
+
+	Convey("defaults", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		c := critical(data)
+		So(c[oidKeyUsage.String()], ShouldBeTrue)
+		So(c[oidExtKeyUsage.String()], ShouldBeFalse)
+		So(c[QCStatementsExt.String()], ShouldBeFalse)
+	})
+
+	Convey("overridden", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+			WithExtensionCritical(oidExtKeyUsage, true),
+			WithExtensionCritical(QCStatementsExt, true),
+			WithExtensionCritical(oidKeyUsage, true))
+		So(err, ShouldBeNil)
+		c := critical(data)
+		So(c[oidKeyUsage.String()], ShouldBeTrue)
+		So(c[oidExtKeyUsage.String()], ShouldBeTrue)
+		So(c[QCStatementsExt.String()], ShouldBeTrue)
+	})
+
+	Convey("non-critical KeyUsage", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+			WithExtensionCritical(oidKeyUsage, false))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("critical subjectDirectoryAttributes", t, func() {
+		attr, err := NewDirectoryAttribute(asn1.ObjectIdentifier{2, 5, 4, 97}, "NTRGB-12345678")
+		So(err, ShouldBeNil)
+		_, _, err = GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+			WithSubjectDirectoryAttributes(attr), WithExtensionCritical(SubjectDirectoryAttributesExt, true))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("extension that is not requested", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QSEALType,
+			WithExtensionCritical(oidExtKeyUsage, true))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("subjectKeyIdentifier and subjectAltName", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+			WithDNSName("example.com"), WithIPAddress(net.ParseIP("192.0.2.1")),
+			WithExtensionCritical(oidSubjectKeyIdentifier, true),
+			WithExtensionCritical(oidSubjectAltName, true))
+		So(err, ShouldBeNil)
+		c := critical(data)
+		So(c[oidSubjectKeyIdentifier.String()], ShouldBeTrue)
+		So(c[oidSubjectAltName.String()], ShouldBeTrue)
+
+		req, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+		So(req.Extensions[0].Id, ShouldResemble, oidSubjectAltName)
+		So(req.DNSNames, ShouldResemble, []string{"example.com"})
+		So(req.IPAddresses[0].Equal(net.ParseIP("192.0.2.1")), ShouldBeTrue)
+	})
+
+	Convey("subjectAltName without SANs", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+			WithExtensionCritical(oidSubjectAltName, true))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("the first of several unrequested extensions is reported", t, func() {
+		for i := 0; i < 10; i++ {
+			_, err := NewCSRTemplate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+				WithExtensionCritical(asn1.ObjectIdentifier{1, 2, 3}, true),
+				WithExtensionCritical(asn1.ObjectIdentifier{1, 2, 4}, true),
+				WithExtensionCritical(asn1.ObjectIdentifier{1, 2, 5}, true))
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "extension 1.2.3 ")
+		}
+	})
+}
+
+func TestKeyEncipherment(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	keyUsage := func(csr []byte) x509.KeyUsage {
+		cert, err := ReadCertificate(issueCertificate(t, csr, nil))
+		So(err, ShouldBeNil)
+		return cert.KeyUsage
+	}
+
+	Convey("QWAC defaults to digitalSignature only", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		So(keyUsage(data), ShouldEqual, x509.KeyUsageDigitalSignature)
+	})
+
+	Convey("QWAC with keyEncipherment", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithKeyEncipherment())
+		So(err, ShouldBeNil)
+		So(keyUsage(data), ShouldEqual, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment)
+	})
+
+	Convey("QSEAL with keyEncipherment", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QSEALType, WithKeyEncipherment())
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("ECDSA key with keyEncipherment", t, func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		_, err = GenerateCSRWithSigner(key, "GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithKeyEncipherment())
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestExtensionOrder(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	ids := func(data []byte) []asn1.ObjectIdentifier {
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+		var ids []asn1.ObjectIdentifier
+		for _, ext := range csr.Extensions {
+			ids = append(ids, ext.Id)
+		}
+		return ids
+	}
+
+	Convey("QWAC", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		So(ids(data), ShouldResemble, []asn1.ObjectIdentifier{oidKeyUsage, oidExtKeyUsage, oidSubjectKeyIdentifier, QCStatementsExt})
+	})
+
+	Convey("QSEAL", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QSEALType)
+		So(err, ShouldBeNil)
+		So(ids(data), ShouldResemble, []asn1.ObjectIdentifier{oidKeyUsage, oidSubjectKeyIdentifier, QCStatementsExt})
+	})
+}
+
+func TestKeyUsageEncoding(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	keyUsageExt := func(qcType asn1.ObjectIdentifier, opts ...CertificateOption) pkix.Extension {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcType, opts...)
+		So(err, ShouldBeNil)
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+		for _, ext := range csr.Extensions {
+			if ext.Id.Equal(oidKeyUsage) {
+				return ext
+			}
+		}
+		t.Fatal("no key usage extension")
+		return pkix.Extension{}
+	}
+
+	for _, c := range []struct {
+		name   string
+		qcType asn1.ObjectIdentifier
+		opts   []CertificateOption
+		want   []byte
+	}{
+		{"QWAC", qcstatements.QWACType, nil, []byte{0x03, 0x02, 0x07, 0x80}},
+		{"QWAC with keyEncipherment", qcstatements.QWACType, []CertificateOption{WithKeyEncipherment()}, []byte{0x03, 0x02, 0x05, 0xa0}},
+		{"QSEAL", qcstatements.QSEALType, nil, []byte{0x03, 0x02, 0x06, 0xc0}},
+		{"ESIGN", qcstatements.ESignType, nil, []byte{0x03, 0x02, 0x06, 0x40}},
+	} {
+		c := c
+		Convey(c.name+" key usage is DER encoded", t, func() {
+			ext := keyUsageExt(c.qcType, c.opts...)
+			So(ext.Value, ShouldResemble, c.want)
+			So(ext.Critical, ShouldBeTrue)
+		})
+	}
+}
+
 func TestExtendedKeyUsage(t *testing.T) {
 	Convey("extended key usage for QWAC", t, func() {
 		usage, err := extendedKeyUsageForType(qcstatements.QWACType)
@@ -95,6 +289,407 @@ func TestBuildCSR(t *testing.T) {
 	})
 }
 
+func TestNewCSRTemplate(t *testing.T) {
+	Convey("template for QWAC", t, func() {
+		req, err := NewCSRTemplate("GB", "Foo Org", "Foo Org ID", "Foo Name", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QWACType, WithDNSName("foo.example.com"))
+		So(err, ShouldBeNil)
+		So(req.DNSNames, ShouldResemble, []string{"foo.example.com"})
+		So(req.ExtraExtensions, shouldContainID, QCStatementsExt)
+		So(req.ExtraExtensions, shouldNotContainID, asn1.ObjectIdentifier{2, 5, 29, 14})
+	})
+
+	Convey("template with invalid input", t, func() {
+		_, err := NewCSRTemplate("XX", "Foo Org", "Foo Org ID", "Foo Name", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QWACType)
+		So(err, ShouldNotBeNil)
+		_, err = NewCSRTemplate("GB", "Foo Org", "Foo Org ID", "Foo Name", []qcstatements.Role{"PSP_XX"}, qcstatements.QWACType)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestInputErrors(t *testing.T) {
+	Convey("every bad input is reported", t, func() {
+		_, _, err := GenerateCSR("GBR", "Foo Org", "PSDIE-CBI-123456", strings.Repeat("a", 65), []qcstatements.Role{"PSP_XX"}, asn1.ObjectIdentifier{1, 2, 3},
+			WithSerialNumber("#1"))
+		So(err, ShouldNotBeNil)
+		inputErrs, ok := err.(InputErrors)
+		So(ok, ShouldBeTrue)
+		var fields []string
+		for _, e := range inputErrs {
+			fields = append(fields, e.Field)
+		}
+		So(fields, ShouldResemble, []string{
+			FieldCountryCode,
+			FieldCommonName,
+			FieldSerialNumber,
+			FieldQCType,
+			FieldRoles,
+			FieldOrganizationID,
+			FieldCountryCode,
+		})
+		So(err.Error(), ShouldStartWith, "eidas: invalid input: country_code: ")
+	})
+
+	Convey("non-PSD2 certificate with roles and an organization ID", t, func() {
+		_, err := NewCSRTemplate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QSEALType,
+			WithoutPSD2(), WithKeyEncipherment())
+		So(err, ShouldHaveSameTypeAs, InputErrors{})
+		So(err.(InputErrors), ShouldHaveLength, 3)
+		So(err.(InputErrors)[0].Field, ShouldEqual, FieldKeyUsage)
+	})
+
+	Convey("invalid SAN", t, func() {
+		_, err := NewCSRTemplate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QWACType,
+			WithEmailAddress("foo@example.com"))
+		So(err, ShouldHaveSameTypeAs, InputErrors{})
+		So(err.(InputErrors)[0].Field, ShouldEqual, FieldSubjectAltName)
+	})
+
+	Convey("option errors are reported with the other inputs", t, func() {
+		resolver := qcstatements.CompetentAuthorityMap{"GB": {Name: "Financial Conduct Authority", ID: "gb-fca"}}
+		ai := qcstatements.RoleAccountInformation
+		_, err := NewCSRTemplate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", []qcstatements.Role{ai, ai}, qcstatements.QSEALType,
+			StrictRoles(),
+			WithCompetentAuthorityResolver(resolver),
+			WithExtendedKeyUsage(tLSWWWServerAuthUsage, asn1.ObjectIdentifier{1, 2, 3}),
+			WithIPAddress(net.IPv4zero),
+			WithURI(&url.URL{Path: "relative"}))
+		So(err, ShouldHaveSameTypeAs, InputErrors{})
+		var fields, messages []string
+		for _, e := range err.(InputErrors) {
+			fields = append(fields, e.Field)
+			messages = append(messages, e.Message)
+		}
+		So(fields, ShouldResemble, []string{
+			FieldExtendedKeyUsage,
+			FieldRoles,
+			FieldExtendedKeyUsage,
+			FieldSubjectAltName,
+			FieldSubjectAltName,
+			FieldCountryCode,
+		})
+		So(messages[0], ShouldEqual, "unknown extended key usage: 1.2.3")
+		So(messages[1], ShouldEqual, "role PSP_AI given more than once")
+		So(messages[2], ShouldContainSubstring, "QSEAL has TLS extended key usage")
+		So(messages[5], ShouldContainSubstring, "gb-fca")
+	})
+}
+
+func TestOrganizationIDCountry(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+
+	Convey("organization ID for the subject country", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("organization ID for another country", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "PSDIE-CBI-123456", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "is for country IE, not GB")
+	})
+}
+
+func TestSerialNumber(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+
+	Convey("CSR with serial number", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QSEALType, WithSerialNumber("HSM-0042"))
+		So(err, ShouldBeNil)
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+		So(csr.Subject.SerialNumber, ShouldEqual, "HSM-0042")
+
+		names := csr.Subject.Names
+		So(names, ShouldHaveLength, 5)
+		So(names[3].Type, ShouldEqual, oidCommonName)
+		So(names[4].Type, ShouldEqual, oidSerialNumber)
+
+		var raw struct {
+			Type  asn1.ObjectIdentifier
+			Value asn1.RawValue
+		}
+		_, err = asn1.Unmarshal(rawRDN(t, csr.RawSubject, 4), &raw)
+		So(err, ShouldBeNil)
+		So(raw.Value.Tag, ShouldEqual, asn1.TagPrintableString)
+	})
+
+	Convey("serial number that is not printable", t, func() {
+		_, err := NewCSRTemplate("GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QSEALType, WithSerialNumber("HSM_0042"))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// rawRDN returns the encoding of the single attribute in the i'th RDN of a
+// subject.
+func rawRDN(t *testing.T, subject []byte, i int) []byte {
+	var rdns []asn1.RawValue
+	if _, err := asn1.Unmarshal(subject, &rdns); err != nil {
+		t.Fatal(err)
+	}
+	var attrs []asn1.RawValue
+	if _, err := asn1.UnmarshalWithParams(rdns[i].FullBytes, &attrs, "set"); err != nil {
+		t.Fatal(err)
+	}
+	return attrs[0].FullBytes
+}
+
+func TestSubjectLengths(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+
+	Convey("maximum length attributes", t, func() {
+		_, err := NewCSRTemplate("GB", strings.Repeat("é", 64), "Foo Org ID", strings.Repeat("a", 64), roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("organization name too long", t, func() {
+		_, err := NewCSRTemplate("GB", strings.Repeat("a", 65), "Foo Org ID", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "organization name")
+	})
+
+	Convey("common name too long", t, func() {
+		_, err := NewCSRTemplate("GB", "Foo Org", "Foo Org ID", strings.Repeat("a", 65), roles, qcstatements.QWACType)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "common name")
+	})
+
+	Convey("organizational unit too long", t, func() {
+		_, err := NewCSRTemplate("GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QWACType, WithOrganizationalUnit(strings.Repeat("a", 65)))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("country code of the wrong length", t, func() {
+		_, err := NewCSRTemplate("GBR", "Foo Org", "", "Foo Name", nil, qcstatements.QWACType, WithoutPSD2())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "country code")
+	})
+}
+
+func TestOrganizationNameEncoding(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	orgNameTag := func(orgName string, opts ...CertificateOption) int {
+		req, err := NewCSRTemplate("FR", orgName, "", "Foo Name", roles, qcstatements.QWACType, opts...)
+		So(err, ShouldBeNil)
+		attrs, err := ParseSubject(req.RawSubject)
+		So(err, ShouldBeNil)
+		for _, a := range attrs {
+			if a.Type.Equal(oidOrganizationName) {
+				So(a.Value, ShouldEqual, orgName)
+				return a.Tag
+			}
+		}
+		t.Fatal("no organizationName")
+		return 0
+	}
+
+	Convey("ASCII name is a PrintableString", t, func() {
+		So(orgNameTag("Foo Org"), ShouldEqual, asn1.TagPrintableString)
+	})
+
+	Convey("accented name is a UTF8String", t, func() {
+		So(orgNameTag("Société Générale"), ShouldEqual, asn1.TagUTF8String)
+	})
+
+	Convey("name with characters PrintableString lacks is a UTF8String", t, func() {
+		So(orgNameTag("Foo & Bar"), ShouldEqual, asn1.TagUTF8String)
+	})
+
+	Convey("forced UTF8String", t, func() {
+		So(orgNameTag("Foo Org", WithOrganizationNameEncoding(StringEncodingUTF8)), ShouldEqual, asn1.TagUTF8String)
+	})
+
+	Convey("forced PrintableString", t, func() {
+		So(orgNameTag("Foo Org", WithOrganizationNameEncoding(StringEncodingPrintable)), ShouldEqual, asn1.TagPrintableString)
+		_, err := NewCSRTemplate("FR", "Société Générale", "", "Foo Name", roles, qcstatements.QWACType, WithOrganizationNameEncoding(StringEncodingPrintable))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestOrganizationalUnits(t *testing.T) {
+	Convey("CSR with organizational units", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QWACType, WithOrganizationalUnit("Payments"), WithOrganizationalUnit("0123"))
+		So(err, ShouldBeNil)
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+		So(csr.Subject.OrganizationalUnit, ShouldResemble, []string{"Payments", "0123"})
+
+		names := csr.Subject.Names
+		So(names, ShouldHaveLength, 6)
+		So(names[1].Type, ShouldEqual, oidOrganizationName)
+		So(names[2].Type, ShouldEqual, oidOrganizationalUnit)
+		So(names[2].Value, ShouldEqual, "Payments")
+		So(names[3].Type, ShouldEqual, oidOrganizationalUnit)
+		So(names[3].Value, ShouldEqual, "0123")
+		So(names[4].Type, ShouldEqual, oidOrganizationID)
+		So(names[5].Type, ShouldEqual, oidCommonName)
+
+		var rdns pkix.RDNSequence
+		_, err = asn1.Unmarshal(csr.RawSubject, &rdns)
+		So(err, ShouldBeNil)
+		So(rdns, ShouldHaveLength, 6)
+	})
+}
+
+func TestWithSubjectOrder(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+
+	Convey("commonName first", t, func() {
+		order := []asn1.ObjectIdentifier{oidCommonName, oidCountryCode, oidOrganizationName, oidOrganizationalUnit, oidOrganizationID, oidSerialNumber}
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithSubjectOrder(order...), WithOrganizationalUnit("Payments"), WithOrganizationalUnit("0123"))
+		So(err, ShouldBeNil)
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+
+		var types []asn1.ObjectIdentifier
+		var values []interface{}
+		for _, n := range csr.Subject.Names {
+			types = append(types, n.Type)
+			values = append(values, n.Value)
+		}
+		So(types, ShouldResemble, []asn1.ObjectIdentifier{oidCommonName, oidCountryCode, oidOrganizationName, oidOrganizationalUnit, oidOrganizationalUnit, oidOrganizationID})
+		So(values, ShouldResemble, []interface{}{"Foo Name", "GB", "Foo Org", "Payments", "0123", "PSDGB-FCA-123456"})
+	})
+
+	Convey("order missing a subject attribute", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithSubjectOrder(oidCommonName, oidCountryCode, oidOrganizationName))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "subject order does not include 2.5.4.97")
+	})
+
+	Convey("order with a repeated type", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithSubjectOrder(oidCommonName, oidCountryCode, oidOrganizationName, oidOrganizationID, oidCommonName))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "more than once")
+	})
+}
+
+func TestStrict(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	pds := qcstatements.PDSLocation{URL: "https://example.com/pds_en.pdf", Language: "en"}
+
+	Convey("strict CSR without QcCompliance", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QWACType, Strict())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "QcCompliance")
+	})
+
+	Convey("strict CSR with QcCompliance", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QWACType, Strict(), WithQcCompliance())
+		So(err, ShouldBeNil)
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+		So(csr.Extensions, shouldContainID, QCStatementsExt)
+	})
+
+	Convey("CSR requiring QcPDS", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QWACType, RequireQcPDS(), WithQcCompliance())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "QcPDS")
+
+		_, _, err = GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QWACType, RequireQcPDS(), WithQcCompliance(), WithQcPDS(pds))
+		So(err, ShouldBeNil)
+	})
+}
+
+func TestCompetentAuthorityResolver(t *testing.T) {
+	Convey("CSR with custom resolver", t, func() {
+		resolver := qcstatements.CompetentAuthorityMap{
+			"GB": {ID: "GB-XYZ", Name: "Some Other Authority"},
+		}
+		data, _, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QWACType, WithCompetentAuthorityResolver(resolver))
+		So(err, ShouldBeNil)
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+		for _, ext := range csr.Extensions {
+			if ext.Id.Equal(QCStatementsExt) {
+				_, caName, caID, err := qcstatements.Extract(ext.Value)
+				So(err, ShouldBeNil)
+				So(caName, ShouldEqual, "Some Other Authority")
+				So(caID, ShouldEqual, "GB-XYZ")
+			}
+		}
+	})
+
+	Convey("CSR with custom resolver missing the country", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QWACType, WithCompetentAuthorityResolver(qcstatements.CompetentAuthorityMap{}))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("CSR with derived competent authority", t, func() {
+		var warnings []string
+		req, err := NewCSRTemplate("GB", "Foo Org", "PSDGB-XYZ-123456", "Foo Name", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QWACType,
+			WithCompetentAuthorityResolver(qcstatements.CompetentAuthorityMap{}),
+			WithDerivedCompetentAuthority(),
+			WithWarningHandler(func(w string) { warnings = append(warnings, w) }))
+		So(err, ShouldBeNil)
+		So(warnings, ShouldHaveLength, 1)
+		So(warnings[0], ShouldContainSubstring, "GB-XYZ")
+		_, caName, caID, err := qcstatements.Extract(findQCStatements(req.ExtraExtensions))
+		So(err, ShouldBeNil)
+		So(caName, ShouldEqual, "")
+		So(caID, ShouldEqual, "GB-XYZ")
+	})
+
+	Convey("CSR with derived competent authority and an unparseable organization ID", t, func() {
+		_, err := NewCSRTemplate("GB", "Foo Org", "Foo Org ID", "Foo Name", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QWACType,
+			WithCompetentAuthorityResolver(qcstatements.CompetentAuthorityMap{}),
+			WithDerivedCompetentAuthority())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "unknown country code")
+	})
+}
+
+func TestWithoutPSD2(t *testing.T) {
+	Convey("non-PSD2 CSR", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "", "foo.example.com", nil, qcstatements.QWACType, WithoutPSD2(), Strict())
+		So(err, ShouldBeNil)
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+
+		names := csr.Subject.Names
+		So(names, ShouldHaveLength, 3)
+		for _, name := range names {
+			So(name.Type.Equal(oidOrganizationID), ShouldBeFalse)
+		}
+
+		qc := findQCStatements(csr.Extensions)
+		So(qc, ShouldNotBeNil)
+		So(qcstatements.CheckMandatoryQualified(qc, false), ShouldBeNil)
+		_, _, _, err = qcstatements.Extract(qc)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("non-PSD2 CSR with roles", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "", "foo.example.com", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QWACType, WithoutPSD2())
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("non-PSD2 CSR with organization ID", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "foo.example.com", nil, qcstatements.QWACType, WithoutPSD2())
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestVerifyCSRKey(t *testing.T) {
+	Convey("CSR signed by key", t, func() {
+		data, key, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		So(VerifyCSRKey(data, key), ShouldBeNil)
+
+		Convey("with a different key", func() {
+			other, err := rsa.GenerateKey(rand.Reader, 1024)
+			So(err, ShouldBeNil)
+			So(VerifyCSRKey(data, other), ShouldNotBeNil)
+		})
+
+		Convey("with a corrupted signature", func() {
+			corrupt := append([]byte(nil), data...)
+			corrupt[len(corrupt)-1] ^= 0xff
+			So(VerifyCSRKey(corrupt, key), ShouldNotBeNil)
+		})
+	})
+}
+
 func shouldContainID(actual interface{}, expected ...interface{}) string {
 	exts, ok := actual.([]pkix.Extension)
 	if !ok {
@@ -111,3 +706,533 @@ func shouldContainID(actual interface{}, expected ...interface{}) string {
 	}
 	return fmt.Sprintf("Expected to find: %v", expected)
 }
+
+func shouldNotContainID(actual interface{}, expected ...interface{}) string {
+	if shouldContainID(actual, expected...) == "" {
+		return fmt.Sprintf("Expected not to find: %v", expected)
+	}
+	return ""
+}
+
+func TestWithExtendedKeyUsage(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	parse := func(opts ...CertificateOption) (*x509.CertificateRequest, error) {
+		data, _, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QWACType, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return x509.ParseCertificateRequest(data)
+	}
+	extKeyUsage := func(csr *x509.CertificateRequest) []asn1.ObjectIdentifier {
+		for _, ext := range csr.Extensions {
+			if ext.Id.Equal(asn1.ObjectIdentifier{2, 5, 29, 37}) {
+				var usages []asn1.ObjectIdentifier
+				_, err := asn1.Unmarshal(ext.Value, &usages)
+				So(err, ShouldBeNil)
+				return usages
+			}
+		}
+		return nil
+	}
+
+	Convey("QWAC with serverAuth only", t, func() {
+		csr, err := parse(WithServerAuthOnly())
+		So(err, ShouldBeNil)
+		So(extKeyUsage(csr), ShouldResemble, []asn1.ObjectIdentifier{tLSWWWServerAuthUsage})
+	})
+
+	Convey("QWAC without extendedKeyUsage", t, func() {
+		csr, err := parse(WithExtendedKeyUsage())
+		So(err, ShouldBeNil)
+		So(extKeyUsage(csr), ShouldBeNil)
+	})
+
+	Convey("QWAC with an unknown extended key usage", t, func() {
+		_, err := parse(WithExtendedKeyUsage(asn1.ObjectIdentifier{1, 2, 3}))
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "unknown extended key usage")
+	})
+
+	Convey("QWAC with clientAuth only is rejected in strict role mode", t, func() {
+		_, err := parse(WithExtendedKeyUsage(tLSWWWClientAuthUsage), StrictRoles())
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "serverAuth")
+	})
+}
+
+func TestRekeyCSR(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation, qcstatements.RolePaymentInitiation}
+	oldDER, oldKey, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithDNSName("example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := x509.ParseCertificateRequest(oldDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("new CSR matches the old one except for the key", t, func() {
+		newDER, newKey, err := RekeyCSR(old)
+		So(err, ShouldBeNil)
+		So(VerifyCSRKey(newDER, newKey), ShouldBeNil)
+		So(newKey.N.Cmp(oldKey.N), ShouldNotEqual, 0)
+
+		csr, err := x509.ParseCertificateRequest(newDER)
+		So(err, ShouldBeNil)
+		So(csr.RawSubject, ShouldResemble, old.RawSubject)
+		So(csr.Extensions, ShouldHaveLength, len(old.Extensions))
+		for i, ext := range old.Extensions {
+			So(csr.Extensions[i].Id, ShouldResemble, ext.Id)
+			if ext.Id.Equal(oidSubjectKeyIdentifier) {
+				So(csr.Extensions[i].Value, ShouldNotResemble, ext.Value)
+			} else {
+				So(csr.Extensions[i].Value, ShouldResemble, ext.Value)
+			}
+		}
+	})
+
+	Convey("SHA-256 subjectKeyIdentifier", t, func() {
+		shaDER, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithSubjectKeyIdentifierMethod(SKIMethodSHA256))
+		So(err, ShouldBeNil)
+		sha, err := x509.ParseCertificateRequest(shaDER)
+		So(err, ShouldBeNil)
+
+		newDER, newKey, err := RekeyCSR(sha)
+		So(err, ShouldBeNil)
+		csr, err := x509.ParseCertificateRequest(newDER)
+		So(err, ShouldBeNil)
+		want, err := subjectKeyIdentifier(newKey.Public(), SKIMethodSHA256)
+		So(err, ShouldBeNil)
+		for i, ext := range sha.Extensions {
+			So(csr.Extensions[i].Id, ShouldResemble, ext.Id)
+			if ext.Id.Equal(oidSubjectKeyIdentifier) {
+				So(csr.Extensions[i].Value, ShouldResemble, want.Value)
+			}
+		}
+	})
+
+	Convey("CSR with an invalid signature", t, func() {
+		tampered := *old
+		tampered.Signature = append([]byte{}, old.Signature...)
+		tampered.Signature[0] ^= 0xff
+		_, _, err := RekeyCSR(&tampered)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestCheckCSR(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	data, _, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("generated CSR", t, func() {
+		So(checkCSR(data), ShouldBeNil)
+	})
+
+	Convey("CSR with a bad signature", t, func() {
+		bad := append([]byte{}, data...)
+		bad[len(bad)-1] ^= 0xff
+		So(checkCSR(bad), ShouldNotBeNil)
+	})
+
+	Convey("truncated CSR", t, func() {
+		So(checkCSR(data[:len(data)/2]), ShouldNotBeNil)
+	})
+
+	Convey("CSR without self-check", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QWACType, WithoutSelfCheck())
+		So(err, ShouldBeNil)
+		So(checkCSR(data), ShouldBeNil)
+	})
+}
+
+func TestRenewCSR(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation, qcstatements.RolePaymentInitiation}
+	cert, key, err := GenerateSelfSignedCertificate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithDNSName("example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := BundlePEM(cert)
+
+	Convey("renewal with the existing key", t, func() {
+		d, renewedKey, err := RenewCSR(cert, key)
+		So(err, ShouldBeNil)
+		So(renewedKey, ShouldEqual, key)
+		So(VerifyIssuedCertificate(d, certPEM), ShouldBeNil)
+
+		csr, err := x509.ParseCertificateRequest(d)
+		So(err, ShouldBeNil)
+		So(csr.DNSNames, ShouldResemble, []string{"example.com"})
+		var ski []byte
+		for _, ext := range csr.Extensions {
+			if ext.Id.Equal(oidSubjectKeyIdentifier) {
+				_, err := asn1.Unmarshal(ext.Value, &ski)
+				So(err, ShouldBeNil)
+			}
+		}
+		So(ski, ShouldResemble, cert.SubjectKeyId)
+	})
+
+	Convey("renewal with a new key", t, func() {
+		d, newKey, err := RenewCSR(cert, nil)
+		So(err, ShouldBeNil)
+		So(newKey.N.Cmp(key.N), ShouldNotEqual, 0)
+		So(VerifyCSRKey(d, newKey), ShouldBeNil)
+
+		csr, err := x509.ParseCertificateRequest(d)
+		So(err, ShouldBeNil)
+		So(csr.RawSubject, ShouldResemble, cert.RawSubject)
+		So(compareQCStatements(findQCStatements(csr.Extensions), findQCStatements(cert.Extensions)), ShouldBeNil)
+	})
+
+	Convey("renewal with the wrong key", t, func() {
+		other, err := rsa.GenerateKey(rand.Reader, 1024)
+		So(err, ShouldBeNil)
+		_, _, err = RenewCSR(cert, other)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestWithAdditionalQCStatements(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	// QcSSCD, 0.4.0.1862.1.4, which has no statementInfo.
+	sscd, err := asn1.Marshal(struct{ OID asn1.ObjectIdentifier }{asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("CSR with an additional statement", t, func() {
+		req, err := NewCSRTemplate("GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QSEALType, WithAdditionalQCStatements(sscd))
+		So(err, ShouldBeNil)
+		statements, err := qcstatements.ExtractRaw(findQCStatements(req.ExtraExtensions))
+		So(err, ShouldBeNil)
+		So(statements[len(statements)-1].Raw, ShouldResemble, sscd)
+	})
+
+	Convey("CSR with a malformed additional statement", t, func() {
+		_, err := NewCSRTemplate("GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QSEALType, WithAdditionalQCStatements([]byte{0x30}))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// remoteSigner hides the private key behind crypto.Signer, as an HSM would.
+type remoteSigner struct {
+	signer crypto.Signer
+}
+
+func (s remoteSigner) Public() crypto.PublicKey {
+	return s.signer.Public()
+}
+
+func (s remoteSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.signer.Sign(rand, digest, opts)
+}
+
+func TestGenerateCSRWithSigner(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		key  crypto.Signer
+		alg  x509.SignatureAlgorithm
+	}{
+		{name: "RSA", key: rsaKey, alg: x509.SHA256WithRSA},
+		{name: "ECDSA", key: ecKey, alg: x509.ECDSAWithSHA384},
+	} {
+		Convey("CSR signed by a remote "+tc.name+" key", t, func() {
+			data, err := GenerateCSRWithSigner(remoteSigner{tc.key}, "GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QSEALType)
+			So(err, ShouldBeNil)
+			So(VerifyCSRKey(data, tc.key), ShouldBeNil)
+			csr, err := x509.ParseCertificateRequest(data)
+			So(err, ShouldBeNil)
+			So(csr.SignatureAlgorithm, ShouldEqual, tc.alg)
+			So(csr.Extensions, shouldContainID, oidSubjectKeyIdentifier)
+		})
+	}
+
+	Convey("CSR signed by an unsupported key", t, func() {
+		p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+		So(err, ShouldBeNil)
+		_, err = GenerateCSRWithSigner(p224, "GB", "Foo Org", "Foo Org ID", "Foo Name", roles, qcstatements.QSEALType)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestConcurrentGenerateCSR(t *testing.T) {
+	Convey("generating CSRs from many goroutines", t, func() {
+		var wg sync.WaitGroup
+		errs := make(chan error, 20)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				countryCode := []string{"GB", "DE", "FR", "NL"}[i%4]
+				_, _, err := GenerateCSR(countryCode, "Foo Org", "PSD"+countryCode+"-NCA-123456", "Foo Name",
+					[]qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QWACType, WithQcCompliance())
+				errs <- err
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			So(err, ShouldBeNil)
+		}
+	})
+}
+
+func TestSubjectKeyIdentifierMethod(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	skiOf := func(data []byte) ([]byte, []byte) {
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+		var ski []byte
+		for _, ext := range csr.Extensions {
+			if ext.Id.Equal(oidSubjectKeyIdentifier) {
+				_, err := asn1.Unmarshal(ext.Value, &ski)
+				So(err, ShouldBeNil)
+			}
+		}
+		var info struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}
+		_, err = asn1.Unmarshal(csr.RawSubjectPublicKeyInfo, &info)
+		So(err, ShouldBeNil)
+		return ski, info.PublicKey.Bytes
+	}
+
+	Convey("defaults to SHA-1", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		ski, pub := skiOf(data)
+		sum := sha1.Sum(pub)
+		So(ski, ShouldResemble, sum[:])
+	})
+
+	Convey("truncated SHA-256", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+			WithSubjectKeyIdentifierMethod(SKIMethodSHA256))
+		So(err, ShouldBeNil)
+		ski, pub := skiOf(data)
+		sum := sha256.Sum256(pub)
+		So(ski, ShouldResemble, sum[:20])
+	})
+
+	Convey("unknown method", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+			WithSubjectKeyIdentifierMethod(SKIMethod(42)))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestGenerateCSRWithRawSubject(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	subject, err := asn1.Marshal(pkix.RDNSequence{
+		{{Type: oidCountryCode, Value: "GB"}},
+		{{Type: oidOrganizationName, Value: asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte("Foo Org")}}},
+		{{Type: oidOrganizationID, Value: "PSDGB-FCA-123456"}},
+		{{Type: oidCommonName, Value: "Foo Name"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("subject is used verbatim", t, func() {
+		data, key, err := GenerateCSRWithRawSubject(subject, roles, qcstatements.QWACType, WithQcCompliance())
+		So(err, ShouldBeNil)
+		So(VerifyCSRKey(data, key), ShouldBeNil)
+
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+		So(csr.RawSubject, ShouldResemble, subject)
+		So(CheckOrganizationIDAuthority(csr.Subject, csr.Extensions), ShouldBeNil)
+	})
+
+	Convey("invalid subject", t, func() {
+		_, _, err := GenerateCSRWithRawSubject(append(append([]byte{}, subject...), 0), roles, qcstatements.QWACType)
+		So(err, ShouldNotBeNil)
+		_, _, err = GenerateCSRWithRawSubject([]byte{0x02, 0x01, 0x00}, roles, qcstatements.QWACType)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("subject without a country code", t, func() {
+		noCountry, err := asn1.Marshal(pkix.RDNSequence{{{Type: oidCommonName, Value: "Foo Name"}}})
+		So(err, ShouldBeNil)
+		_, _, err = GenerateCSRWithRawSubject(noCountry, roles, qcstatements.QWACType)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+// csrWithAttributes builds a CSR carrying attrs rather than the extensions
+// crypto/x509 would encode.
+func csrWithAttributes(t *testing.T, attrs []csrAttribute) *x509.CertificateRequest {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := asn1.Marshal(pkix.Name{CommonName: "Foo Name"}.ToRDNSequence())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbs, err := asn1.Marshal(struct {
+		Version    int
+		Subject    asn1.RawValue
+		PublicKey  asn1.RawValue
+		Attributes []csrAttribute `asn1:"tag:0,set"`
+	}{0, asn1.RawValue{FullBytes: subject}, asn1.RawValue{FullBytes: spki}, attrs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(tbs)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(struct {
+		TBS       asn1.RawValue
+		Algorithm pkix.AlgorithmIdentifier
+		Signature asn1.BitString
+	}{
+		asn1.RawValue{FullBytes: tbs},
+		pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, Parameters: asn1.NullRawValue},
+		asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+func TestFindCSRQCStatements(t *testing.T) {
+	qc, err := qcstatements.SerializeForCountry([]qcstatements.Role{qcstatements.RoleAccountInformation}, "GB", qcstatements.QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exts, err := asn1.Marshal([]pkix.Extension{qcStatementsExtension(qc)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("in the requested extensions", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+		found, err := FindCSRQCStatements(csr)
+		So(err, ShouldBeNil)
+		So(found, ShouldResemble, qc)
+	})
+
+	Convey("in a Microsoft certificate extensions attribute", t, func() {
+		csr := csrWithAttributes(t, []csrAttribute{
+			{Type: oidMSCertExtensions, Values: []asn1.RawValue{{FullBytes: exts}}},
+		})
+		So(findQCStatements(csr.Extensions), ShouldBeNil)
+		found, err := FindCSRQCStatements(csr)
+		So(err, ShouldBeNil)
+		So(found, ShouldResemble, qc)
+	})
+
+	Convey("directly in an attribute", t, func() {
+		csr := csrWithAttributes(t, []csrAttribute{
+			{Type: asn1.ObjectIdentifier{1, 2, 3, 4}, Values: []asn1.RawValue{{FullBytes: []byte{0x05, 0x00}}}},
+			{Type: QCStatementsExt, Values: []asn1.RawValue{{FullBytes: qc}}},
+		})
+		found, err := FindCSRQCStatements(csr)
+		So(err, ShouldBeNil)
+		So(found, ShouldResemble, qc)
+	})
+
+	Convey("missing", t, func() {
+		_, err := FindCSRQCStatements(csrWithAttributes(t, nil))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSubjectAltNames(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	endpoint, err := url.Parse("https://api.example.com/psd2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("QSEAL with every SAN type", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QSEALType,
+			WithDNSName("foo.example.com"), WithIPAddress(net.ParseIP("192.0.2.1")), WithIPAddress(net.ParseIP("2001:db8::1")),
+			WithURI(endpoint), WithEmailAddress("psd2@example.com"))
+		So(err, ShouldBeNil)
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+		So(csr.DNSNames, ShouldResemble, []string{"foo.example.com"})
+		So(csr.IPAddresses, ShouldHaveLength, 2)
+		So(csr.IPAddresses[0].Equal(net.ParseIP("192.0.2.1")), ShouldBeTrue)
+		So(csr.IPAddresses[1].Equal(net.ParseIP("2001:db8::1")), ShouldBeTrue)
+
+		var ipLengths []int
+		for _, ext := range csr.Extensions {
+			if !ext.Id.Equal(asn1.ObjectIdentifier{2, 5, 29, 17}) {
+				continue
+			}
+			var names []asn1.RawValue
+			_, err := asn1.Unmarshal(ext.Value, &names)
+			So(err, ShouldBeNil)
+			for _, name := range names {
+				if name.Class == asn1.ClassContextSpecific && name.Tag == 7 {
+					ipLengths = append(ipLengths, len(name.Bytes))
+				}
+			}
+		}
+		So(ipLengths, ShouldResemble, []int{4, 16})
+		So(csr.URIs, ShouldHaveLength, 1)
+		So(csr.URIs[0].String(), ShouldEqual, endpoint.String())
+		So(csr.EmailAddresses, ShouldResemble, []string{"psd2@example.com"})
+	})
+
+	Convey("QWAC with an IP address", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+			WithIPAddress(net.ParseIP("192.0.2.1")))
+		So(err, ShouldBeNil)
+	})
+
+	Convey("QWAC with a URI or email address", t, func() {
+		for _, opt := range []CertificateOption{WithURI(endpoint), WithEmailAddress("psd2@example.com")} {
+			_, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, opt)
+			So(err, ShouldNotBeNil)
+
+			data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, opt, AllowNonWebSANs())
+			So(err, ShouldBeNil)
+			csr, err := x509.ParseCertificateRequest(data)
+			So(err, ShouldBeNil)
+			So(len(csr.URIs)+len(csr.EmailAddresses), ShouldEqual, 1)
+		}
+	})
+
+	Convey("invalid SANs", t, func() {
+		relative := &url.URL{Path: "psd2"}
+		for _, opt := range []CertificateOption{WithIPAddress(nil), WithIPAddress(net.IP{192, 0, 2}), WithIPAddress(net.IPv4zero), WithIPAddress(net.ParseIP("ff02::1")), WithURI(relative), WithEmailAddress("Foo <psd2@example.com>"), WithEmailAddress("psd2")} {
+			_, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QSEALType, opt)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package eidas
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+// SubjectChange describes a subject attribute whose values differ between a
+// CSR and the certificate issued for it.
+type SubjectChange struct {
+	Type      asn1.ObjectIdentifier
+	Requested []string
+	Issued    []string
+}
+
+// CertificateDiff describes how a certificate differs from the CSR it was
+// issued for. Extensions and statements are identified by OID and listed in
+// the order they appear.
+type CertificateDiff struct {
+	// PublicKeyChanged is set if the certificate is for a different key.
+	PublicKeyChanged bool
+	// Subject lists the subject attributes that were changed, added or
+	// removed.
+	Subject []SubjectChange
+
+	AddedExtensions   []asn1.ObjectIdentifier
+	RemovedExtensions []asn1.ObjectIdentifier
+	ChangedExtensions []asn1.ObjectIdentifier
+
+	// AddedPolicies lists certificate policies the CSR did not request.
+	AddedPolicies []asn1.ObjectIdentifier
+
+	AddedStatements   []asn1.ObjectIdentifier
+	RemovedStatements []asn1.ObjectIdentifier
+	ChangedStatements []asn1.ObjectIdentifier
+
+	AddedRoles   []qcstatements.Role
+	RemovedRoles []qcstatements.Role
+}
+
+// Empty reports whether the certificate matches the CSR exactly.
+func (d *CertificateDiff) Empty() bool {
+	return !d.PublicKeyChanged && len(d.Subject) == 0 &&
+		len(d.AddedExtensions) == 0 && len(d.RemovedExtensions) == 0 && len(d.ChangedExtensions) == 0 &&
+		len(d.AddedPolicies) == 0 &&
+		len(d.AddedStatements) == 0 && len(d.RemovedStatements) == 0 && len(d.ChangedStatements) == 0 &&
+		len(d.AddedRoles) == 0 && len(d.RemovedRoles) == 0
+}
+
+// CompareCSRToCertificate compares a DER encoded CSR with the DER encoded
+// certificate a CA issued for it. Unlike VerifyIssuedCertificate, which fails
+// on differences that matter, it reports every difference, including the
+// extensions the CA is expected to add, such as the authorityKeyIdentifier.
+func CompareCSRToCertificate(csrDER, certDER []byte) (*CertificateDiff, error) {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse csr: %v", err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	diff := &CertificateDiff{}
+	pub, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	diff.PublicKeyChanged = !ok || !pub.Equal(csr.PublicKey)
+	diff.Subject = diffNames(csr.Subject.Names, cert.Subject.Names)
+
+	requestedExts := extensionValues(csr.Extensions)
+	issuedExts := extensionValues(cert.Extensions)
+	diff.AddedExtensions, diff.RemovedExtensions, diff.ChangedExtensions = diffValues(requestedExts, issuedExts)
+
+	requestedPolicies, err := ExtractPolicies(csr.Extensions)
+	if err != nil {
+		return nil, fmt.Errorf("csr: %v", err)
+	}
+	var requestedIDs []asn1.ObjectIdentifier
+	for _, p := range requestedPolicies {
+		requestedIDs = append(requestedIDs, p.OID)
+	}
+	for _, p := range cert.PolicyIdentifiers {
+		if !containsOID(requestedIDs, p) {
+			diff.AddedPolicies = append(diff.AddedPolicies, p)
+		}
+	}
+
+	requestedQC := findQCStatements(csr.Extensions)
+	issuedQC := findQCStatements(cert.Extensions)
+	requestedStatements, err := statementValues(requestedQC)
+	if err != nil {
+		return nil, fmt.Errorf("csr: %v", err)
+	}
+	issuedStatements, err := statementValues(issuedQC)
+	if err != nil {
+		return nil, fmt.Errorf("certificate: %v", err)
+	}
+	diff.AddedStatements, diff.RemovedStatements, diff.ChangedStatements = diffValues(requestedStatements, issuedStatements)
+
+	requestedRoles, _, _, _ := qcstatements.Extract(requestedQC)
+	issuedRoles, _, _, _ := qcstatements.Extract(issuedQC)
+	diff.AddedRoles, diff.RemovedRoles = qcstatements.RolesDiff(requestedRoles, issuedRoles)
+	return diff, nil
+}
+
+// oidValue is a value identified by an OID, such as an extension.
+type oidValue struct {
+	id    asn1.ObjectIdentifier
+	value []byte
+}
+
+func extensionValues(exts []pkix.Extension) []oidValue {
+	values := make([]oidValue, len(exts))
+	for i, ext := range exts {
+		values[i] = oidValue{ext.Id, ext.Value}
+	}
+	return values
+}
+
+func statementValues(qc []byte) ([]oidValue, error) {
+	if qc == nil {
+		return nil, nil
+	}
+	statements, err := qcstatements.ExtractRaw(qc)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]oidValue, len(statements))
+	for i, st := range statements {
+		values[i] = oidValue{st.OID, st.Raw}
+	}
+	return values, nil
+}
+
+// diffValues compares values by OID, returning those only in issued, those
+// only in requested, and those in both with different values.
+func diffValues(requested, issued []oidValue) (added, removed, changed []asn1.ObjectIdentifier) {
+	find := func(values []oidValue, id asn1.ObjectIdentifier) *oidValue {
+		for i := range values {
+			if values[i].id.Equal(id) {
+				return &values[i]
+			}
+		}
+		return nil
+	}
+	for _, r := range requested {
+		i := find(issued, r.id)
+		if i == nil {
+			removed = append(removed, r.id)
+		} else if !bytes.Equal(i.value, r.value) {
+			changed = append(changed, r.id)
+		}
+	}
+	for _, i := range issued {
+		if find(requested, i.id) == nil {
+			added = append(added, i.id)
+		}
+	}
+	return added, removed, changed
+}
+
+// diffNames groups subject attribute values by type and reports each type
+// whose values differ.
+func diffNames(requested, issued []pkix.AttributeTypeAndValue) []SubjectChange {
+	var changes []SubjectChange
+	var types []asn1.ObjectIdentifier
+	for _, names := range [][]pkix.AttributeTypeAndValue{requested, issued} {
+		for _, name := range names {
+			if !containsOID(types, name.Type) {
+				types = append(types, name.Type)
+			}
+		}
+	}
+	for _, t := range types {
+		r, i := attributeValues(requested, t), attributeValues(issued, t)
+		if fmt.Sprint(r) != fmt.Sprint(i) {
+			changes = append(changes, SubjectChange{Type: t, Requested: r, Issued: i})
+		}
+	}
+	return changes
+}
+
+func attributeValues(names []pkix.AttributeTypeAndValue, t asn1.ObjectIdentifier) []string {
+	var values []string
+	for _, name := range names {
+		if name.Type.Equal(t) {
+			values = append(values, fmt.Sprint(name.Value))
+		}
+	}
+	return values
+}
+
+func containsOID(oids []asn1.ObjectIdentifier, oid asn1.ObjectIdentifier) bool {
+	for _, o := range oids {
+		if o.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
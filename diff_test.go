@@ -0,0 +1,86 @@
+package eidas
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+	"time"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompareCSRToCertificate(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation, qcstatements.RolePaymentInitiation}
+	csrDER, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := newTestCA(t, "Test CA", nil)
+	issue := func(modify func(*x509.Certificate)) []byte {
+		tmpl, err := CertificateTemplate(csr, time.Hour)
+		So(err, ShouldBeNil)
+		modify(tmpl)
+		d, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, csr.PublicKey, ca.key)
+		So(err, ShouldBeNil)
+		return d
+	}
+	oidAuthorityKeyIdentifier := asn1.ObjectIdentifier{2, 5, 29, 35}
+
+	Convey("certificate issued as requested", t, func() {
+		diff, err := CompareCSRToCertificate(csrDER, issue(func(*x509.Certificate) {}))
+		So(err, ShouldBeNil)
+		So(diff.AddedExtensions, ShouldResemble, []asn1.ObjectIdentifier{oidAuthorityKeyIdentifier})
+		diff.AddedExtensions = nil
+		So(diff.Empty(), ShouldBeTrue)
+	})
+
+	Convey("certificate with changes", t, func() {
+		policy := asn1.ObjectIdentifier{0, 4, 0, 194112, 1, 4}
+		certDER := issue(func(tmpl *x509.Certificate) {
+			tmpl.RawSubject = nil
+			tmpl.Subject = pkix.Name{Country: []string{"GB"}, Organization: []string{"Foo Org"}, CommonName: "Other Name"}
+			tmpl.PolicyIdentifiers = []asn1.ObjectIdentifier{policy}
+
+			gb, err := qcstatements.CompetentAuthorityForCountryCode("GB")
+			So(err, ShouldBeNil)
+			qc, err := qcstatements.Serialize([]qcstatements.Role{qcstatements.RoleAccountInformation}, *gb, qcstatements.QWACType, qcstatements.WithCompliance())
+			So(err, ShouldBeNil)
+			for i, ext := range tmpl.ExtraExtensions {
+				if ext.Id.Equal(QCStatementsExt) {
+					tmpl.ExtraExtensions[i].Value = qc
+				}
+			}
+		})
+
+		diff, err := CompareCSRToCertificate(csrDER, certDER)
+		So(err, ShouldBeNil)
+		So(diff.Empty(), ShouldBeFalse)
+		So(diff.PublicKeyChanged, ShouldBeFalse)
+		So(diff.Subject, ShouldResemble, []SubjectChange{
+			{Type: oidOrganizationID, Requested: []string{"PSDGB-FCA-123456"}},
+			{Type: oidCommonName, Requested: []string{"Foo Name"}, Issued: []string{"Other Name"}},
+		})
+		So(diff.AddedExtensions, ShouldContain, oidCertificatePolicies)
+		So(diff.ChangedExtensions, ShouldResemble, []asn1.ObjectIdentifier{QCStatementsExt})
+		So(diff.AddedPolicies, ShouldResemble, []asn1.ObjectIdentifier{policy})
+		So(diff.AddedStatements, ShouldResemble, []asn1.ObjectIdentifier{qcstatements.QcComplianceOID})
+		So(diff.ChangedStatements, ShouldResemble, []asn1.ObjectIdentifier{qcstatements.PSD2OID})
+		So(diff.RemovedStatements, ShouldBeEmpty)
+		So(diff.AddedRoles, ShouldBeEmpty)
+		So(diff.RemovedRoles, ShouldResemble, []qcstatements.Role{qcstatements.RolePaymentInitiation})
+	})
+
+	Convey("malformed input", t, func() {
+		_, err := CompareCSRToCertificate(csrDER, []byte{0x30})
+		So(err, ShouldNotBeNil)
+		_, err = CompareCSRToCertificate([]byte{0x30}, issue(func(*x509.Certificate) {}))
+		So(err, ShouldNotBeNil)
+	})
+}
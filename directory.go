@@ -0,0 +1,66 @@
+package eidas
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+// SubjectDirectoryAttributesExt is the OID of the subjectDirectoryAttributes
+// extension, defined in RFC 5280 section 4.2.1.8.
+var SubjectDirectoryAttributesExt = asn1.ObjectIdentifier{2, 5, 29, 9}
+
+// DirectoryAttribute is an attribute in a subjectDirectoryAttributes
+// extension. Each value holds the DER encoding of an attribute value.
+type DirectoryAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// NewDirectoryAttribute builds a DirectoryAttribute of the given type by
+// encoding each value with encoding/asn1.
+func NewDirectoryAttribute(t asn1.ObjectIdentifier, values ...interface{}) (DirectoryAttribute, error) {
+	attr := DirectoryAttribute{Type: t}
+	for _, v := range values {
+		d, err := asn1.Marshal(v)
+		if err != nil {
+			return DirectoryAttribute{}, fmt.Errorf("failed to marshal %v attribute value: %v", t, err)
+		}
+		attr.Values = append(attr.Values, asn1.RawValue{FullBytes: d})
+	}
+	return attr, nil
+}
+
+func subjectDirectoryAttributesExtension(attrs []DirectoryAttribute) (pkix.Extension, error) {
+	if len(attrs) == 0 {
+		return pkix.Extension{}, fmt.Errorf("subjectDirectoryAttributes must have at least one attribute")
+	}
+	for _, attr := range attrs {
+		if len(attr.Values) == 0 {
+			return pkix.Extension{}, fmt.Errorf("attribute %v has no values", attr.Type)
+		}
+	}
+	d, err := asn1.Marshal(attrs)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("failed to marshal subjectDirectoryAttributes: %v", err)
+	}
+	return pkix.Extension{
+		Id:    SubjectDirectoryAttributesExt,
+		Value: d,
+	}, nil
+}
+
+// ParseSubjectDirectoryAttributes decodes the value of a
+// subjectDirectoryAttributes extension. Attribute values are left encoded and
+// may be decoded with asn1.Unmarshal.
+func ParseSubjectDirectoryAttributes(data []byte) ([]DirectoryAttribute, error) {
+	var attrs []DirectoryAttribute
+	rest, err := asn1.Unmarshal(data, &attrs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode subjectDirectoryAttributes: %v", err)
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after subjectDirectoryAttributes")
+	}
+	return attrs, nil
+}
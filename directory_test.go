@@ -0,0 +1,57 @@
+package eidas
+
+import (
+	"encoding/asn1"
+	"testing"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSubjectDirectoryAttributes(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	// id-at-organizationIdentifier, used here for a registration reference.
+	oidRegistration := asn1.ObjectIdentifier{2, 5, 4, 97}
+
+	Convey("CSR without the extension by default", t, func() {
+		req, err := NewCSRTemplate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		So(req.ExtraExtensions, shouldNotContainID, SubjectDirectoryAttributesExt)
+	})
+
+	Convey("CSR with attributes", t, func() {
+		attr, err := NewDirectoryAttribute(oidRegistration, "NTRGB-12345678")
+		So(err, ShouldBeNil)
+		req, err := NewCSRTemplate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+			WithSubjectDirectoryAttributes(attr))
+		So(err, ShouldBeNil)
+		So(req.ExtraExtensions, shouldContainID, SubjectDirectoryAttributesExt)
+
+		for _, ext := range req.ExtraExtensions {
+			if !ext.Id.Equal(SubjectDirectoryAttributesExt) {
+				continue
+			}
+			So(ext.Critical, ShouldBeFalse)
+			attrs, err := ParseSubjectDirectoryAttributes(ext.Value)
+			So(err, ShouldBeNil)
+			So(attrs, ShouldHaveLength, 1)
+			So(attrs[0].Type, ShouldResemble, oidRegistration)
+			So(attrs[0].Values, ShouldHaveLength, 1)
+			var value string
+			_, err = asn1.Unmarshal(attrs[0].Values[0].FullBytes, &value)
+			So(err, ShouldBeNil)
+			So(value, ShouldEqual, "NTRGB-12345678")
+		}
+	})
+
+	Convey("attribute without values", t, func() {
+		_, err := NewCSRTemplate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+			WithSubjectDirectoryAttributes(DirectoryAttribute{Type: oidRegistration}))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("malformed extension", t, func() {
+		_, err := ParseSubjectDirectoryAttributes([]byte{0x30, 0x03, 0x06})
+		So(err, ShouldNotBeNil)
+	})
+}
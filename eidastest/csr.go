@@ -0,0 +1,97 @@
+package eidastest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/creditkudos/eidas"
+)
+
+// EditCSR re-signs a DER encoded CSR with key, which must be the CSR's key,
+// after passing a copy of its requested extensions to edit and requesting
+// those edit returns instead, e.g. to build invalid input for code that
+// validates CSRs. The subject and public key are kept; other attributes,
+// such as a challengePassword, are dropped.
+func EditCSR(csrDER []byte, key crypto.Signer, edit func([]pkix.Extension) []pkix.Extension) ([]byte, error) {
+	if err := eidas.VerifyCSRKey(csrDER, key); err != nil {
+		return nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse csr: %v", err)
+	}
+	exts := edit(append([]pkix.Extension{}, csr.Extensions...))
+	d, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		RawSubject:         csr.RawSubject,
+		SignatureAlgorithm: csr.SignatureAlgorithm,
+		ExtraExtensions:    exts,
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate csr: %v", err)
+	}
+	return d, nil
+}
+
+// RemoveExtension re-signs a DER encoded CSR with key, without the requested
+// extension identified by oid, e.g. eidas.QCStatementsExt.
+func RemoveExtension(csrDER []byte, key crypto.Signer, oid asn1.ObjectIdentifier) ([]byte, error) {
+	return EditCSR(csrDER, key, func(exts []pkix.Extension) []pkix.Extension {
+		var kept []pkix.Extension
+		for _, ext := range exts {
+			if !ext.Id.Equal(oid) {
+				kept = append(kept, ext)
+			}
+		}
+		return kept
+	})
+}
+
+// SetExtension re-signs a DER encoded CSR with key, requesting ext in place
+// of any extension with the same OID, or in addition to the others if there
+// is none. Its value need not be well formed.
+func SetExtension(csrDER []byte, key crypto.Signer, ext pkix.Extension) ([]byte, error) {
+	return EditCSR(csrDER, key, func(exts []pkix.Extension) []pkix.Extension {
+		for i := range exts {
+			if exts[i].Id.Equal(ext.Id) {
+				exts[i] = ext
+				return exts
+			}
+		}
+		return append(exts, ext)
+	})
+}
+
+// InjectStatement re-signs a DER encoded CSR with key, with statement
+// appended to the SEQUENCE OF QCStatement of its QCStatements extension.
+// statement is added as given, so it may be malformed, e.g. truncated or
+// with a statementInfo of the wrong type, to test how a validator copes.
+func InjectStatement(csrDER []byte, key crypto.Signer, statement []byte) ([]byte, error) {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse csr: %v", err)
+	}
+	for _, ext := range csr.Extensions {
+		if !ext.Id.Equal(eidas.QCStatementsExt) {
+			continue
+		}
+		var seq asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &seq); err != nil {
+			return nil, fmt.Errorf("failed to decode QCStatements: %v", err)
+		}
+		ext.Value, err = asn1.Marshal(asn1.RawValue{
+			Class:      asn1.ClassUniversal,
+			Tag:        asn1.TagSequence,
+			IsCompound: true,
+			Bytes:      append(append([]byte{}, seq.Bytes...), statement...),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode QCStatements: %v", err)
+		}
+		return SetExtension(csrDER, key, ext)
+	}
+	return nil, fmt.Errorf("csr has no QCStatements extension")
+}
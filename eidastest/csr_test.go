@@ -0,0 +1,95 @@
+package eidastest
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/creditkudos/eidas"
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+func TestEditCSR(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	csrDER, key, err := eidas.GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+		eidas.WithDNSName("foo.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parse := func(d []byte) *x509.CertificateRequest {
+		csr, err := x509.ParseCertificateRequest(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := csr.CheckSignature(); err != nil {
+			t.Fatal(err)
+		}
+		return csr
+	}
+	original := parse(csrDER)
+
+	removed, err := RemoveExtension(csrDER, key, eidas.QCStatementsExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr := parse(removed)
+	if qc, _ := eidas.FindCSRQCStatements(csr); qc != nil {
+		t.Error("Expected QCStatements to be removed")
+	}
+	if len(csr.Extensions) != len(original.Extensions)-1 {
+		t.Errorf("Expected %d extensions, got %d", len(original.Extensions)-1, len(csr.Extensions))
+	}
+	if !bytes.Equal(csr.RawSubject, original.RawSubject) || len(csr.DNSNames) != 1 {
+		t.Error("Expected subject and subjectAltName to be kept")
+	}
+
+	garbage := pkix.Extension{Id: eidas.QCStatementsExt, Value: []byte{0x30, 0x05, 0x06}}
+	for _, d := range [][]byte{csrDER, removed} {
+		set, err := SetExtension(d, key, garbage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		qc, err := eidas.FindCSRQCStatements(parse(set))
+		if err != nil || !bytes.Equal(qc, garbage.Value) {
+			t.Errorf("Expected QCStatements %x, got %x (%v)", garbage.Value, qc, err)
+		}
+	}
+
+	// A PSD2 statement whose statementInfo is an INTEGER rather than a
+	// SEQUENCE, placed before the valid one.
+	malformed, err := asn1.Marshal(struct {
+		OID  asn1.ObjectIdentifier
+		Info int
+	}{qcstatements.PSD2OID, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := SetExtension(removed, key, pkix.Extension{Id: eidas.QCStatementsExt, Value: []byte{0x30, 0x00}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	injected, err := InjectStatement(empty, key, malformed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qc, err := eidas.FindCSRQCStatements(parse(injected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := qcstatements.Extract(qc); err == nil {
+		t.Error("Expected Extract to fail on the injected statement")
+	}
+
+	if _, err := InjectStatement(removed, key, malformed); err == nil {
+		t.Error("Expected an error injecting into a CSR without QCStatements")
+	}
+	_, other, err := eidas.GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RemoveExtension(csrDER, other, eidas.QCStatementsExt); err == nil {
+		t.Error("Expected an error re-signing with another key")
+	}
+}
@@ -0,0 +1,98 @@
+// Package eidastest provides a throwaway certificate authority for testing
+// code that handles eIDAS certificates, so that chains carrying QCStatements
+// can be built and verified without a real QTSP, helpers that edit the
+// extensions of a CSR to build invalid input, and keys derived from a seed for
+// reproducible fixtures. It is not for production use.
+package eidastest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/creditkudos/eidas"
+)
+
+// CAOptions configures NewCA.
+type CAOptions struct {
+	// CommonName of the CA. If empty, "eidastest CA" is used.
+	CommonName string
+	// Validity of the CA certificate, from now. If zero, a day is used.
+	Validity time.Duration
+	// PermittedDNSDomains and ExcludedDNSDomains are the DNS name
+	// constraints on the certificates the CA issues. If both are empty the
+	// CA certificate has no NameConstraints extension.
+	PermittedDNSDomains []string
+	ExcludedDNSDomains  []string
+}
+
+// CA is a self-signed certificate authority.
+type CA struct {
+	Certificate *x509.Certificate
+	Key         *ecdsa.PrivateKey
+}
+
+// NewCA generates a key and a self-signed CA certificate for it. Any name
+// constraints are marked critical, as RFC 5280 requires.
+func NewCA(opts CAOptions) (*CA, error) {
+	name := opts.CommonName
+	if name == "" {
+		name = "eidastest CA"
+	}
+	validity := opts.Validity
+	if validity == 0 {
+		validity = 24 * time.Hour
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key pair: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:                serial,
+		Subject:                     pkix.Name{CommonName: name},
+		NotBefore:                   now.Add(-time.Minute),
+		NotAfter:                    now.Add(validity),
+		KeyUsage:                    x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid:       true,
+		IsCA:                        true,
+		PermittedDNSDomains:         opts.PermittedDNSDomains,
+		ExcludedDNSDomains:          opts.ExcludedDNSDomains,
+		PermittedDNSDomainsCritical: len(opts.PermittedDNSDomains) != 0 || len(opts.ExcludedDNSDomains) != 0,
+	}
+	d, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	return &CA{Certificate: cert, Key: key}, nil
+}
+
+// Issue signs a certificate for a DER encoded CSR, e.g. from
+// eidas.GenerateCSR, valid from now for the given duration, with
+// eidas.CAIssuer. The subject and extensions are copied from the CSR, so the
+// certificate carries the CSR's QCStatements.
+func (ca *CA) Issue(csrDER []byte, validity time.Duration) (*x509.Certificate, error) {
+	issuer := eidas.CAIssuer{Cert: ca.Certificate, Key: ca.Key}
+	return issuer.IssueCSR(csrDER, validity)
+}
+
+// Pool returns a pool holding the CA certificate, for use as roots.
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Certificate)
+	return pool
+}
@@ -0,0 +1,74 @@
+package eidastest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/creditkudos/eidas"
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+func TestIssue(t *testing.T) {
+	ca, err := NewCA(CAOptions{PermittedDNSDomains: []string{"example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ca.Certificate.IsCA || !ca.Certificate.PermittedDNSDomainsCritical {
+		t.Fatal("Expected a CA certificate with critical name constraints")
+	}
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	opts := eidas.VerifyOptions{Roots: ca.Pool(), Offline: true}
+
+	csr, _, err := eidas.GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+		eidas.WithQcCompliance(), eidas.WithDNSName("foo.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ca.Issue(csr, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chains, err := eidas.VerifyCertificate(context.Background(), cert, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chains) != 1 || len(chains[0]) != 2 {
+		t.Errorf("Expected a two certificate chain, got %v", chains)
+	}
+	if !eidas.IsQWAC(cert) {
+		t.Error("Expected issued certificate to be a QWAC")
+	}
+	if findings := eidas.LintCertificate(cert, eidas.LintOptions{}); len(findings) != 0 {
+		t.Errorf("Unexpected findings: %v", findings)
+	}
+
+	csr, _, err = eidas.GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+		eidas.WithDNSName("foo.example.org"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err = ca.Issue(csr, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := eidas.VerifyCertificate(context.Background(), cert, opts); err == nil {
+		t.Error("Expected name constraint violation")
+	}
+}
+
+func TestNewCA(t *testing.T) {
+	ca, err := NewCA(CAOptions{CommonName: "Test CA", Validity: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.Certificate.Subject.CommonName != "Test CA" {
+		t.Errorf("Unexpected subject %v", ca.Certificate.Subject)
+	}
+	if ca.Certificate.PermittedDNSDomainsCritical {
+		t.Error("Expected no name constraints")
+	}
+	if err := ca.Certificate.CheckSignatureFrom(ca.Certificate); err != nil {
+		t.Error(err)
+	}
+}
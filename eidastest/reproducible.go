@@ -0,0 +1,103 @@
+package eidastest
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// ReproducibleKey derives a 2048-bit RSA key from seed, for committed test
+// fixtures: with eidas.WithReproducibleSeed and
+// eidas.GenerateSelfSignedCertificateWithKey it gives byte-identical
+// certificates across runs. Anyone who knows the seed can recreate the key,
+// so it must never be used outside tests.
+func ReproducibleKey(seed []byte) (*rsa.PrivateKey, error) {
+	key, err := deterministicRSAKey(newSeedReader(seed, "key"), 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key pair: %v", err)
+	}
+	return key, nil
+}
+
+// seedReader is an endless stream of bytes derived from a seed and a label,
+// each block being the SHA-256 of the seed, the label and a counter.
+type seedReader struct {
+	seed, label []byte
+	counter     uint64
+	buf         []byte
+}
+
+func newSeedReader(seed []byte, label string) *seedReader {
+	return &seedReader{seed: seed, label: []byte(label)}
+}
+
+func (r *seedReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			h := sha256.New()
+			h.Write(r.seed)
+			h.Write(r.label)
+			binary.Write(h, binary.BigEndian, r.counter)
+			r.counter++
+			r.buf = h.Sum(nil)
+		}
+		c := copy(p[n:], r.buf)
+		r.buf = r.buf[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// deterministicRSAKey generates an RSA key of the given size with primes
+// drawn from r. rsa.GenerateKey cannot be used, as it does not read
+// deterministically from its source of randomness.
+func deterministicRSAKey(r io.Reader, bits int) (*rsa.PrivateKey, error) {
+	e := big.NewInt(65537)
+	one := big.NewInt(1)
+	prime := func() (*big.Int, error) {
+		b := make([]byte, bits/16)
+		for {
+			if _, err := io.ReadFull(r, b); err != nil {
+				return nil, err
+			}
+			// Setting the top two bits makes the product of two primes
+			// exactly bits long.
+			b[0] |= 0xc0
+			b[len(b)-1] |= 1
+			p := new(big.Int).SetBytes(b)
+			if !p.ProbablyPrime(20) {
+				continue
+			}
+			if new(big.Int).GCD(nil, nil, e, new(big.Int).Sub(p, one)).Cmp(one) == 0 {
+				return p, nil
+			}
+		}
+	}
+
+	p, err := prime()
+	if err != nil {
+		return nil, err
+	}
+	q, err := prime()
+	for err == nil && p.Cmp(q) == 0 {
+		q, err = prime()
+	}
+	if err != nil {
+		return nil, err
+	}
+	phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+	key := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: new(big.Int).Mul(p, q), E: int(e.Int64())},
+		D:         new(big.Int).ModInverse(e, phi),
+		Primes:    []*big.Int{p, q},
+	}
+	if err := key.Validate(); err != nil {
+		return nil, err
+	}
+	key.Precompute()
+	return key, nil
+}
@@ -0,0 +1,42 @@
+package eidastest
+
+import (
+	"bytes"
+	"crypto/x509"
+	"testing"
+
+	"github.com/creditkudos/eidas"
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+func TestReproducibleKey(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	generate := func(seed string) ([]byte, []byte) {
+		key, err := ReproducibleKey([]byte(seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.N.BitLen() != 2048 {
+			t.Errorf("Expected a 2048-bit key, got %d bits", key.N.BitLen())
+		}
+		cert, err := eidas.GenerateSelfSignedCertificateWithKey(key, "GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QSEALType,
+			eidas.WithReproducibleSeed([]byte(seed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Raw, x509.MarshalPKCS1PrivateKey(key)
+	}
+
+	cert1, key1 := generate("fixture")
+	cert2, key2 := generate("fixture")
+	if !bytes.Equal(key1, key2) {
+		t.Error("Expected the same key from the same seed")
+	}
+	if !bytes.Equal(cert1, cert2) {
+		t.Error("Expected byte-identical certificates from the same seed")
+	}
+	cert3, key3 := generate("other fixture")
+	if bytes.Equal(key1, key3) || bytes.Equal(cert1, cert3) {
+		t.Error("Expected a different key and certificate from another seed")
+	}
+}
@@ -0,0 +1,38 @@
+package eidas_test
+
+import (
+	"crypto/x509"
+	"fmt"
+	"log"
+
+	"github.com/creditkudos/eidas"
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+func ExampleGenerateCSR() {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation, qcstatements.RolePaymentInitiation}
+	der, _, err := eidas.GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		log.Fatal(err)
+	}
+	qc, err := eidas.FindCSRQCStatements(csr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	extracted, name, id, err := qcstatements.Extract(qc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(csr.Subject.CommonName)
+	fmt.Println(extracted)
+	fmt.Println(name, id)
+	// Output:
+	// Foo Name
+	// [PSP_PI PSP_AI]
+	// Financial Conduct Authority GB-FCA
+}
@@ -1,5 +1,5 @@
 module github.com/creditkudos/eidas
 
-go 1.11
+go 1.15
 
 require github.com/smartystreets/goconvey v0.0.0-20190330032615-68dc04aab96a
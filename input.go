@@ -0,0 +1,51 @@
+package eidas
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Fields named by InputError.
+const (
+	FieldCountryCode        = "country_code"
+	FieldOrganizationName   = "organization_name"
+	FieldOrganizationalUnit = "organizational_unit"
+	FieldOrganizationID     = "organization_id"
+	FieldCommonName         = "common_name"
+	FieldSerialNumber       = "serial_number"
+	FieldRoles              = "roles"
+	FieldQCType             = "qc_type"
+	FieldKeyUsage           = "key_usage"
+	FieldExtendedKeyUsage   = "extended_key_usage"
+	FieldSubjectAltName     = "subject_alt_name"
+)
+
+// InputError is a problem with one argument or option of GenerateCSR, so that
+// a form built on it can highlight the field at fault.
+type InputError struct {
+	// Field is one of the Field constants, e.g. FieldCountryCode.
+	Field   string
+	Message string
+}
+
+func (e *InputError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// InputErrors are all of the problems found with the arguments and options of
+// GenerateCSR, which validates every input before failing. Use a type
+// assertion on the error it returns to get them.
+type InputErrors []*InputError
+
+func (e InputErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return "eidas: invalid input: " + strings.Join(messages, "; ")
+}
+
+// add appends a problem with field to e.
+func (e *InputErrors) add(field string, format string, a ...interface{}) {
+	*e = append(*e, &InputError{Field: field, Message: fmt.Sprintf(format, a...)})
+}
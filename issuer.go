@@ -0,0 +1,106 @@
+package eidas
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"time"
+
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+// CAIssuer issues QWACs and QSEALs from a CA certificate and its key, for
+// internal CAs in non-production environments. The key may be held
+// externally, e.g. in an HSM, behind a crypto.Signer.
+type CAIssuer struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// Issue signs an end-entity certificate for pub from tmpl, which must carry
+// the QCStatements extension in its ExtraExtensions, as the templates from
+// CertificateTemplate do. If tmpl has no keyUsage or extended key usage, they
+// are set to those required by the declared QC type. The authorityKeyIdentifier
+// is set from the CA certificate's subjectKeyIdentifier, or derived from its
+// key if it has none. tmpl is not modified.
+func (ca *CAIssuer) Issue(tmpl *x509.Certificate, pub crypto.PublicKey) (*x509.Certificate, error) {
+	if !ca.Cert.IsCA {
+		return nil, fmt.Errorf("eidas: issuer certificate is not a CA")
+	}
+	if ca.Cert.KeyUsage != 0 && ca.Cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, fmt.Errorf("eidas: issuer certificate is not allowed to sign certificates")
+	}
+	caPub, ok := ca.Key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !caPub.Equal(ca.Cert.PublicKey) {
+		return nil, fmt.Errorf("eidas: issuer key does not match issuer certificate")
+	}
+
+	qc := findQCStatements(tmpl.ExtraExtensions)
+	if qc == nil {
+		return nil, fmt.Errorf("eidas: template has no QCStatements extension")
+	}
+	qcType, err := qcstatements.ExtractType(qc)
+	if err != nil {
+		return nil, fmt.Errorf("eidas: %v", err)
+	}
+
+	t := *tmpl
+	t.BasicConstraintsValid = true
+	t.IsCA = false
+	if t.KeyUsage == 0 && !containsExtension(t.ExtraExtensions, oidKeyUsage) {
+		usages, err := keyUsageForType(qcType)
+		if err != nil {
+			return nil, fmt.Errorf("eidas: %v", err)
+		}
+		for _, usage := range usages {
+			t.KeyUsage |= usage
+		}
+	}
+	if len(t.ExtKeyUsage) == 0 && len(t.UnknownExtKeyUsage) == 0 && !containsExtension(t.ExtraExtensions, oidExtKeyUsage) {
+		if t.UnknownExtKeyUsage, err = extendedKeyUsageForType(qcType); err != nil {
+			return nil, fmt.Errorf("eidas: %v", err)
+		}
+	}
+	if len(ca.Cert.SubjectKeyId) == 0 {
+		if t.AuthorityKeyId, err = keyIdentifier(ca.Cert.PublicKey, SKIMethodSHA1); err != nil {
+			return nil, fmt.Errorf("eidas: %v", err)
+		}
+	}
+
+	d, err := x509.CreateCertificate(rand.Reader, &t, ca.Cert, pub, ca.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// IssueCSR issues a certificate for a DER encoded CSR, valid from now for the
+// given duration, with the subject and extensions copied from the CSR by
+// CertificateTemplate.
+func (ca *CAIssuer) IssueCSR(csrDER []byte, validity time.Duration) (*x509.Certificate, error) {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse csr: %v", err)
+	}
+	tmpl, err := CertificateTemplate(csr, validity)
+	if err != nil {
+		return nil, err
+	}
+	return ca.Issue(tmpl, csr.PublicKey)
+}
+
+func containsExtension(exts []pkix.Extension, id asn1.ObjectIdentifier) bool {
+	for _, ext := range exts {
+		if ext.Id.Equal(id) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package eidas
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testCAIssuer(t *testing.T) *CAIssuer {
+	ca := newTestCA(t, "Test CA", nil)
+	return &CAIssuer{Cert: ca.cert, Key: ca.key}
+}
+
+func TestCAIssuer(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+
+	Convey("issue a QWAC from a CSR", t, func() {
+		ca := testCAIssuer(t)
+		csr, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithDNSName("foo.example.com"))
+		So(err, ShouldBeNil)
+		cert, err := ca.IssueCSR(csr, time.Hour)
+		So(err, ShouldBeNil)
+		So(cert.CheckSignatureFrom(ca.Cert), ShouldBeNil)
+		So(cert.AuthorityKeyId, ShouldResemble, ca.Cert.SubjectKeyId)
+		So(cert.IsCA, ShouldBeFalse)
+		So(cert.KeyUsage, ShouldEqual, x509.KeyUsageDigitalSignature)
+		So(cert.ExtKeyUsage, ShouldResemble, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth})
+		So(IsQWAC(cert), ShouldBeTrue)
+		So(VerifyIssuedCertificate(csr, BundlePEM(cert)), ShouldBeNil)
+	})
+
+	Convey("certificate policies are copied from the CSR", t, func() {
+		ca := testCAIssuer(t)
+		policies := []Policy{{
+			OID:         PolicyQCPWeb,
+			CPSURIs:     []string{"https://example.com/cps"},
+			UserNotices: []string{"Issued by an internal CA"},
+		}}
+		csr, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithPolicies(policies...))
+		So(err, ShouldBeNil)
+		cert, err := ca.IssueCSR(csr, time.Hour)
+		So(err, ShouldBeNil)
+		got, err := ExtractPolicies(cert.Extensions)
+		So(err, ShouldBeNil)
+		So(got, ShouldResemble, policies)
+	})
+
+	Convey("usages are set from the QC type", t, func() {
+		ca := testCAIssuer(t)
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		qc, err := qcstatements.SerializeForCountry(roles, "GB", qcstatements.QSEALType)
+		So(err, ShouldBeNil)
+		tmpl := &x509.Certificate{
+			SerialNumber:    big.NewInt(2),
+			Subject:         pkix.Name{CommonName: "Foo Name"},
+			NotBefore:       time.Now(),
+			NotAfter:        time.Now().Add(time.Hour),
+			ExtraExtensions: []pkix.Extension{qcStatementsExtension(qc)},
+		}
+		cert, err := ca.Issue(tmpl, &key.PublicKey)
+		So(err, ShouldBeNil)
+		So(cert.KeyUsage, ShouldEqual, x509.KeyUsageDigitalSignature|x509.KeyUsageContentCommitment)
+		So(cert.ExtKeyUsage, ShouldBeEmpty)
+		So(tmpl.KeyUsage, ShouldEqual, 0)
+
+		Convey("with an authorityKeyIdentifier derived from the CA key", func() {
+			ca.Cert.SubjectKeyId = nil
+			cert, err := ca.Issue(tmpl, &key.PublicKey)
+			So(err, ShouldBeNil)
+			id, err := keyIdentifier(ca.Cert.PublicKey, SKIMethodSHA1)
+			So(err, ShouldBeNil)
+			So(cert.AuthorityKeyId, ShouldResemble, id)
+		})
+	})
+
+	Convey("template without QCStatements", t, func() {
+		ca := testCAIssuer(t)
+		_, err := ca.Issue(&x509.Certificate{SerialNumber: big.NewInt(2)}, ca.Key.Public())
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("issuer that is not a CA", t, func() {
+		ca := testCAIssuer(t)
+		ca.Cert.IsCA = false
+		csr, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		_, err = ca.IssueCSR(csr, time.Hour)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("issuer key that does not match", t, func() {
+		ca := testCAIssuer(t)
+		ca.Key = testCAIssuer(t).Key
+		csr, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		_, err = ca.IssueCSR(csr, time.Hour)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,58 @@
+package eidas
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+)
+
+// KeyPolicy is the minimum strength of key accepted by CheckKeyStrength. A
+// key type the policy does not allow is rejected, so the zero KeyPolicy
+// rejects every key.
+type KeyPolicy struct {
+	// MinRSABits is the smallest RSA modulus allowed, in bits. RSA keys are
+	// not allowed if it is zero.
+	MinRSABits int
+	// Curves are the curves allowed for ECDSA keys.
+	Curves []elliptic.Curve
+	// AllowEd25519 allows Ed25519 keys.
+	AllowEd25519 bool
+}
+
+// DefaultKeyPolicy allows RSA keys of at least 2048 bits and ECDSA keys on
+// P-256, P-384 or P-521.
+var DefaultKeyPolicy = KeyPolicy{
+	MinRSABits: 2048,
+	Curves:     []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()},
+}
+
+// CheckKeyStrength returns an error describing why the public key of csr
+// does not meet policy, or nil if it does.
+func CheckKeyStrength(csr *x509.CertificateRequest, policy KeyPolicy) error {
+	switch pub := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if policy.MinRSABits == 0 {
+			return fmt.Errorf("eidas: RSA keys are not allowed by the key policy")
+		}
+		if bits := pub.N.BitLen(); bits < policy.MinRSABits {
+			return fmt.Errorf("eidas: RSA key of %d bits is smaller than the minimum of %d", bits, policy.MinRSABits)
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		for _, curve := range policy.Curves {
+			if pub.Curve == curve {
+				return nil
+			}
+		}
+		return fmt.Errorf("eidas: ECDSA curve %s is not allowed by the key policy", pub.Curve.Params().Name)
+	case ed25519.PublicKey:
+		if !policy.AllowEd25519 {
+			return fmt.Errorf("eidas: Ed25519 keys are not allowed by the key policy")
+		}
+		return nil
+	}
+	return fmt.Errorf("eidas: unsupported key type: %T", csr.PublicKey)
+}
@@ -0,0 +1,60 @@
+package eidas
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckKeyStrength(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+
+	Convey("RSA key", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+
+		So(CheckKeyStrength(csr, DefaultKeyPolicy), ShouldBeNil)
+
+		err = CheckKeyStrength(csr, KeyPolicy{MinRSABits: 3072})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "2048 bits")
+
+		So(CheckKeyStrength(csr, KeyPolicy{Curves: DefaultKeyPolicy.Curves}), ShouldNotBeNil)
+	})
+
+	Convey("ECDSA key", t, func() {
+		for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+			key, err := ecdsa.GenerateKey(curve, rand.Reader)
+			So(err, ShouldBeNil)
+			data, err := GenerateCSRWithSigner(key, "GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QSEALType)
+			So(err, ShouldBeNil)
+			csr, err := x509.ParseCertificateRequest(data)
+			So(err, ShouldBeNil)
+
+			So(CheckKeyStrength(csr, DefaultKeyPolicy), ShouldBeNil)
+		}
+
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		err = CheckKeyStrength(&x509.CertificateRequest{PublicKey: &key.PublicKey}, KeyPolicy{Curves: []elliptic.Curve{elliptic.P384()}})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "P-256")
+	})
+
+	Convey("Ed25519 key", t, func() {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		So(err, ShouldBeNil)
+		csr := &x509.CertificateRequest{PublicKey: pub}
+
+		So(CheckKeyStrength(csr, DefaultKeyPolicy), ShouldNotBeNil)
+		So(CheckKeyStrength(csr, KeyPolicy{AllowEd25519: true}), ShouldBeNil)
+	})
+}
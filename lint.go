@@ -0,0 +1,243 @@
+package eidas
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+// Severity is how serious a Finding is.
+type Severity int
+
+const (
+	// SeverityWarning marks something unusual that does not by itself make
+	// the certificate non-conformant.
+	SeverityWarning Severity = iota
+	// SeverityError marks a conformance problem.
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// MarshalText encodes s as "warning" or "error".
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Finding is a problem found by LintCertificate.
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// LintOptions configures LintCertificate.
+type LintOptions struct {
+	// Now returns the time at which the certificate must be valid. If nil,
+	// time.Now is used.
+	Now func() time.Time
+}
+
+// LintCertificate checks a QWAC or QSEAL for every eIDAS and PSD2
+// conformance problem it can detect, returning a Finding for each:
+//
+//   - the certificate must be within its validity period;
+//   - it must have QCStatements with QcCompliance, a known QcType and a PSD2
+//     statement with at least one role, each named consistently with its
+//     OID;
+//   - its KeyUsage and extended key usages must suit its QC type;
+//   - its subject must have a PSD2 organizationIdentifier whose NCA matches
+//     the competent authority of the PSD2 statement.
+//
+// A certificate without findings is not necessarily valid: its chain is not
+// verified.
+func LintCertificate(cert *x509.Certificate, opts LintOptions) []Finding {
+	var findings []Finding
+	add := func(severity Severity, format string, a ...interface{}) {
+		findings = append(findings, Finding{Severity: severity, Message: fmt.Sprintf(format, a...)})
+	}
+
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+	if err := checkValidityPeriod(cert, now()); err != nil {
+		add(SeverityError, "%v", err)
+	}
+
+	orgIDValid := false
+	if s, ok := organizationIDFromName(cert.Subject); !ok {
+		add(SeverityError, "subject has no organizationIdentifier")
+	} else if _, err := ParseOrganizationID(s); err != nil {
+		add(SeverityError, "%v", err)
+	} else {
+		orgIDValid = true
+	}
+
+	qc := findQCStatements(cert.Extensions)
+	if qc == nil {
+		add(SeverityError, "no QCStatements extension")
+		return findings
+	}
+	statements, err := qcstatements.ExtractRaw(qc)
+	if err != nil {
+		add(SeverityError, "failed to decode QCStatements: %v", err)
+		return findings
+	}
+	has := func(oid asn1.ObjectIdentifier) bool {
+		for _, st := range statements {
+			if st.OID.Equal(oid) {
+				return true
+			}
+		}
+		return false
+	}
+	if !has(qcstatements.QcComplianceOID) {
+		add(SeverityError, "no QcCompliance statement")
+	}
+
+	var qcType asn1.ObjectIdentifier
+	if !has(qcstatements.QcTypeOID) {
+		add(SeverityError, "no QcType statement")
+	} else if qcType, err = qcstatements.ExtractType(qc); err != nil {
+		add(SeverityError, "%v", err)
+	} else if err := checkKeyUsageForType(cert.KeyUsage, qcType); err != nil {
+		add(SeverityError, "%v", err)
+	}
+
+	if !has(qcstatements.PSD2OID) {
+		add(SeverityError, "no PSD2 statement")
+		return findings
+	}
+	roles, name, id, err := qcstatements.Extract(qc)
+	if err != nil {
+		add(SeverityError, "%v", err)
+		return findings
+	}
+	mismatches, err := qcstatements.CheckRoleOIDs(qc)
+	if err != nil {
+		add(SeverityError, "%v", err)
+	}
+	for _, m := range mismatches {
+		add(SeverityError, "%s", m)
+	}
+	if qcType != nil {
+		extKeyUsage, err := extKeyUsageOIDs(cert.Extensions)
+		if err != nil {
+			add(SeverityError, "%v", err)
+		}
+		for _, problem := range ValidateRolesForType(roles, qcType, extKeyUsage) {
+			add(SeverityError, "%s", problem)
+		}
+	}
+	if name == "" {
+		add(SeverityWarning, "PSD2 statement has no competent authority name")
+	}
+	if _, err := qcstatements.CompetentAuthorityForID(id); err != nil {
+		add(SeverityWarning, "%v", err)
+	}
+	if orgIDValid {
+		if err := CheckOrganizationIDAuthority(cert.Subject, cert.Extensions); err != nil {
+			add(SeverityError, "%v", err)
+		}
+	}
+	return findings
+}
+
+// extKeyUsageOIDs returns the extended key usages in exts, including those
+// crypto/x509 does not know.
+func extKeyUsageOIDs(exts []pkix.Extension) ([]asn1.ObjectIdentifier, error) {
+	for _, ext := range exts {
+		if ext.Id.Equal(oidExtKeyUsage) {
+			var usages []asn1.ObjectIdentifier
+			if _, err := asn1.Unmarshal(ext.Value, &usages); err != nil {
+				return nil, fmt.Errorf("failed to decode extended key usage: %v", err)
+			}
+			return usages, nil
+		}
+	}
+	return nil, nil
+}
+
+// HasErrors reports whether any of findings is an error, for rejecting a
+// certificate that is not conformant.
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// PSD2Options configures ValidatePSD2Certificate.
+type PSD2Options struct {
+	// Type is the QC type the certificate must declare, e.g.
+	// qcstatements.QWACType. If nil, any known type is accepted.
+	Type asn1.ObjectIdentifier
+	// RequiredRoles are the roles the PSD2 statement must include.
+	RequiredRoles []qcstatements.Role
+	// Now returns the time at which the certificate must be valid. If nil,
+	// time.Now is used.
+	Now func() time.Time
+}
+
+// ValidatePSD2Certificate checks a certificate presented by a PSD2
+// counterparty. It returns the findings of LintCertificate, followed by an
+// error finding if the certificate does not declare opts.Type and one for each
+// of opts.RequiredRoles it lacks. To enforce, reject the certificate if
+// HasErrors reports true; to report, log every finding.
+//
+// The error is only set if opts is invalid: Type is not a registered QC type
+// or a required role is unknown.
+func ValidatePSD2Certificate(cert *x509.Certificate, opts PSD2Options) ([]Finding, error) {
+	if opts.Type != nil {
+		if _, err := lookupQCType(opts.Type); err != nil {
+			return nil, fmt.Errorf("eidas: %v", err)
+		}
+	}
+	required := make([]string, len(opts.RequiredRoles))
+	for i, r := range opts.RequiredRoles {
+		required[i] = string(r)
+	}
+	if _, err := qcstatements.ParseRoles(required); err != nil {
+		return nil, fmt.Errorf("eidas: %v", err)
+	}
+
+	findings := LintCertificate(cert, LintOptions{Now: opts.Now})
+	add := func(format string, a ...interface{}) {
+		findings = append(findings, Finding{Severity: SeverityError, Message: fmt.Sprintf(format, a...)})
+	}
+
+	// LintCertificate has already reported statements that are missing or
+	// cannot be decoded, so these checks only apply to those it could read.
+	if opts.Type != nil {
+		if types, err := CertificateQCTypes(cert); err == nil && !containsOID(types, opts.Type) {
+			names := make([]string, len(types))
+			for i, t := range types {
+				names[i] = qcTypeName(t)
+			}
+			add("certificate is not a %s: declares %s", qcTypeName(opts.Type), strings.Join(names, ", "))
+		}
+	}
+	if len(opts.RequiredRoles) != 0 {
+		if qc := findQCStatements(cert.Extensions); qc != nil {
+			if roles, _, _, err := qcstatements.Extract(qc); err == nil {
+				missing, _ := qcstatements.RolesDiff(roles, opts.RequiredRoles)
+				for _, r := range missing {
+					add("missing required role %s", r)
+				}
+			}
+		}
+	}
+	return findings, nil
+}
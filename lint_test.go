@@ -0,0 +1,138 @@
+package eidas
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLintCertificate(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	issue := func(opts ...CertificateOption) *x509.Certificate {
+		csr, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, opts...)
+		So(err, ShouldBeNil)
+		cert, err := ReadCertificate(issueCertificate(t, csr, nil))
+		So(err, ShouldBeNil)
+		return cert
+	}
+	messages := func(findings []Finding, severity Severity) []string {
+		var m []string
+		for _, f := range findings {
+			if f.Severity == severity {
+				m = append(m, f.Message)
+			}
+		}
+		return m
+	}
+
+	Convey("conformant certificate", t, func() {
+		cert := issue(WithQcCompliance())
+		So(LintCertificate(cert, LintOptions{}), ShouldBeEmpty)
+	})
+
+	Convey("expired certificate", t, func() {
+		cert := issue(WithQcCompliance())
+		tomorrow := func() time.Time { return cert.NotAfter.Add(24 * time.Hour) }
+		errs := messages(LintCertificate(cert, LintOptions{Now: tomorrow}), SeverityError)
+		So(errs, ShouldHaveLength, 1)
+		So(errs[0], ShouldContainSubstring, "expired")
+	})
+
+	Convey("missing QcCompliance", t, func() {
+		errs := messages(LintCertificate(issue(), LintOptions{}), SeverityError)
+		So(errs, ShouldResemble, []string{"no QcCompliance statement"})
+	})
+
+	Convey("QWAC without serverAuth", t, func() {
+		cert := issue(WithQcCompliance(), WithExtendedKeyUsage(tLSWWWClientAuthUsage))
+		errs := messages(LintCertificate(cert, LintOptions{}), SeverityError)
+		So(errs, ShouldResemble, []string{"QWAC extended key usage is missing serverAuth"})
+	})
+
+	Convey("competent authority mismatch", t, func() {
+		resolver := qcstatements.CompetentAuthorityMap{"GB": {Name: "Prudential Regulation Authority", ID: "GB-PRA"}}
+		findings := LintCertificate(issue(WithQcCompliance(), WithCompetentAuthorityResolver(resolver)), LintOptions{})
+		So(messages(findings, SeverityError), ShouldHaveLength, 1)
+		So(messages(findings, SeverityWarning), ShouldResemble, []string{"unknown competent authority: GB-PRA"})
+	})
+
+	Convey("certificate missing most requirements", t, func() {
+		cert := selfSignedCertificate(t, qcstatements.QSEALType, x509.KeyUsageDigitalSignature)
+		errs := messages(LintCertificate(cert, LintOptions{}), SeverityError)
+		So(errs, ShouldResemble, []string{
+			"subject has no organizationIdentifier",
+			"no QcCompliance statement",
+			"key usage for QC type 0.4.0.1862.1.6.2 is missing nonRepudiation",
+		})
+	})
+
+	Convey("findings encode as JSON", t, func() {
+		d, err := json.Marshal(Finding{Severity: SeverityError, Message: "no QcType statement"})
+		So(err, ShouldBeNil)
+		So(string(d), ShouldEqual, `{"severity":"error","message":"no QcType statement"}`)
+	})
+}
+
+func TestValidatePSD2Certificate(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation, qcstatements.RolePaymentInitiation}
+	issue := func(qcType asn1.ObjectIdentifier) *x509.Certificate {
+		csr, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcType, WithQcCompliance())
+		So(err, ShouldBeNil)
+		cert, err := ReadCertificate(issueCertificate(t, csr, nil))
+		So(err, ShouldBeNil)
+		return cert
+	}
+
+	Convey("conformant certificate", t, func() {
+		findings, err := ValidatePSD2Certificate(issue(qcstatements.QWACType), PSD2Options{
+			Type:          qcstatements.QWACType,
+			RequiredRoles: []qcstatements.Role{qcstatements.RoleAccountInformation},
+		})
+		So(err, ShouldBeNil)
+		So(findings, ShouldBeEmpty)
+		So(HasErrors(findings), ShouldBeFalse)
+	})
+
+	Convey("wrong type and missing roles", t, func() {
+		findings, err := ValidatePSD2Certificate(issue(qcstatements.QSEALType), PSD2Options{
+			Type:          qcstatements.QWACType,
+			RequiredRoles: []qcstatements.Role{qcstatements.RoleAccountServicing, qcstatements.RolePaymentInitiation, qcstatements.RolePaymentInstruments},
+		})
+		So(err, ShouldBeNil)
+		So(findings, ShouldResemble, []Finding{
+			{Severity: SeverityError, Message: "certificate is not a QWAC: declares QSEAL"},
+			{Severity: SeverityError, Message: "missing required role PSP_AS"},
+			{Severity: SeverityError, Message: "missing required role PSP_IC"},
+		})
+		So(HasErrors(findings), ShouldBeTrue)
+	})
+
+	Convey("expired certificate", t, func() {
+		cert := issue(qcstatements.QWACType)
+		tomorrow := func() time.Time { return cert.NotAfter.Add(24 * time.Hour) }
+		findings, err := ValidatePSD2Certificate(cert, PSD2Options{Now: tomorrow})
+		So(err, ShouldBeNil)
+		So(findings, ShouldHaveLength, 1)
+		So(findings[0].Message, ShouldContainSubstring, "expired")
+	})
+
+	Convey("lint findings are included", t, func() {
+		cert := selfSignedCertificate(t, qcstatements.QSEALType, x509.KeyUsageDigitalSignature|x509.KeyUsageContentCommitment)
+		findings, err := ValidatePSD2Certificate(cert, PSD2Options{Type: qcstatements.QSEALType})
+		So(err, ShouldBeNil)
+		So(findings, ShouldResemble, LintCertificate(cert, LintOptions{}))
+	})
+
+	Convey("invalid options", t, func() {
+		cert := issue(qcstatements.QWACType)
+		_, err := ValidatePSD2Certificate(cert, PSD2Options{Type: asn1.ObjectIdentifier{1, 2, 3}})
+		So(err, ShouldNotBeNil)
+		_, err = ValidatePSD2Certificate(cert, PSD2Options{RequiredRoles: []qcstatements.Role{"PSP_XX"}})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,89 @@
+package eidas
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"strings"
+
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+// OrganizationID is a parsed PSD2 organizationIdentifier, as defined in ETSI
+// TS 119 495 section 5.2.1, e.g. "PSDGB-FCA-123456".
+type OrganizationID struct {
+	// CountryCode is the ISO 3166-1 alpha-2 code of the NCA, e.g. "GB".
+	CountryCode string
+	// NCA is the identifier of the competent authority within its country,
+	// e.g. "FCA".
+	NCA string
+	// Reference is the authorization number assigned by the NCA, e.g. "123456".
+	Reference string
+}
+
+// ParseOrganizationID parses a PSD2 organizationIdentifier of the form
+// "PSD<country code>-<NCA>-<authorization number>".
+func ParseOrganizationID(s string) (*OrganizationID, error) {
+	parts := strings.SplitN(s, "-", 3)
+	if len(parts) != 3 || len(parts[0]) != 5 || !strings.HasPrefix(parts[0], "PSD") {
+		return nil, fmt.Errorf("invalid PSD2 organization ID: %q", s)
+	}
+	if parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("invalid PSD2 organization ID: %q", s)
+	}
+	return &OrganizationID{
+		CountryCode: parts[0][3:],
+		NCA:         parts[1],
+		Reference:   parts[2],
+	}, nil
+}
+
+// String returns the organizationIdentifier encoding of id.
+func (id OrganizationID) String() string {
+	return fmt.Sprintf("PSD%s-%s-%s", id.CountryCode, id.NCA, id.Reference)
+}
+
+// SubjectOrganizationID returns the organizationIdentifier (2.5.4.97) of the
+// subject of csr, which crypto/x509 only exposes in Subject.Names. It reports
+// false if the subject has no organizationIdentifier.
+func SubjectOrganizationID(csr *x509.CertificateRequest) (string, bool) {
+	return organizationIDFromName(csr.Subject)
+}
+
+func organizationIDFromName(name pkix.Name) (string, bool) {
+	for _, n := range name.Names {
+		if n.Type.Equal(oidOrganizationID) {
+			s, ok := n.Value.(string)
+			return s, ok
+		}
+	}
+	return "", false
+}
+
+// CheckOrganizationIDAuthority checks that the NCA of the PSD2
+// organizationIdentifier in subject, e.g. "GB-FCA" in "PSDGB-FCA-123456",
+// matches the competent authority ID of the PSD2 statement in exts, the
+// Extensions of a certificate or CSR. A mismatch indicates that the subject
+// and the statement name different authorities.
+func CheckOrganizationIDAuthority(subject pkix.Name, exts []pkix.Extension) error {
+	s, ok := organizationIDFromName(subject)
+	if !ok {
+		return fmt.Errorf("subject has no organizationIdentifier")
+	}
+	orgID, err := ParseOrganizationID(s)
+	if err != nil {
+		return err
+	}
+	qc := findQCStatements(exts)
+	if qc == nil {
+		return fmt.Errorf("no QCStatements extension")
+	}
+	_, _, caID, err := qcstatements.Extract(qc)
+	if err != nil {
+		return fmt.Errorf("failed to extract PSD2 statement: %v", err)
+	}
+	if nca := orgID.CountryCode + "-" + orgID.NCA; nca != caID {
+		return fmt.Errorf("organizationIdentifier %q names competent authority %q but the PSD2 statement names %q", s, nca, caID)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package eidas
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseOrganizationID(t *testing.T) {
+	Convey("valid organization ID", t, func() {
+		id, err := ParseOrganizationID("PSDGB-FCA-123456")
+		So(err, ShouldBeNil)
+		So(id.CountryCode, ShouldEqual, "GB")
+		So(id.NCA, ShouldEqual, "FCA")
+		So(id.Reference, ShouldEqual, "123456")
+		So(id.String(), ShouldEqual, "PSDGB-FCA-123456")
+	})
+
+	Convey("authorization number containing a hyphen", t, func() {
+		id, err := ParseOrganizationID("PSDDE-BAFIN-123-456")
+		So(err, ShouldBeNil)
+		So(id.NCA, ShouldEqual, "BAFIN")
+		So(id.Reference, ShouldEqual, "123-456")
+	})
+
+	Convey("invalid organization IDs", t, func() {
+		for _, s := range []string{"", "PSDGB", "PSDGB-FCA", "PSDGB-FCA-", "VATGB-123-456", "PSDGBR-FCA-1", "PSDGB--123"} {
+			_, err := ParseOrganizationID(s)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestSubjectOrganizationID(t *testing.T) {
+	Convey("CSR with an organizationIdentifier", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+
+		id, ok := SubjectOrganizationID(csr)
+		So(ok, ShouldBeTrue)
+		So(id, ShouldEqual, "PSDGB-FCA-123456")
+	})
+
+	Convey("CSR without an organizationIdentifier", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "", "Foo Name", nil, qcstatements.QWACType, WithoutPSD2())
+		So(err, ShouldBeNil)
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+
+		id, ok := SubjectOrganizationID(csr)
+		So(ok, ShouldBeFalse)
+		So(id, ShouldEqual, "")
+	})
+}
+
+func TestCheckOrganizationIDAuthority(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	parse := func(data []byte) *x509.CertificateRequest {
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+		return csr
+	}
+
+	Convey("matching competent authority", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		csr := parse(data)
+		So(CheckOrganizationIDAuthority(csr.Subject, csr.Extensions), ShouldBeNil)
+	})
+
+	Convey("mismatched competent authority", t, func() {
+		resolver := qcstatements.CompetentAuthorityMap{"GB": {Name: "Prudential Regulation Authority", ID: "GB-PRA"}}
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithCompetentAuthorityResolver(resolver))
+		So(err, ShouldBeNil)
+		csr := parse(data)
+		err = CheckOrganizationIDAuthority(csr.Subject, csr.Extensions)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, `"GB-FCA"`)
+		So(err.Error(), ShouldContainSubstring, `"GB-PRA"`)
+	})
+
+	Convey("without an organizationIdentifier", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "", "Foo Name", nil, qcstatements.QWACType, WithoutPSD2())
+		So(err, ShouldBeNil)
+		csr := parse(data)
+		So(CheckOrganizationIDAuthority(csr.Subject, csr.Extensions), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,126 @@
+package eidas
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// KeyFormat selects the encoding used for a private key.
+type KeyFormat int
+
+// Supported private key encodings.
+const (
+	// KeyFormatPKCS8 encodes the key as a PKCS#8 "PRIVATE KEY".
+	KeyFormatPKCS8 KeyFormat = iota
+	// KeyFormatPKCS1 encodes the key as a PKCS#1 "RSA PRIVATE KEY". Only RSA
+	// keys are supported.
+	KeyFormatPKCS1
+)
+
+// EncodePrivateKeyDER returns the DER encoding of key in the given format.
+func EncodePrivateKeyDER(key crypto.Signer, format KeyFormat) ([]byte, error) {
+	switch format {
+	case KeyFormatPKCS8:
+		d, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal private key: %v", err)
+		}
+		return d, nil
+	case KeyFormatPKCS1:
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("PKCS#1 requires an RSA key, got %T", key)
+		}
+		return x509.MarshalPKCS1PrivateKey(rsaKey), nil
+	}
+	return nil, fmt.Errorf("unknown key format: %d", format)
+}
+
+// EncodePrivateKeyPEM returns the PEM encoding of key in the given format.
+func EncodePrivateKeyPEM(key crypto.Signer, format KeyFormat) ([]byte, error) {
+	d, err := EncodePrivateKeyDER(key, format)
+	if err != nil {
+		return nil, err
+	}
+	blockType := "PRIVATE KEY"
+	if format == KeyFormatPKCS1 {
+		blockType = "RSA PRIVATE KEY"
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  blockType,
+		Bytes: d,
+	}), nil
+}
+
+// PEM block types for certificate signing requests.
+const (
+	// CSRBlockType is the standard PEM block type for a CSR.
+	CSRBlockType = "CERTIFICATE REQUEST"
+	// LegacyCSRBlockType is the PEM block type expected by some older
+	// enrollment tools.
+	LegacyCSRBlockType = "NEW CERTIFICATE REQUEST"
+)
+
+// EncodeCSRPEM returns the PEM encoding of a DER encoded certificate signing
+// request, as returned by GenerateCSR.
+func EncodeCSRPEM(der []byte) []byte {
+	return EncodeCSRPEMWithType(der, CSRBlockType)
+}
+
+// EncodeCSRPEMWithType is like EncodeCSRPEM but uses the given PEM block
+// type, e.g. LegacyCSRBlockType.
+func EncodeCSRPEMWithType(der []byte, blockType string) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  blockType,
+		Bytes: der,
+	})
+}
+
+// ReadCSR parses a certificate signing request that may be PEM or DER
+// encoded. If data holds several PEM blocks, the first CSR block is used.
+func ReadCSR(data []byte) (*x509.CertificateRequest, error) {
+	der, err := findPEMBlock(data, CSRBlockType, LegacyCSRBlockType)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse csr: %v", err)
+	}
+	return csr, nil
+}
+
+// ReadCertificate parses a certificate that may be PEM or DER encoded. If data
+// holds several PEM blocks, the first certificate block is used.
+func ReadCertificate(data []byte) (*x509.Certificate, error) {
+	der, err := findPEMBlock(data, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	return cert, nil
+}
+
+// findPEMBlock returns the contents of the first PEM block in data with one of
+// the given types. If data is not PEM encoded it is returned unchanged.
+func findPEMBlock(data []byte, types ...string) ([]byte, error) {
+	block, rest := pem.Decode(data)
+	if block == nil {
+		return data, nil
+	}
+	for block != nil {
+		for _, t := range types {
+			if block.Type == t {
+				return block.Bytes, nil
+			}
+		}
+		block, rest = pem.Decode(rest)
+	}
+	return nil, fmt.Errorf("no %s PEM block found", types[0])
+}
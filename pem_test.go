@@ -0,0 +1,124 @@
+package eidas
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEncodePrivateKeyPEM(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("PKCS#8 private key", t, func() {
+		d, err := EncodePrivateKeyPEM(key, KeyFormatPKCS8)
+		So(err, ShouldBeNil)
+		block, rest := pem.Decode(d)
+		So(block, ShouldNotBeNil)
+		So(rest, ShouldBeEmpty)
+		So(block.Type, ShouldEqual, "PRIVATE KEY")
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		So(err, ShouldBeNil)
+		So(parsed.(*rsa.PrivateKey).N, ShouldResemble, key.N)
+	})
+
+	Convey("PKCS#1 private key", t, func() {
+		d, err := EncodePrivateKeyPEM(key, KeyFormatPKCS1)
+		So(err, ShouldBeNil)
+		block, _ := pem.Decode(d)
+		So(block, ShouldNotBeNil)
+		So(block.Type, ShouldEqual, "RSA PRIVATE KEY")
+		parsed, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		So(err, ShouldBeNil)
+		So(parsed.N, ShouldResemble, key.N)
+	})
+
+	Convey("PKCS#1 for a non-RSA key", t, func() {
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		_, err = EncodePrivateKeyPEM(ecKey, KeyFormatPKCS1)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "RSA")
+	})
+
+	Convey("unknown key format", t, func() {
+		_, err := EncodePrivateKeyPEM(key, KeyFormat(42))
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestEncodeCSRPEM(t *testing.T) {
+	Convey("CSR PEM", t, func() {
+		block, _ := pem.Decode(EncodeCSRPEM([]byte{1, 2, 3}))
+		So(block, ShouldNotBeNil)
+		So(block.Type, ShouldEqual, "CERTIFICATE REQUEST")
+		So(block.Bytes, ShouldResemble, []byte{1, 2, 3})
+	})
+
+	Convey("CSR PEM with legacy block type", t, func() {
+		block, _ := pem.Decode(EncodeCSRPEMWithType([]byte{1, 2, 3}, LegacyCSRBlockType))
+		So(block, ShouldNotBeNil)
+		So(block.Type, ShouldEqual, "NEW CERTIFICATE REQUEST")
+		So(block.Bytes, ShouldResemble, []byte{1, 2, 3})
+	})
+}
+
+func TestReadCSR(t *testing.T) {
+	der, key, err := GenerateCSR("GB", "Foo Org", "Foo Org ID", "Foo Name", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := EncodePrivateKeyPEM(key, KeyFormatPKCS8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("DER CSR", t, func() {
+		csr, err := ReadCSR(der)
+		So(err, ShouldBeNil)
+		So(csr.Raw, ShouldResemble, der)
+	})
+
+	Convey("PEM CSR", t, func() {
+		csr, err := ReadCSR(EncodeCSRPEM(der))
+		So(err, ShouldBeNil)
+		So(csr.Raw, ShouldResemble, der)
+	})
+
+	Convey("PEM CSR after other blocks", t, func() {
+		data := append(keyPEM, EncodeCSRPEMWithType(der, LegacyCSRBlockType)...)
+		csr, err := ReadCSR(data)
+		So(err, ShouldBeNil)
+		So(csr.Raw, ShouldResemble, der)
+	})
+
+	Convey("PEM without a CSR", t, func() {
+		_, err := ReadCSR(keyPEM)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestReadCertificate(t *testing.T) {
+	cert := selfSignedCertificate(t, qcstatements.QWACType, x509.KeyUsageDigitalSignature)
+
+	Convey("DER certificate", t, func() {
+		parsed, err := ReadCertificate(cert.Raw)
+		So(err, ShouldBeNil)
+		So(parsed.Raw, ShouldResemble, cert.Raw)
+	})
+
+	Convey("PEM certificate bundle", t, func() {
+		parsed, err := ReadCertificate(BundlePEM(cert))
+		So(err, ShouldBeNil)
+		So(parsed.Raw, ShouldResemble, cert.Raw)
+	})
+}
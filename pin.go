@@ -0,0 +1,74 @@
+package eidas
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+)
+
+// KeyPin identifies the public key of a CSR, so that a gateway can pin it
+// before the certificate is issued.
+type KeyPin struct {
+	// SubjectKeyID is the subjectKeyIdentifier the CSR requests, which
+	// CertificateTemplate copies into the certificate, or nil if it requests
+	// none.
+	SubjectKeyID []byte
+	// SPKISHA256 is the SHA-256 hash of the DER encoded SubjectPublicKeyInfo.
+	SPKISHA256 []byte
+}
+
+// WithKeyPinHandler passes the KeyPin of the generated CSR, or self-signed
+// certificate, to h once it has been signed, so that the pin can be
+// registered without parsing the CSR again. The subjectKeyIdentifier is the
+// one actually requested, whichever SKIMethod derived it.
+func WithKeyPinHandler(h func(KeyPin)) CertificateOption {
+	return func(c *csrConfig) {
+		c.keyPin = h
+	}
+}
+
+// CSRKeyPin returns the KeyPin of a parsed CSR.
+func CSRKeyPin(csr *x509.CertificateRequest) (KeyPin, error) {
+	pin, err := keyPin(csr.PublicKey, csr.Extensions)
+	if err != nil {
+		return KeyPin{}, fmt.Errorf("eidas: %v", err)
+	}
+	return pin, nil
+}
+
+// keyPin builds the KeyPin of pub, reading the subjectKeyIdentifier from
+// exts.
+func keyPin(pub crypto.PublicKey, exts []pkix.Extension) (KeyPin, error) {
+	spki, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return KeyPin{}, fmt.Errorf("failed to marshal public key: %v", err)
+	}
+	sum := sha256.Sum256(spki)
+	pin := KeyPin{SPKISHA256: sum[:]}
+	for _, ext := range exts {
+		if ext.Id.Equal(oidSubjectKeyIdentifier) {
+			if _, err := asn1.Unmarshal(ext.Value, &pin.SubjectKeyID); err != nil {
+				return KeyPin{}, fmt.Errorf("failed to decode subject key identifier: %v", err)
+			}
+			break
+		}
+	}
+	return pin, nil
+}
+
+// reportKeyPin passes the KeyPin of pub and exts to the handler given by
+// WithKeyPinHandler, if any.
+func (c *csrConfig) reportKeyPin(pub crypto.PublicKey, exts []pkix.Extension) error {
+	if c.keyPin == nil {
+		return nil
+	}
+	pin, err := keyPin(pub, exts)
+	if err != nil {
+		return err
+	}
+	c.keyPin(pin)
+	return nil
+}
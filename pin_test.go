@@ -0,0 +1,66 @@
+package eidas
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"testing"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKeyPin(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+
+	Convey("CSR signed with an existing key", t, func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		for _, method := range []SKIMethod{SKIMethodSHA1, SKIMethodSHA256} {
+			var pins []KeyPin
+			data, err := GenerateCSRWithSigner(key, "GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+				WithSubjectKeyIdentifierMethod(method), WithKeyPinHandler(func(p KeyPin) { pins = append(pins, p) }))
+			So(err, ShouldBeNil)
+			So(pins, ShouldHaveLength, 1)
+
+			csr, err := x509.ParseCertificateRequest(data)
+			So(err, ShouldBeNil)
+			spki := sha256.Sum256(csr.RawSubjectPublicKeyInfo)
+			So(pins[0].SPKISHA256, ShouldResemble, spki[:])
+			ski, err := keyIdentifier(&key.PublicKey, method)
+			So(err, ShouldBeNil)
+			So(pins[0].SubjectKeyID, ShouldResemble, ski)
+
+			pin, err := CSRKeyPin(csr)
+			So(err, ShouldBeNil)
+			So(pin, ShouldResemble, pins[0])
+
+			cert, err := ReadCertificate(issueCertificate(t, data, nil))
+			So(err, ShouldBeNil)
+			So(cert.SubjectKeyId, ShouldResemble, pin.SubjectKeyID)
+			certSPKI := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			So(certSPKI[:], ShouldResemble, pin.SPKISHA256)
+		}
+	})
+
+	Convey("self-signed certificate", t, func() {
+		var pin KeyPin
+		cert, _, err := GenerateSelfSignedCertificate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QSEALType,
+			WithKeyPinHandler(func(p KeyPin) { pin = p }))
+		So(err, ShouldBeNil)
+		So(pin.SubjectKeyID, ShouldResemble, cert.SubjectKeyId)
+		spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		So(pin.SPKISHA256, ShouldResemble, spki[:])
+	})
+
+	Convey("CSR without a subjectKeyIdentifier", t, func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		pin, err := CSRKeyPin(&x509.CertificateRequest{PublicKey: &key.PublicKey})
+		So(err, ShouldBeNil)
+		So(pin.SubjectKeyID, ShouldBeNil)
+		So(pin.SPKISHA256, ShouldHaveLength, sha256.Size)
+	})
+}
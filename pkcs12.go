@@ -0,0 +1,352 @@
+package eidas
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"hash"
+	"math/big"
+	"unicode/utf16"
+)
+
+// This file encodes PKCS#12 (RFC 7292) bundles. The private key is encrypted
+// with PBES2, using PBKDF2 with HMAC-SHA-256 and AES-256-CBC, and the bundle
+// is protected by an HMAC-SHA-256 MAC, as with "openssl pkcs12 -export" in
+// OpenSSL 3. Certificates are public so are not encrypted.
+
+// pkcs12Iterations is the iteration count for key derivation, matching the
+// OpenSSL default.
+const pkcs12Iterations = 2048
+
+var (
+	oidDataContentType     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509CertificateType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidLocalKeyID          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBES2               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidSHA256              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+type pfx struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	// Content is [0] EXPLICIT, built with explicitTag.
+	Content asn1.RawValue
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID asn1.ObjectIdentifier
+	// Value is [0] EXPLICIT, built with explicitTag.
+	Value      asn1.RawValue
+	Attributes []pkcs12Attribute `asn1:"set"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	PRF        pkix.AlgorithmIdentifier
+}
+
+// EncodePKCS12 returns a PKCS#12 bundle of key and certs protected by
+// password, e.g. for import into a Java keystore. certs may be empty, to
+// bundle just the key of a CSR, or the issued certificate followed by its
+// chain. The key is paired with the certificate for the same public key.
+func EncodePKCS12(key crypto.Signer, certs []*x509.Certificate, password string) ([]byte, error) {
+	keyID, err := publicKeyID(key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	var certBags []safeBag
+	for _, cert := range certs {
+		d, err := asn1.Marshal(certBag{ID: oidX509CertificateType, Data: cert.Raw})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal certificate: %v", err)
+		}
+		bag := safeBag{ID: oidCertBag, Value: explicitTag(d)}
+		if id, err := publicKeyID(cert.PublicKey); err == nil && bytes.Equal(id, keyID) {
+			if bag.Attributes, err = localKeyIDAttributes(keyID); err != nil {
+				return nil, err
+			}
+		}
+		certBags = append(certBags, bag)
+	}
+
+	encryptedKey, err := encryptPrivateKey(key, password)
+	if err != nil {
+		return nil, err
+	}
+	keyBag := safeBag{ID: oidPKCS8ShroudedKeyBag, Value: explicitTag(encryptedKey)}
+	if keyBag.Attributes, err = localKeyIDAttributes(keyID); err != nil {
+		return nil, err
+	}
+
+	var safes []contentInfo
+	for _, bags := range [][]safeBag{certBags, {keyBag}} {
+		if len(bags) == 0 {
+			continue
+		}
+		ci, err := dataContentInfo(bags)
+		if err != nil {
+			return nil, err
+		}
+		safes = append(safes, ci)
+	}
+	authSafe, err := asn1.Marshal(safes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal authenticated safe: %v", err)
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	macKey := pkcs12KDF(sha256.New, bmpPassword(password), salt, 3, pkcs12Iterations, sha256.Size)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(authSafe)
+
+	authSafeContent, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	d, err := asn1.Marshal(pfx{
+		Version:  3,
+		AuthSafe: contentInfo{ContentType: oidDataContentType, Content: explicitTag(authSafeContent)},
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    salt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal PKCS#12: %v", err)
+	}
+	return d, nil
+}
+
+// explicitTag wraps the DER encoding d in a [0] EXPLICIT tag. encoding/asn1
+// ignores the explicit tag of a RawValue field with FullBytes set.
+func explicitTag(d []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: d}
+}
+
+// publicKeyID identifies a key pair by the SHA-1 hash of its public key, to
+// pair a key with its certificate through the localKeyId attribute.
+func publicKeyID(pub crypto.PublicKey) ([]byte, error) {
+	d, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %v", err)
+	}
+	sum := sha1.Sum(d)
+	return sum[:], nil
+}
+
+func localKeyIDAttributes(id []byte) ([]pkcs12Attribute, error) {
+	d, err := asn1.Marshal(id)
+	if err != nil {
+		return nil, err
+	}
+	return []pkcs12Attribute{{
+		ID:    oidLocalKeyID,
+		Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: d},
+	}}, nil
+}
+
+func dataContentInfo(bags []safeBag) (contentInfo, error) {
+	d, err := asn1.Marshal(bags)
+	if err != nil {
+		return contentInfo{}, fmt.Errorf("failed to marshal safe contents: %v", err)
+	}
+	content, err := asn1.Marshal(d)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidDataContentType, Content: explicitTag(content)}, nil
+}
+
+// encryptPrivateKey returns the EncryptedPrivateKeyInfo of key, encrypted
+// with PBES2.
+func encryptPrivateKey(key crypto.Signer, password string) ([]byte, error) {
+	plain, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %v", err)
+	}
+
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(pbkdf2(sha256.New, []byte(password), salt, pkcs12Iterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	encrypted := append(plain, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: pkcs12Iterations,
+		PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: encrypted,
+	})
+}
+
+// pbkdf2 derives a key as defined in RFC 8018 section 5.2.
+func pbkdf2(h func() hash.Hash, password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(h, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// bmpPassword encodes a password as a NUL terminated BMPString, as the
+// PKCS#12 key derivation function requires.
+func bmpPassword(password string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(password)) {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return append(b, 0, 0)
+}
+
+// pkcs12KDF derives key material as defined in RFC 7292 appendix B.2. id is
+// 1 for encryption keys, 2 for IVs and 3 for MAC keys.
+func pkcs12KDF(h func() hash.Hash, password, salt []byte, id byte, iterations, size int) []byte {
+	u := h().Size()
+	v := h().BlockSize()
+
+	// fill repeats b to a multiple of v bytes.
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		n := v * ((len(b) + v - 1) / v)
+		out := make([]byte, n)
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	d := bytes.Repeat([]byte{id}, v)
+	i := append(fill(salt), fill(password)...)
+
+	one := big.NewInt(1)
+	var out []byte
+	for len(out) < size {
+		hasher := h()
+		hasher.Write(d)
+		hasher.Write(i)
+		a := hasher.Sum(nil)
+		for j := 1; j < iterations; j++ {
+			hasher = h()
+			hasher.Write(a)
+			a = hasher.Sum(a[:0])
+		}
+		out = append(out, a...)
+
+		// Each v-byte block of i is replaced by block + b + 1 mod 2^(8v),
+		// where b is a repeated to v bytes.
+		b := new(big.Int).SetBytes(fill(a[:u])[:v])
+		b.Add(b, one)
+		for j := 0; j < len(i); j += v {
+			block := new(big.Int).SetBytes(i[j : j+v])
+			block.Add(block, b)
+			sum := block.Bytes()
+			if len(sum) > v {
+				sum = sum[len(sum)-v:]
+			}
+			copy(i[j:j+v], make([]byte, v))
+			copy(i[j+v-len(sum):j+v], sum)
+		}
+	}
+	return out[:size]
+}
@@ -0,0 +1,200 @@
+package eidas
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Qualified certificate policies from ETSI EN 319 411-2.
+var (
+	// PolicyQCPNatural is QCP-n, for qualified certificates issued to natural
+	// persons.
+	PolicyQCPNatural = asn1.ObjectIdentifier{0, 4, 0, 194112, 1, 0}
+	// PolicyQCPLegal is QCP-l, for qualified certificates issued to legal
+	// persons, such as QSEALs.
+	PolicyQCPLegal = asn1.ObjectIdentifier{0, 4, 0, 194112, 1, 1}
+	// PolicyQCPNaturalQSCD is QCP-n-qscd, QCP-n with the key on a QSCD.
+	PolicyQCPNaturalQSCD = asn1.ObjectIdentifier{0, 4, 0, 194112, 1, 2}
+	// PolicyQCPLegalQSCD is QCP-l-qscd, QCP-l with the key on a QSCD.
+	PolicyQCPLegalQSCD = asn1.ObjectIdentifier{0, 4, 0, 194112, 1, 3}
+	// PolicyQCPWeb is QCP-w, for qualified website authentication
+	// certificates (QWACs).
+	PolicyQCPWeb = asn1.ObjectIdentifier{0, 4, 0, 194112, 1, 4}
+)
+
+var (
+	oidCertificatePolicies       = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidPolicyQualifierCPS        = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+	oidPolicyQualifierUserNotice = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 2}
+)
+
+// Policy is an entry of a certificatePolicies extension.
+type Policy struct {
+	OID asn1.ObjectIdentifier
+	// CPSURIs are the URIs of the certification practice statement, from
+	// CPS policy qualifiers.
+	CPSURIs []string
+	// UserNotices are the explicit texts of user notice policy qualifiers.
+	UserNotices []string
+}
+
+// maxExplicitTextLength is the most characters RFC 5280 allows in the
+// explicitText of a user notice.
+const maxExplicitTextLength = 200
+
+// WithPolicies adds a certificatePolicies extension holding the given
+// policies to the CSR, and so to certificates from
+// GenerateSelfSignedCertificate and those issued from the CSR with
+// CertificateTemplate. Each CPS URI is encoded as a CPS qualifier,
+// and each user notice as a UserNotice qualifier whose explicitText is a
+// UTF8String of at most 200 characters. The noticeRef is omitted, as RFC 5280
+// recommends. It may be given several times, the policies being combined in
+// the order given.
+func WithPolicies(policies ...Policy) CertificateOption {
+	return func(c *csrConfig) {
+		c.policies = append(c.policies, policies...)
+	}
+}
+
+type policyInformation struct {
+	Policy     asn1.ObjectIdentifier
+	Qualifiers []policyQualifierInfo `asn1:"optional"`
+}
+
+type policyQualifierInfo struct {
+	ID        asn1.ObjectIdentifier
+	Qualifier asn1.RawValue
+}
+
+// userNotice is a UserNotice with only an explicitText.
+type userNotice struct {
+	ExplicitText string `asn1:"utf8"`
+}
+
+func certificatePoliciesExtension(policies []Policy) (pkix.Extension, error) {
+	infos := make([]policyInformation, len(policies))
+	for i, p := range policies {
+		if len(p.OID) == 0 {
+			return pkix.Extension{}, fmt.Errorf("policy has no OID")
+		}
+		infos[i].Policy = p.OID
+		for _, uri := range p.CPSURIs {
+			d, err := asn1.MarshalWithParams(uri, "ia5")
+			if err != nil {
+				return pkix.Extension{}, fmt.Errorf("invalid CPS URI %q of policy %v: %v", uri, p.OID, err)
+			}
+			infos[i].Qualifiers = append(infos[i].Qualifiers, policyQualifierInfo{
+				ID:        oidPolicyQualifierCPS,
+				Qualifier: asn1.RawValue{FullBytes: d},
+			})
+		}
+		for _, text := range p.UserNotices {
+			if text == "" || !utf8.ValidString(text) || utf8.RuneCountInString(text) > maxExplicitTextLength {
+				return pkix.Extension{}, fmt.Errorf("user notice of policy %v must be 1 to %d characters of UTF-8", p.OID, maxExplicitTextLength)
+			}
+			d, err := asn1.Marshal(userNotice{ExplicitText: text})
+			if err != nil {
+				return pkix.Extension{}, fmt.Errorf("failed to encode user notice of policy %v: %v", p.OID, err)
+			}
+			infos[i].Qualifiers = append(infos[i].Qualifiers, policyQualifierInfo{
+				ID:        oidPolicyQualifierUserNotice,
+				Qualifier: asn1.RawValue{FullBytes: d},
+			})
+		}
+	}
+	d, err := asn1.Marshal(infos)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("failed to encode certificate policies: %v", err)
+	}
+	return pkix.Extension{Id: oidCertificatePolicies, Value: d}, nil
+}
+
+// ExtractPolicies returns the policies of a certificatePolicies extension in
+// exts, e.g. the Extensions of a certificate or CSR, or nil if there is none.
+// Qualifiers other than CPS URIs and user notices are ignored, as are user
+// notices with only a notice reference.
+func ExtractPolicies(exts []pkix.Extension) ([]Policy, error) {
+	for _, ext := range exts {
+		if ext.Id.Equal(oidCertificatePolicies) {
+			return parsePolicies(ext.Value)
+		}
+	}
+	return nil, nil
+}
+
+func parsePolicies(data []byte) ([]Policy, error) {
+	var infos []policyInformation
+	rest, err := asn1.Unmarshal(data, &infos)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode certificate policies: %v", err)
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after certificate policies")
+	}
+
+	policies := make([]Policy, len(infos))
+	for i, info := range infos {
+		policies[i].OID = info.Policy
+		for _, q := range info.Qualifiers {
+			switch {
+			case q.ID.Equal(oidPolicyQualifierCPS):
+				var uri string
+				if _, err := asn1.UnmarshalWithParams(q.Qualifier.FullBytes, &uri, "ia5"); err != nil {
+					return nil, fmt.Errorf("failed to decode CPS URI of policy %v: %v", info.Policy, err)
+				}
+				policies[i].CPSURIs = append(policies[i].CPSURIs, uri)
+			case q.ID.Equal(oidPolicyQualifierUserNotice):
+				text, err := userNoticeText(q.Qualifier)
+				if err != nil {
+					return nil, fmt.Errorf("failed to decode user notice of policy %v: %v", info.Policy, err)
+				}
+				if text != "" {
+					policies[i].UserNotices = append(policies[i].UserNotices, text)
+				}
+			}
+		}
+	}
+	return policies, nil
+}
+
+// userNoticeText returns the explicitText of a UserNotice, which follows an
+// optional noticeRef SEQUENCE.
+func userNoticeText(v asn1.RawValue) (string, error) {
+	if v.Class != asn1.ClassUniversal || v.Tag != asn1.TagSequence {
+		return "", fmt.Errorf("expected SEQUENCE, got class %d tag %d", v.Class, v.Tag)
+	}
+	data := v.Bytes
+	for len(data) != 0 {
+		var e asn1.RawValue
+		rest, err := asn1.Unmarshal(data, &e)
+		if err != nil {
+			return "", err
+		}
+		data = rest
+		if e.Class != asn1.ClassUniversal || e.IsCompound {
+			continue
+		}
+		switch e.Tag {
+		case asn1.TagIA5String, 26 /* VisibleString */, asn1.TagUTF8String:
+			return string(e.Bytes), nil
+		case 30: // BMPString
+			return decodeBMPString(e.Bytes)
+		}
+	}
+	return "", nil
+}
+
+// decodeBMPString decodes the big-endian UTF-16 content of a BMPString.
+func decodeBMPString(b []byte) (string, error) {
+	if len(b)%2 != 0 {
+		return "", fmt.Errorf("invalid BMPString")
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	return string(utf16.Decode(u)), nil
+}
@@ -0,0 +1,129 @@
+package eidas
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"strings"
+	"testing"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExtractPolicies(t *testing.T) {
+	qualifier := func(id asn1.ObjectIdentifier, value interface{}, params string) policyQualifierInfo {
+		d, err := asn1.MarshalWithParams(value, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return policyQualifierInfo{ID: id, Qualifier: asn1.RawValue{FullBytes: d}}
+	}
+	type noticeReference struct {
+		Organization  string `asn1:"utf8"`
+		NoticeNumbers []int
+	}
+	type userNotice struct {
+		NoticeRef    noticeReference
+		ExplicitText string `asn1:"utf8"`
+	}
+	type refOnlyNotice struct {
+		NoticeRef noticeReference
+	}
+	bmp, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: 30, Bytes: []byte{0, 'H', 0, 'i'}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bmpNotice, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: bmp})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := asn1.Marshal([]policyInformation{
+		{
+			Policy: PolicyQCPWeb,
+			Qualifiers: []policyQualifierInfo{
+				qualifier(oidPolicyQualifierCPS, "https://example.com/cps", "ia5"),
+				qualifier(oidPolicyQualifierUserNotice, userNotice{noticeReference{"Foo CA", []int{1}}, "Test notice"}, ""),
+				qualifier(oidPolicyQualifierUserNotice, refOnlyNotice{noticeReference{"Foo CA", []int{2}}}, ""),
+				{ID: oidPolicyQualifierUserNotice, Qualifier: asn1.RawValue{FullBytes: bmpNotice}},
+			},
+		},
+		{Policy: asn1.ObjectIdentifier{0, 4, 0, 19495, 3, 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("policies with qualifiers", t, func() {
+		policies, err := ExtractPolicies([]pkix.Extension{{Id: oidCertificatePolicies, Value: value}})
+		So(err, ShouldBeNil)
+		So(policies, ShouldResemble, []Policy{
+			{
+				OID:         PolicyQCPWeb,
+				CPSURIs:     []string{"https://example.com/cps"},
+				UserNotices: []string{"Test notice", "Hi"},
+			},
+			{OID: asn1.ObjectIdentifier{0, 4, 0, 19495, 3, 1}},
+		})
+	})
+
+	Convey("no certificatePolicies extension", t, func() {
+		policies, err := ExtractPolicies(nil)
+		So(err, ShouldBeNil)
+		So(policies, ShouldBeNil)
+	})
+
+	Convey("malformed certificatePolicies extension", t, func() {
+		_, err := ExtractPolicies([]pkix.Extension{{Id: oidCertificatePolicies, Value: value[:len(value)-1]}})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestWithPolicies(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	internal := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+	policies := []Policy{
+		{OID: PolicyQCPWeb, CPSURIs: []string{"https://example.com/cps"}, UserNotices: []string{"Test certificate, not for production use"}},
+		{OID: internal, UserNotices: []string{"Émis par l'AC interne"}},
+	}
+
+	Convey("self-signed certificate with policies", t, func() {
+		cert, _, err := GenerateSelfSignedCertificate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+			WithPolicies(policies[0]), WithPolicies(policies[1]))
+		So(err, ShouldBeNil)
+		So(cert.PolicyIdentifiers, ShouldResemble, []asn1.ObjectIdentifier{PolicyQCPWeb, internal})
+
+		got, err := ExtractPolicies(cert.Extensions)
+		So(err, ShouldBeNil)
+		So(got, ShouldResemble, policies)
+
+		var infos []policyInformation
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(oidCertificatePolicies) {
+				_, err := asn1.Unmarshal(ext.Value, &infos)
+				So(err, ShouldBeNil)
+			}
+		}
+		So(infos, ShouldHaveLength, 2)
+		notice := infos[1].Qualifiers[0]
+		So(notice.ID, ShouldResemble, oidPolicyQualifierUserNotice)
+		var text asn1.RawValue
+		rest, err := asn1.Unmarshal(notice.Qualifier.Bytes, &text)
+		So(err, ShouldBeNil)
+		So(rest, ShouldBeEmpty)
+		So(text.Tag, ShouldEqual, asn1.TagUTF8String)
+		So(string(text.Bytes), ShouldEqual, "Émis par l'AC interne")
+	})
+
+	Convey("invalid policies", t, func() {
+		for _, p := range []Policy{
+			{},
+			{OID: internal, CPSURIs: []string{"https://example.com/cpś"}},
+			{OID: internal, UserNotices: []string{""}},
+			{OID: internal, UserNotices: []string{strings.Repeat("x", 201)}},
+		} {
+			_, err := NewCSRTemplate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithPolicies(p))
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
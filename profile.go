@@ -0,0 +1,245 @@
+package eidas
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"sync"
+
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+// Profile lists the QCStatements and extensions a certificate must, and may,
+// carry to conform to a certificate profile such as PSD2 QWAC.
+type Profile struct {
+	// Name identifies the profile to CheckProfile, e.g. "psd2-qwac".
+	Name string
+	// QCType, if set, must be one of the types declared by the QcType
+	// statement.
+	QCType asn1.ObjectIdentifier
+	// RequiredStatements must all be present, and OptionalStatements may be.
+	// Any other statement is reported as extra.
+	RequiredStatements []asn1.ObjectIdentifier
+	OptionalStatements []asn1.ObjectIdentifier
+	// RequiredExtensions must all be present, and OptionalExtensions may be.
+	// Any other extension is reported as extra.
+	RequiredExtensions []asn1.ObjectIdentifier
+	OptionalExtensions []asn1.ObjectIdentifier
+}
+
+// Names of the built-in profiles.
+const (
+	ProfilePSD2QWAC  = "psd2-qwac"
+	ProfilePSD2QSEAL = "psd2-qseal"
+)
+
+// Statement and extension identifiers used by the built-in profiles.
+var (
+	oidQcLimitValue        = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 2}
+	oidQcRetentionPeriod   = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 3}
+	oidQcSSCD              = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 4}
+	oidAuthorityKeyID      = asn1.ObjectIdentifier{2, 5, 29, 35}
+	oidSubjectAltName      = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidBasicConstraints    = asn1.ObjectIdentifier{2, 5, 29, 19}
+	oidCRLDistribution     = asn1.ObjectIdentifier{2, 5, 29, 31}
+	oidAuthorityInfo       = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 1}
+	oidSignedCertTimestamp = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+)
+
+// oidNames are readable names for the OIDs in findings.
+var oidNames = map[string]string{
+	qcstatements.QcComplianceOID.String():   "QcCompliance",
+	qcstatements.QcTypeOID.String():         "QcType",
+	qcstatements.QcPDSOID.String():          "QcPDS",
+	qcstatements.PSD2OID.String():           "PSD2",
+	oidQcLimitValue.String():                "QcLimitValue",
+	oidQcRetentionPeriod.String():           "QcRetentionPeriod",
+	oidQcSSCD.String():                      "QcSSCD",
+	qcstatements.PKIXQCSyntaxV2OID.String(): "semantics",
+	oidSubjectKeyIdentifier.String():        "subjectKeyIdentifier",
+	oidKeyUsage.String():                    "keyUsage",
+	oidExtKeyUsage.String():                 "extKeyUsage",
+	oidAuthorityKeyID.String():              "authorityKeyIdentifier",
+	oidSubjectAltName.String():              "subjectAltName",
+	oidBasicConstraints.String():            "basicConstraints",
+	oidCRLDistribution.String():             "cRLDistributionPoints",
+	oidAuthorityInfo.String():               "authorityInfoAccess",
+	oidCertificatePolicies.String():         "certificatePolicies",
+	oidSignedCertTimestamp.String():         "signedCertificateTimestampList",
+	QCStatementsExt.String():                "QCStatements",
+	CABFOrganizationIdentifierExt.String():  "cabfOrganizationIdentifier",
+	SubjectDirectoryAttributesExt.String():  "subjectDirectoryAttributes",
+}
+
+func oidName(oid asn1.ObjectIdentifier) string {
+	if name, ok := oidNames[oid.String()]; ok {
+		return fmt.Sprintf("%s (%v)", name, oid)
+	}
+	return oid.String()
+}
+
+// psd2OptionalStatements are the statements a PSD2 certificate may carry in
+// addition to QcCompliance, QcType and PSD2, from ETSI EN 319 412-5.
+var psd2OptionalStatements = []asn1.ObjectIdentifier{
+	qcstatements.QcPDSOID,
+	oidQcLimitValue,
+	oidQcRetentionPeriod,
+	oidQcSSCD,
+	qcstatements.PKIXQCSyntaxV2OID,
+}
+
+var profiles = struct {
+	sync.RWMutex
+	m map[string]Profile
+}{
+	m: map[string]Profile{
+		ProfilePSD2QWAC: {
+			Name:   ProfilePSD2QWAC,
+			QCType: qcstatements.QWACType,
+			RequiredStatements: []asn1.ObjectIdentifier{
+				qcstatements.QcComplianceOID,
+				qcstatements.QcTypeOID,
+				qcstatements.PSD2OID,
+			},
+			OptionalStatements: psd2OptionalStatements,
+			RequiredExtensions: []asn1.ObjectIdentifier{
+				oidAuthorityKeyID,
+				oidKeyUsage,
+				oidExtKeyUsage,
+				oidSubjectAltName,
+				oidCertificatePolicies,
+				QCStatementsExt,
+			},
+			OptionalExtensions: []asn1.ObjectIdentifier{
+				oidSubjectKeyIdentifier,
+				oidBasicConstraints,
+				oidCRLDistribution,
+				oidAuthorityInfo,
+				oidSignedCertTimestamp,
+				CABFOrganizationIdentifierExt,
+				SubjectDirectoryAttributesExt,
+			},
+		},
+		ProfilePSD2QSEAL: {
+			Name:   ProfilePSD2QSEAL,
+			QCType: qcstatements.QSEALType,
+			RequiredStatements: []asn1.ObjectIdentifier{
+				qcstatements.QcComplianceOID,
+				qcstatements.QcTypeOID,
+				qcstatements.PSD2OID,
+			},
+			OptionalStatements: psd2OptionalStatements,
+			RequiredExtensions: []asn1.ObjectIdentifier{
+				oidAuthorityKeyID,
+				oidKeyUsage,
+				oidCertificatePolicies,
+				QCStatementsExt,
+			},
+			OptionalExtensions: []asn1.ObjectIdentifier{
+				oidSubjectKeyIdentifier,
+				oidExtKeyUsage,
+				oidSubjectAltName,
+				oidBasicConstraints,
+				oidCRLDistribution,
+				oidAuthorityInfo,
+				CABFOrganizationIdentifierExt,
+				SubjectDirectoryAttributesExt,
+			},
+		},
+	},
+}
+
+// RegisterProfile adds a profile for CheckProfile to check certificates
+// against. It is an error to register a name twice, including the names of
+// the built-in profiles, ProfilePSD2QWAC and ProfilePSD2QSEAL.
+func RegisterProfile(p Profile) error {
+	if p.Name == "" {
+		return fmt.Errorf("eidas: profile has no name")
+	}
+	profiles.Lock()
+	defer profiles.Unlock()
+	if _, ok := profiles.m[p.Name]; ok {
+		return fmt.Errorf("eidas: profile %s is already registered", p.Name)
+	}
+	profiles.m[p.Name] = p
+	return nil
+}
+
+// Kinds of ProfileFinding.
+const (
+	FindingMissingStatement = "missing_statement"
+	FindingExtraStatement   = "extra_statement"
+	FindingMissingExtension = "missing_extension"
+	FindingExtraExtension   = "extra_extension"
+	FindingWrongQCType      = "wrong_qc_type"
+)
+
+// ProfileFinding is a difference between a certificate and a profile found by
+// CheckProfile. Missing statements and extensions, and a wrong QC type, are
+// errors; extra ones are warnings.
+type ProfileFinding struct {
+	Finding
+	// Kind is one of the Finding constants, e.g. FindingMissingStatement.
+	Kind string `json:"kind"`
+	// OID identifies the statement or extension, or for FindingWrongQCType
+	// the QC type the profile requires.
+	OID string `json:"oid"`
+}
+
+// CheckProfile compares the QCStatements and extensions of a certificate
+// with those of the named profile, returning a finding for each that is
+// missing or not allowed. It returns an error if the profile is not
+// registered or the QCStatements cannot be decoded.
+func CheckProfile(name string, cert *x509.Certificate) ([]ProfileFinding, error) {
+	profiles.RLock()
+	p, ok := profiles.m[name]
+	profiles.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("eidas: unknown profile: %s", name)
+	}
+
+	var statements []asn1.ObjectIdentifier
+	if qc := findQCStatements(cert.Extensions); qc != nil {
+		raw, err := qcstatements.ExtractRaw(qc)
+		if err != nil {
+			return nil, fmt.Errorf("eidas: %v", err)
+		}
+		for _, st := range raw {
+			statements = append(statements, st.OID)
+		}
+	}
+	extensions := make([]asn1.ObjectIdentifier, len(cert.Extensions))
+	for i, ext := range cert.Extensions {
+		extensions[i] = ext.Id
+	}
+
+	var findings []ProfileFinding
+	add := func(severity Severity, kind string, oid asn1.ObjectIdentifier, format string) {
+		findings = append(findings, ProfileFinding{
+			Finding: Finding{Severity: severity, Message: fmt.Sprintf(format, oidName(oid))},
+			Kind:    kind,
+			OID:     oid.String(),
+		})
+	}
+	compare := func(present, required, optional []asn1.ObjectIdentifier, missingKind, extraKind, what string) {
+		for _, oid := range required {
+			if !containsOID(present, oid) {
+				add(SeverityError, missingKind, oid, "missing "+what+" %s")
+			}
+		}
+		for _, oid := range present {
+			if !containsOID(required, oid) && !containsOID(optional, oid) {
+				add(SeverityWarning, extraKind, oid, what+" %s is not in the profile")
+			}
+		}
+	}
+	compare(statements, p.RequiredStatements, p.OptionalStatements, FindingMissingStatement, FindingExtraStatement, "statement")
+	compare(extensions, p.RequiredExtensions, p.OptionalExtensions, FindingMissingExtension, FindingExtraExtension, "extension")
+
+	if p.QCType != nil && containsOID(statements, qcstatements.QcTypeOID) {
+		if types, err := CertificateQCTypes(cert); err != nil || !containsOID(types, p.QCType) {
+			add(SeverityError, FindingWrongQCType, p.QCType, "QcType does not declare %s")
+		}
+	}
+	return findings, nil
+}
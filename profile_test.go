@@ -0,0 +1,109 @@
+package eidas
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+	"time"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// profileCertificate issues a certificate for a CSR generated with opts from
+// a CA with a subjectKeyIdentifier, so that it has an
+// authorityKeyIdentifier, adding a certificate policy.
+func profileCertificate(t *testing.T, qcType asn1.ObjectIdentifier, opts ...CertificateOption) *x509.Certificate {
+	csrDER, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcType, opts...)
+	So(err, ShouldBeNil)
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	So(err, ShouldBeNil)
+	tmpl, err := CertificateTemplate(csr, time.Hour)
+	So(err, ShouldBeNil)
+	// QCP-w from ETSI EN 319 411-2.
+	tmpl.PolicyIdentifiers = []asn1.ObjectIdentifier{{0, 4, 0, 194112, 1, 4}}
+
+	ca := newTestCA(t, "Test CA", nil)
+	cert, err := x509.ParseCertificate(ca.sign(t, tmpl, csr.PublicKey))
+	So(err, ShouldBeNil)
+	return cert
+}
+
+func TestCheckProfile(t *testing.T) {
+	kinds := func(findings []ProfileFinding) []string {
+		var k []string
+		for _, f := range findings {
+			k = append(k, f.Kind+" "+f.OID)
+		}
+		return k
+	}
+
+	Convey("conformant PSD2 QWAC", t, func() {
+		cert := profileCertificate(t, qcstatements.QWACType, WithQcCompliance(), WithDNSName("foo.example.com"))
+		findings, err := CheckProfile(ProfilePSD2QWAC, cert)
+		So(err, ShouldBeNil)
+		So(findings, ShouldBeEmpty)
+	})
+
+	Convey("PSD2 QWAC missing statements and extensions", t, func() {
+		cert := profileCertificate(t, qcstatements.QWACType)
+		findings, err := CheckProfile(ProfilePSD2QWAC, cert)
+		So(err, ShouldBeNil)
+		So(kinds(findings), ShouldResemble, []string{
+			"missing_statement 0.4.0.1862.1.1",
+			"missing_extension 2.5.29.17",
+		})
+		So(findings[0].Severity, ShouldEqual, SeverityError)
+		So(findings[0].Message, ShouldEqual, "missing statement QcCompliance (0.4.0.1862.1.1)")
+	})
+
+	Convey("QSEAL checked against the QWAC profile", t, func() {
+		cert := profileCertificate(t, qcstatements.QSEALType, WithQcCompliance())
+		findings, err := CheckProfile(ProfilePSD2QWAC, cert)
+		So(err, ShouldBeNil)
+		So(kinds(findings), ShouldResemble, []string{
+			"missing_extension 2.5.29.37",
+			"missing_extension 2.5.29.17",
+			"wrong_qc_type 0.4.0.1862.1.6.3",
+		})
+	})
+
+	Convey("conformant PSD2 QSEAL", t, func() {
+		cert := profileCertificate(t, qcstatements.QSEALType, WithQcCompliance())
+		findings, err := CheckProfile(ProfilePSD2QSEAL, cert)
+		So(err, ShouldBeNil)
+		So(findings, ShouldBeEmpty)
+	})
+
+	Convey("extra statements and extensions", t, func() {
+		cert := profileCertificate(t, qcstatements.QSEALType, WithQcCompliance(), WithLegalPersonSemantics())
+		err := RegisterProfile(Profile{
+			Name:               "strict-seal",
+			RequiredStatements: []asn1.ObjectIdentifier{qcstatements.QcComplianceOID, qcstatements.QcTypeOID, qcstatements.PSD2OID},
+			RequiredExtensions: []asn1.ObjectIdentifier{oidKeyUsage, QCStatementsExt},
+		})
+		So(err, ShouldBeNil)
+		findings, err := CheckProfile("strict-seal", cert)
+		So(err, ShouldBeNil)
+		// Extensions are in the order crypto/x509 encodes them.
+		k := kinds(findings)
+		So(k, ShouldHaveLength, 4)
+		So(k[0], ShouldEqual, "extra_statement 1.3.6.1.5.5.7.11.2")
+		So(k, ShouldContain, "extra_extension 2.5.29.14")
+		So(k, ShouldContain, "extra_extension 2.5.29.35")
+		So(k, ShouldContain, "extra_extension 2.5.29.32")
+		for _, f := range findings {
+			So(f.Severity, ShouldEqual, SeverityWarning)
+		}
+	})
+
+	Convey("registering a profile twice", t, func() {
+		So(RegisterProfile(Profile{Name: ProfilePSD2QWAC}), ShouldNotBeNil)
+		So(RegisterProfile(Profile{}), ShouldNotBeNil)
+	})
+
+	Convey("unknown profile", t, func() {
+		_, err := CheckProfile("no-such-profile", &x509.Certificate{})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,53 @@
+// Package psd2http provides net/http middleware that enforces PSD2 roles using
+// the QCStatements of a client's TLS certificate.
+package psd2http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/creditkudos/eidas"
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+type contextKey struct{}
+
+// RequireRoles returns middleware that rejects a request with 403 Forbidden
+// unless the client presented a verified certificate whose PSD2 statement
+// grants every one of the given roles. Apply it per route to require different
+// roles for different handlers. The extracted statement is available to the
+// wrapped handler through StatementFromContext.
+func RequireRoles(roles ...qcstatements.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ps, err := eidas.PeerStatementFromTLS(r.TLS)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			for _, role := range roles {
+				if !hasRole(ps.Roles, role) {
+					http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, ps)))
+		})
+	}
+}
+
+// StatementFromContext returns the client's PSD2 statement stored by
+// RequireRoles.
+func StatementFromContext(ctx context.Context) (*eidas.PeerStatement, bool) {
+	ps, ok := ctx.Value(contextKey{}).(*eidas.PeerStatement)
+	return ps, ok
+}
+
+func hasRole(roles []qcstatements.Role, role qcstatements.Role) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
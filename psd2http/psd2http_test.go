@@ -0,0 +1,95 @@
+package psd2http
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/creditkudos/eidas"
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+func clientCertificate(t *testing.T, roles []qcstatements.Role) *x509.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := qcstatements.CompetentAuthorityForCountryCode("GB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	qc, err := qcstatements.Serialize(roles, *ca, qcstatements.QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Foo Name"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{
+			Id:    eidas.QCStatementsExt,
+			Value: qc,
+		}},
+	}
+	d, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestRequireRoles(t *testing.T) {
+	cert := clientCertificate(t, []qcstatements.Role{qcstatements.RoleAccountInformation, qcstatements.RolePaymentInitiation})
+	verified := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+
+	var got *eidas.PeerStatement
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = StatementFromContext(r.Context())
+	})
+
+	for _, tc := range []struct {
+		name   string
+		state  *tls.ConnectionState
+		roles  []qcstatements.Role
+		status int
+	}{
+		{"all roles present", verified, []qcstatements.Role{qcstatements.RoleAccountInformation, qcstatements.RolePaymentInitiation}, http.StatusOK},
+		{"no roles required", verified, nil, http.StatusOK},
+		{"role missing", verified, []qcstatements.Role{qcstatements.RoleAccountServicing}, http.StatusForbidden},
+		{"plain HTTP", nil, []qcstatements.Role{qcstatements.RoleAccountInformation}, http.StatusForbidden},
+		{"unverified certificate", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, nil, http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest("GET", "/accounts", nil)
+			req.TLS = tc.state
+			rec := httptest.NewRecorder()
+			RequireRoles(tc.roles...)(handler).ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Errorf("Expected status %d but got %d", tc.status, rec.Code)
+			}
+			if tc.status == http.StatusOK && (got == nil || got.CAID != "GB-FCA") {
+				t.Errorf("Expected statement in context but got %v", got)
+			}
+			if tc.status != http.StatusOK && got != nil {
+				t.Error("Expected handler not to be called")
+			}
+		})
+	}
+}
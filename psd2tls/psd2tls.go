@@ -0,0 +1,81 @@
+// Package psd2tls fetches the PSD2 statement of a server's TLS certificate,
+// e.g. to monitor that a bank's QWAC is still valid and grants the expected
+// roles. It is kept apart from package eidas, which does not use the network.
+package psd2tls
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/creditkudos/eidas"
+)
+
+// Dialer opens network connections, as net.Dialer does.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Options configures FetchStatement.
+type Options struct {
+	// Config is the TLS configuration of the connection. If its ServerName
+	// is empty, the host of the address is used. If nil, the default
+	// configuration is used.
+	Config *tls.Config
+	// Dialer opens the TCP connection. If nil, a zero net.Dialer is used.
+	Dialer Dialer
+}
+
+// FetchStatement connects to the TLS server at addr, a "host:port", and
+// returns the PSD2 statement of its leaf certificate. The server's chain must
+// verify against opts.Config, so a configuration that skips verification
+// always fails. The connection is closed before FetchStatement returns, and
+// ctx bounds both the dial and the handshake.
+func FetchStatement(ctx context.Context, addr string, opts Options) (*eidas.PeerStatement, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("psd2tls: %v", err)
+	}
+	var config *tls.Config
+	if opts.Config != nil {
+		config = opts.Config.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	var dialer Dialer = &net.Dialer{}
+	if opts.Dialer != nil {
+		dialer = opts.Dialer
+	}
+
+	raw, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("psd2tls: %v", err)
+	}
+	conn := tls.Client(raw, config)
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if err := conn.Handshake(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("psd2tls: %v", ctx.Err())
+		}
+		return nil, fmt.Errorf("psd2tls: handshake with %s failed: %v", addr, err)
+	}
+	cs := conn.ConnectionState()
+	return eidas.PeerStatementFromTLS(&cs)
+}
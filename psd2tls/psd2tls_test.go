@@ -0,0 +1,94 @@
+package psd2tls
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/creditkudos/eidas"
+	"github.com/creditkudos/eidas/eidastest"
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+// redirectDialer dials addr whatever address it is asked for.
+type redirectDialer struct {
+	addr string
+}
+
+func (d redirectDialer) DialContext(ctx context.Context, network, _ string) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, d.addr)
+}
+
+func TestFetchStatement(t *testing.T) {
+	ca, err := eidastest.NewCA(eidastest.CAOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles := []qcstatements.Role{qcstatements.RolePaymentInitiation, qcstatements.RoleAccountInformation}
+	csr, key, err := eidas.GenerateCSR("GB", "Foo Bank", "PSDGB-FCA-123456", "bank.example.com", roles, qcstatements.QWACType,
+		eidas.WithDNSName("bank.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := ca.Issue(csr, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	dialer := redirectDialer{addr: ln.Addr().String()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ps, err := FetchStatement(ctx, "bank.example.com:443", Options{
+		Config: &tls.Config{RootCAs: ca.Pool()},
+		Dialer: dialer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ps.Roles, roles) {
+		t.Errorf("Expected roles %v, got %v", roles, ps.Roles)
+	}
+	if ps.CAID != "GB-FCA" {
+		t.Errorf("Expected competent authority GB-FCA, got %s", ps.CAID)
+	}
+	if len(ps.Chain) != 2 || !ps.Chain[0].Equal(cert) {
+		t.Errorf("Expected chain from the server's certificate, got %v", ps.Chain)
+	}
+
+	for name, config := range map[string]*tls.Config{
+		"untrusted root":    nil,
+		"wrong server name": {RootCAs: ca.Pool(), ServerName: "other.example.com"},
+		"unverified":        {InsecureSkipVerify: true},
+	} {
+		if _, err := FetchStatement(ctx, "bank.example.com:443", Options{Config: config, Dialer: dialer}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if _, err := FetchStatement(ctx, "bank.example.com", Options{Dialer: dialer}); err == nil {
+		t.Error("Expected an error for an address without a port")
+	}
+}
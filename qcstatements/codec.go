@@ -0,0 +1,206 @@
+package qcstatements
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"sort"
+)
+
+// Encoder serializes qualified statements with a fixed set of options. The
+// statements the options add are encoded once, by NewEncoder, rather than
+// on every call. An Encoder is safe for concurrent use.
+type Encoder struct {
+	preserveRoleOrder bool
+	omitPSD2          bool
+	roleOIDArc        asn1.ObjectIdentifier
+
+	// before are the statements preceding QcType, pds is the QcPDS statement
+	// if any, and after are the additional statements.
+	before []asn1.RawValue
+	pds    []byte
+	after  []asn1.RawValue
+}
+
+// NewEncoder returns an Encoder applying opts, as given to Serialize.
+func NewEncoder(opts ...Option) (*Encoder, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	e := &Encoder{
+		preserveRoleOrder: o.preserveRoleOrder,
+		omitPSD2:          o.omitPSD2,
+		roleOIDArc:        RoleOIDArc,
+	}
+	if o.roleOIDArc != nil {
+		if len(o.roleOIDArc) == 0 {
+			return nil, fmt.Errorf("role OID arc is empty")
+		}
+		e.roleOIDArc = o.roleOIDArc
+	}
+
+	var before []interface{}
+	if o.legalSemantics {
+		before = append(before, semanticsStatement{
+			OID:       PKIXQCSyntaxV2OID,
+			Semantics: semanticsInformation{Identifier: oidSemanticsLegal},
+		})
+	}
+	if o.compliance {
+		before = append(before, qcCompliance{OID: QcComplianceOID})
+	}
+	for _, st := range before {
+		d, err := asn1.Marshal(st)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshal eIDAS: %v", err)
+		}
+		e.before = append(e.before, asn1.RawValue{FullBytes: d})
+	}
+
+	if len(o.pds) != 0 {
+		locations := make([]pdsLocation, len(o.pds))
+		for i, l := range o.pds {
+			if err := l.validate(); err != nil {
+				return nil, err
+			}
+			locations[i] = pdsLocation{URL: l.URL, Language: l.Language}
+		}
+		d, err := asn1.Marshal(qcPDS{OID: QcPDSOID, Locations: locations})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshal eIDAS: %v", err)
+		}
+		e.pds = d
+	}
+
+	for _, d := range o.additional {
+		var st statement
+		rest, err := asn1.Unmarshal(d, &st)
+		if err != nil {
+			return nil, fmt.Errorf("invalid additional statement: %v", err)
+		}
+		if len(rest) != 0 {
+			return nil, fmt.Errorf("invalid additional statement: trailing data")
+		}
+		e.after = append(e.after, asn1.RawValue{FullBytes: d})
+	}
+	return e, nil
+}
+
+// Encode serializes roles, ca and the QC type t as Serialize does.
+func (e *Encoder) Encode(roles []Role, ca CompetentAuthority, t asn1.ObjectIdentifier) ([]byte, error) {
+	if e.omitPSD2 && len(roles) != 0 {
+		return nil, fmt.Errorf("roles given without a PSD2 statement: %v", roles)
+	}
+	if !e.omitPSD2 {
+		if err := ca.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := encodeRoles(roles, e.roleOIDArc)
+	if err != nil {
+		return nil, err
+	}
+	if !e.preserveRoleOrder {
+		sort.SliceStable(r, func(i, j int) bool {
+			return r[i].Role.Code() < r[j].Role.Code()
+		})
+	}
+
+	raw := make([]asn1.RawValue, 0, len(e.before)+3+len(e.after))
+	raw = append(raw, e.before...)
+	d, err := asn1.Marshal(qcType{
+		OID:    QcTypeOID,
+		Detail: []asn1.ObjectIdentifier{t},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal eIDAS: %v", err)
+	}
+	raw = append(raw, asn1.RawValue{FullBytes: d})
+	if e.pds != nil {
+		raw = append(raw, asn1.RawValue{FullBytes: e.pds})
+	}
+	if !e.omitPSD2 {
+		d, err := asn1.Marshal(qcStatement{
+			OID: PSD2OID,
+			RolesInfo: rolesInfo{
+				Roles:  r,
+				CAName: ca.Name,
+				CAID:   ca.ID,
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshal eIDAS: %v", err)
+		}
+		raw = append(raw, asn1.RawValue{FullBytes: d})
+	}
+	raw = append(raw, e.after...)
+
+	fin, err := asn1.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal eIDAS: %v", err)
+	}
+	return fin, nil
+}
+
+// ExtractedStatement is the content of an encoded qualified statement, as
+// returned by Decoder.
+type ExtractedStatement struct {
+	// Roles are read as by ExtractRoles.
+	Roles  []Role
+	CAName string
+	CAID   string
+	// Types are the QC types declared by the QcType statement, or nil if
+	// there is none.
+	Types []asn1.ObjectIdentifier
+}
+
+// Decoder extracts qualified statements, reusing its buffers from one call
+// to the next, so that a service decoding many certificates can pool
+// Decoders, e.g. in a sync.Pool, rather than allocating afresh each time. A
+// Decoder is not safe for concurrent use.
+type Decoder struct {
+	statements []statement
+	result     ExtractedStatement
+}
+
+// Decode extracts the roles, competent authority and QC types from an
+// encoded qualified statement, which must have a PSD2 statement. The result
+// is owned by the Decoder and is only valid until its next call to Decode.
+func (d *Decoder) Decode(data []byte) (*ExtractedStatement, error) {
+	statements, err := appendStatements(d.statements[:0], data)
+	if err != nil {
+		return nil, err
+	}
+	d.statements = statements
+
+	res := &d.result
+	res.Roles, res.CAName, res.CAID, res.Types = res.Roles[:0], "", "", nil
+	foundPSD2 := false
+	for _, st := range d.statements {
+		switch {
+		case st.OID.Equal(PSD2OID) && !foundPSD2:
+			info, err := parseRolesInfo(st.Info)
+			if err != nil {
+				return nil, decodeError(data, st.Raw, err)
+			}
+			for _, r := range info.Roles {
+				role, err := r.extract()
+				if err != nil {
+					return nil, decodeError(data, r.raw, err)
+				}
+				res.Roles = append(res.Roles, role.Role)
+			}
+			res.CAName, res.CAID = info.CAName, info.CAID
+			foundPSD2 = true
+		case st.OID.Equal(QcTypeOID) && res.Types == nil:
+			if _, err := asn1.Unmarshal(st.Info.FullBytes, &res.Types); err != nil {
+				return nil, decodeError(data, st.Raw, fmt.Errorf("QcType: %v", err))
+			}
+		}
+	}
+	if !foundPSD2 {
+		return nil, fmt.Errorf("failed to decode eIDAS: no PSD2 statement")
+	}
+	return res, nil
+}
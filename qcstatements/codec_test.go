@@ -0,0 +1,134 @@
+package qcstatements
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"reflect"
+	"testing"
+)
+
+func TestEncoder(t *testing.T) {
+	opts := []Option{WithLegalPersonSemantics(), WithCompliance(), WithPDS(PDSLocation{URL: "https://example.com/pds", Language: "en"})}
+	e, err := NewEncoder(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, roles := range [][]Role{{RoleAccountInformation}, AllRoles()} {
+		d, err := e.Encode(roles, defaultCA, QWACType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := Serialize(roles, defaultCA, QWACType, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(d, expected) {
+			t.Errorf("Encoder and Serialize differ for %v:\n%x\n%x", roles, d, expected)
+		}
+	}
+
+	if _, err := NewEncoder(WithPDS(PDSLocation{URL: "https://example.com/pds", Language: "english"})); err == nil {
+		t.Error("Expected error for invalid PDS location")
+	}
+	if _, err := NewEncoder(WithAdditionalStatements([]byte{0x30})); err == nil {
+		t.Error("Expected error for invalid additional statement")
+	}
+	omit, err := NewEncoder(OmitPSD2())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := omit.Encode([]Role{RoleAccountInformation}, defaultCA, QWACType); err == nil {
+		t.Error("Expected error for roles without a PSD2 statement")
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	var dec Decoder
+	for _, tc := range []struct {
+		roles []Role
+		t     asn1.ObjectIdentifier
+	}{
+		{AllRoles(), QSEALType},
+		{[]Role{RoleAccountInformation}, QWACType},
+	} {
+		d, err := Serialize(tc.roles, defaultCA, tc.t, WithCompliance())
+		if err != nil {
+			t.Fatal(err)
+		}
+		st, err := dec.Decode(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := &ExtractedStatement{
+			Roles:  tc.roles,
+			CAName: defaultCA.Name,
+			CAID:   defaultCA.ID,
+			Types:  []asn1.ObjectIdentifier{tc.t},
+		}
+		if !reflect.DeepEqual(st, expected) {
+			t.Errorf("Expected %+v but got %+v", expected, st)
+		}
+	}
+
+	noPSD2, err := Serialize(nil, CompetentAuthority{}, QWACType, OmitPSD2())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.Decode(noPSD2); err == nil {
+		t.Error("Expected error for statement without PSD2")
+	}
+	if _, err := dec.Decode([]byte{0x04, 0x00}); err == nil {
+		t.Error("Expected error for statement that is not a SEQUENCE")
+	}
+}
+
+func benchmarkStatement(b *testing.B) []byte {
+	d, err := Serialize(AllRoles(), defaultCA, QWACType, WithCompliance())
+	if err != nil {
+		b.Fatal(err)
+	}
+	return d
+}
+
+func BenchmarkExtract(b *testing.B) {
+	d := benchmarkStatement(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := Extract(d); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	d := benchmarkStatement(b)
+	var dec Decoder
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := dec.Decode(d); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSerialize(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Serialize(AllRoles(), defaultCA, QWACType, WithLegalPersonSemantics(), WithCompliance()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	e, err := NewEncoder(WithLegalPersonSemantics(), WithCompliance())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := e.Encode(AllRoles(), defaultCA, QWACType); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package qcstatements
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// CheckDER checks that data, e.g. the output of Serialize, is a single value
+// in canonical DER as defined in X.690 section 10: lengths are definite and
+// minimal, INTEGERs and BOOLEANs are minimally encoded, strings are
+// primitive, and the elements of each SET are sorted. Errors give the byte
+// offset of the offending value.
+func CheckDER(data []byte) error {
+	n, err := checkDERValue(data, 0)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return fmt.Errorf("at offset %d: trailing data after DER value", n)
+	}
+	return nil
+}
+
+// Universal tags with special DER rules.
+const (
+	derTagBoolean   = 1
+	derTagInteger   = 2
+	derTagBitString = 3
+	derTagSet       = 17
+)
+
+// derStringTags are the universal tags of string types, which DER requires
+// to be primitive.
+var derStringTags = map[int]bool{
+	3: true, 4: true, 12: true, 18: true, 19: true, 20: true, 21: true,
+	22: true, 25: true, 26: true, 27: true, 28: true, 30: true,
+}
+
+// checkDERValue checks the value at the start of data, which is at offset in
+// the outermost value, returning its encoded length.
+func checkDERValue(data []byte, offset int) (int, error) {
+	fail := func(format string, a ...interface{}) (int, error) {
+		return 0, fmt.Errorf("at offset %d: %s", offset, fmt.Sprintf(format, a...))
+	}
+	if len(data) < 2 {
+		return fail("truncated value")
+	}
+
+	class, compound, tag := int(data[0]>>6), data[0]&0x20 != 0, int(data[0]&0x1f)
+	i := 1
+	if tag == 0x1f {
+		tag = 0
+		for {
+			if i >= len(data) {
+				return fail("truncated tag")
+			}
+			b := data[i]
+			i++
+			if tag == 0 && b == 0x80 {
+				return fail("tag is not minimally encoded")
+			}
+			if tag > 1<<23 {
+				return fail("tag too large")
+			}
+			tag = tag<<7 | int(b&0x7f)
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		if tag < 0x1f {
+			return fail("tag %d should use the short form", tag)
+		}
+	}
+
+	if i >= len(data) {
+		return fail("truncated length")
+	}
+	length := int(data[i])
+	i++
+	if length == 0x80 {
+		return fail("indefinite length")
+	}
+	if length > 0x80 {
+		size := length & 0x7f
+		if size > 4 || i+size > len(data) {
+			return fail("invalid length")
+		}
+		if data[i] == 0 {
+			return fail("length has leading zeros")
+		}
+		length = 0
+		for _, b := range data[i : i+size] {
+			length = length<<8 | int(b)
+		}
+		i += size
+		if length < 0x80 {
+			return fail("length %d should use the short form", length)
+		}
+	}
+	if length > len(data)-i {
+		return fail("length %d exceeds the %d bytes available", length, len(data)-i)
+	}
+	content := data[i : i+length]
+	end := i + length
+
+	if class == 0 && !compound {
+		switch tag {
+		case derTagBoolean:
+			if length != 1 || (content[0] != 0 && content[0] != 0xff) {
+				return fail("BOOLEAN must be a single 0x00 or 0xff byte")
+			}
+		case derTagInteger:
+			if length == 0 {
+				return fail("empty INTEGER")
+			}
+			if length > 1 && ((content[0] == 0 && content[1]&0x80 == 0) || (content[0] == 0xff && content[1]&0x80 != 0)) {
+				return fail("INTEGER is not minimally encoded")
+			}
+		case derTagBitString:
+			if length == 0 || content[0] > 7 || (length == 1 && content[0] != 0) {
+				return fail("invalid BIT STRING padding")
+			}
+			if mask := byte(1)<<content[0] - 1; content[length-1]&mask != 0 {
+				return fail("BIT STRING padding bits are not zero")
+			}
+		}
+		return end, nil
+	}
+	if class == 0 && compound && derStringTags[tag] {
+		return fail("constructed string with tag %d", tag)
+	}
+	if !compound {
+		return end, nil
+	}
+
+	var elements [][]byte
+	for pos := 0; pos < length; {
+		n, err := checkDERValue(content[pos:], offset+i+pos)
+		if err != nil {
+			return 0, err
+		}
+		elements = append(elements, content[pos:pos+n])
+		pos += n
+	}
+	if class == 0 && tag == derTagSet {
+		for j := 1; j < len(elements); j++ {
+			if compareSetElements(elements[j-1], elements[j]) > 0 {
+				return fail("SET elements are not sorted")
+			}
+		}
+	}
+	return end, nil
+}
+
+// compareSetElements orders the encodings of SET elements as X.690 section
+// 11.6 requires: as octet strings, with the shorter padded with zeros.
+func compareSetElements(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	if c := bytes.Compare(a[:n], b[:n]); c != 0 {
+		return c
+	}
+	for _, x := range a[n:] {
+		if x != 0 {
+			return 1
+		}
+	}
+	for _, x := range b[n:] {
+		if x != 0 {
+			return -1
+		}
+	}
+	return 0
+}
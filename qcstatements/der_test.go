@@ -0,0 +1,49 @@
+package qcstatements
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestCheckDER(t *testing.T) {
+	d, err := Serialize(AllRoles(), defaultCA, QSEALType, WithLegalPersonSemantics(), WithCompliance(),
+		WithPDS(PDSLocation{URL: "https://example.com/pds_en.pdf", Language: "en"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckDER(d); err != nil {
+		t.Errorf("Expected Serialize output to be canonical DER: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		hex  string
+		err  string
+	}{
+		{"long form short length", "3081020500", "at offset 0: length 2 should use the short form"},
+		{"indefinite length", "308005000000", "at offset 0: indefinite length"},
+		{"length leading zero", "308200020500", "at offset 0: length has leading zeros"},
+		{"non-minimal integer", "30040202007f", "at offset 2: INTEGER is not minimally encoded"},
+		{"non-minimal boolean", "300301017f", "at offset 2: BOOLEAN must be a single 0x00 or 0xff byte"},
+		{"constructed string", "30062c040c026162", "at offset 2: constructed string with tag 12"},
+		{"unsorted set", "3106020102020101", "at offset 0: SET elements are not sorted"},
+		{"bit string padding", "03020101", "at offset 0: BIT STRING padding bits are not zero"},
+		{"trailing data", "050000", "at offset 2: trailing data after DER value"},
+		{"overlong length", "3005020100", "at offset 0: length 5 exceeds the 3 bytes available"},
+	} {
+		data, err := hex.DecodeString(tc.hex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = CheckDER(data)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected error %q, got %v", tc.name, tc.err, err)
+		}
+	}
+
+	sorted, _ := hex.DecodeString("3106020101020102")
+	if err := CheckDER(sorted); err != nil {
+		t.Errorf("Expected sorted SET to be canonical DER: %v", err)
+	}
+}
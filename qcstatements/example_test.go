@@ -0,0 +1,35 @@
+package qcstatements_test
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+func ExampleExtract() {
+	ca, err := qcstatements.CompetentAuthorityForCountryCode("GB")
+	if err != nil {
+		log.Fatal(err)
+	}
+	d, err := qcstatements.Serialize([]qcstatements.Role{qcstatements.RoleAccountInformation}, *ca, qcstatements.QSEALType)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	roles, name, id, err := qcstatements.Extract(d)
+	if err != nil {
+		log.Fatal(err)
+	}
+	t, err := qcstatements.ExtractType(d)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(roles)
+	fmt.Println(name, id)
+	fmt.Println(qcstatements.QCTypeForOID(t))
+	// Output:
+	// [PSP_AI]
+	// Financial Conduct Authority GB-FCA
+	// QSEAL
+}
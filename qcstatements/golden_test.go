@@ -0,0 +1,113 @@
+package qcstatements
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// TestGolden compares serialized statements byte-for-byte against the files in
+// testdata, which are of two kinds:
+//
+//   - psd2_csr_profiles_* are reference vectors, the single-role examples in
+//     "eIDAS PSD2 Certificate Signing Request Profiles" Issue: 2.2, dated 20th
+//     May 2020, also checked by TestAll. They are never rewritten.
+//
+//   - the others are snapshots of Serialize's own output, to catch unintended
+//     changes to the encoding. They cannot catch a bug that was present when
+//     they were made. If an encoding change is intended, regenerate them with:
+//
+//     go test ./qcstatements -run TestGolden -update
+func TestGolden(t *testing.T) {
+	ie := CompetentAuthority{Name: "Central Bank of Ireland", ID: "IE-CBI"}
+	for _, tc := range []struct {
+		name      string
+		reference bool
+		roles     []Role
+		ca        CompetentAuthority
+		t         asn1.ObjectIdentifier
+		opts      []Option
+	}{
+		{
+			name:      "psd2_csr_profiles_qwac_psp_as",
+			reference: true,
+			roles:     []Role{RoleAccountServicing},
+			ca:        defaultCA,
+			t:         QWACType,
+		},
+		{
+			name:      "psd2_csr_profiles_qseal_psp_as",
+			reference: true,
+			roles:     []Role{RoleAccountServicing},
+			ca:        defaultCA,
+			t:         QSEALType,
+		},
+		{
+			name:      "psd2_csr_profiles_qseal_psp_ai",
+			reference: true,
+			roles:     []Role{RoleAccountInformation},
+			ca:        defaultCA,
+			t:         QSEALType,
+		},
+		{
+			name:  "qwac_all_roles",
+			roles: []Role{RoleAccountServicing, RolePaymentInitiation, RoleAccountInformation, RolePaymentInstruments},
+			ca:    defaultCA,
+			t:     QWACType,
+		},
+		{
+			name:  "qseal_ie_pi_ai",
+			roles: []Role{RolePaymentInitiation, RoleAccountInformation},
+			ca:    ie,
+			t:     QSEALType,
+		},
+		{
+			name:  "qwac_compliance_pds",
+			roles: []Role{RoleAccountInformation},
+			ca:    defaultCA,
+			t:     QWACType,
+			opts: []Option{
+				WithCompliance(),
+				WithPDS(PDSLocation{URL: "https://example.com/pds_en.pdf", Language: "en"}),
+			},
+		},
+		{
+			name:  "qseal_legal_semantics",
+			roles: []Role{RoleAccountInformation},
+			ca:    defaultCA,
+			t:     QSEALType,
+			opts:  []Option{WithLegalPersonSemantics(), WithCompliance()},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := Serialize(tc.roles, tc.ca, tc.t, tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := hex.EncodeToString(d)
+
+			path := filepath.Join("testdata", tc.name+".golden")
+			if *update && !tc.reference {
+				if err := ioutil.WriteFile(path, []byte(got+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != strings.TrimSpace(string(want)) {
+				t.Errorf("Mismatch with %s:\n got: %s\nwant: %s", path, got, want)
+			}
+			if err := CheckDER(d); err != nil {
+				t.Errorf("%s is not canonical DER: %v", path, err)
+			}
+		})
+	}
+}
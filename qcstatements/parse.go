@@ -0,0 +1,274 @@
+package qcstatements
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"unicode/utf8"
+)
+
+// This file decodes the PSD2 statement from its raw elements rather than with
+// struct tags, so that statements from issuers whose tagging differs from ETSI
+// TS 119 495 can still be read. A SEQUENCE may be replaced by an IMPLICIT
+// context-specific tag or wrapped in an EXPLICIT one, strings may use any
+// common string type, and primitives may be implicitly tagged.
+
+// parsedRolesInfo is the content of a PSD2 statement.
+type parsedRolesInfo struct {
+	Roles  []parsedRole
+	CAName string
+	CAID   string
+}
+
+// DecodeError is a malformed element in an encoded qualified statement. Its
+// message gives the offset of the element and the bytes around it, marked
+// with "|", to help find the problem in a particular issuer's encoding.
+type DecodeError struct {
+	// Offset is the position of the malformed element in the encoding.
+	Offset int
+	Err    error
+	data   []byte
+}
+
+// decodeErrorContext is how many bytes either side of the offset a
+// DecodeError shows.
+const decodeErrorContext = 8
+
+func (e *DecodeError) Error() string {
+	start, end := e.Offset-decodeErrorContext, e.Offset+2*decodeErrorContext
+	if start < 0 {
+		start = 0
+	}
+	if end > len(e.data) {
+		end = len(e.data)
+	}
+	return fmt.Sprintf("failed to decode eIDAS at offset %d: %v [% x | % x]", e.Offset, e.Err, e.data[start:e.Offset], e.data[e.Offset:end])
+}
+
+// Unwrap returns the error describing what is wrong with the element.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// elementError is an error located at the encoding of an element, which
+// decodeError turns into a DecodeError.
+type elementError struct {
+	at  []byte
+	err error
+}
+
+func (e *elementError) Error() string {
+	return e.err.Error()
+}
+
+// errorAt locates err at an element, unless it is already located at one
+// nested inside it.
+func errorAt(at []byte, err error) error {
+	if _, ok := err.(*elementError); ok {
+		return err
+	}
+	return &elementError{at: at, err: err}
+}
+
+// prefixError adds a prefix to the message of err, keeping its location.
+func prefixError(prefix string, err error) error {
+	if e, ok := err.(*elementError); ok {
+		return &elementError{at: e.at, err: fmt.Errorf("%s: %v", prefix, e.err)}
+	}
+	return fmt.Errorf("%s: %v", prefix, err)
+}
+
+// decodeError returns a DecodeError for err in data, at the element err is
+// located at or, if it is not located, at fallback. Both must be subslices of
+// data, as the values decoded from it by encoding/asn1 are.
+func decodeError(data, fallback []byte, err error) error {
+	at := fallback
+	if e, ok := err.(*elementError); ok {
+		if _, ok := offsetIn(data, e.at); ok {
+			at = e.at
+		}
+		err = e.err
+	}
+	offset, _ := offsetIn(data, at)
+	return &DecodeError{Offset: offset, Err: err, data: data}
+}
+
+// offsetIn returns the offset of sub in data, which it shares a backing array
+// with if it was sliced from data.
+func offsetIn(data, sub []byte) (int, bool) {
+	offset := cap(data) - cap(sub)
+	if len(sub) == 0 || offset < 0 || offset+len(sub) > len(data) {
+		return 0, false
+	}
+	return offset, true
+}
+
+// appendStatements decodes the SEQUENCE OF QCStatement in data, appending
+// the statements to dst.
+func appendStatements(dst []statement, data []byte) ([]statement, error) {
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(data, &seq); err != nil {
+		return nil, decodeError(data, data, err)
+	}
+	if seq.Class != asn1.ClassUniversal || seq.Tag != asn1.TagSequence || !seq.IsCompound {
+		return nil, decodeError(data, data, fmt.Errorf("expected SEQUENCE, got class %d tag %d", seq.Class, seq.Tag))
+	}
+	for rest := seq.Bytes; len(rest) != 0; {
+		var st statement
+		next, err := asn1.Unmarshal(rest, &st)
+		if err != nil {
+			return nil, decodeError(data, rest, err)
+		}
+		dst = append(dst, st)
+		rest = next
+	}
+	return dst, nil
+}
+
+// parseRolesInfo decodes the statementInfo of a PSD2 statement. Fields after
+// CAID are ignored.
+func parseRolesInfo(v asn1.RawValue) (*parsedRolesInfo, error) {
+	fields, err := sequenceElements(v, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < 3 {
+		return nil, errorAt(v.FullBytes, fmt.Errorf("expected roles, CA name and CA ID, got %d fields", len(fields)))
+	}
+
+	rawRoles, err := sequenceElements(fields[0], true)
+	if err != nil {
+		return nil, prefixError("roles", err)
+	}
+	info := &parsedRolesInfo{Roles: make([]parsedRole, len(rawRoles))}
+	for i, raw := range rawRoles {
+		if info.Roles[i], err = parseRole(raw); err != nil {
+			return nil, err
+		}
+	}
+
+	var ok bool
+	if info.CAName, ok, err = decodeString(fields[1]); err != nil || !ok {
+		return nil, notStringError("CA name", fields[1], err)
+	}
+	if info.CAID, ok, err = decodeString(fields[2]); err != nil || !ok {
+		return nil, notStringError("CA ID", fields[2], err)
+	}
+	return info, nil
+}
+
+// notStringError reports that the named field v could not be decoded by
+// decodeString, which returned err.
+func notStringError(field string, v asn1.RawValue, err error) error {
+	if err == nil {
+		err = fmt.Errorf("%s is not a string: class %d tag %d", field, v.Class, v.Tag)
+	} else {
+		err = fmt.Errorf("%s is not a string: %v", field, err)
+	}
+	return errorAt(v.FullBytes, err)
+}
+
+func parseRole(v asn1.RawValue) (parsedRole, error) {
+	fields, err := sequenceElements(v, false)
+	if err != nil {
+		return parsedRole{}, prefixError("role", err)
+	}
+	if len(fields) == 0 {
+		return parsedRole{}, errorAt(v.FullBytes, fmt.Errorf("role has no OID"))
+	}
+	r := parsedRole{raw: v.FullBytes}
+	if r.OID, err = decodeOID(fields[0]); err != nil {
+		return parsedRole{}, errorAt(fields[0].FullBytes, fmt.Errorf("role: %v", err))
+	}
+	if len(fields) > 1 {
+		if r.Name, r.HasName, err = decodeString(fields[1]); err != nil {
+			return parsedRole{}, errorAt(fields[1].FullBytes, fmt.Errorf("role name: %v", err))
+		}
+	}
+	return r, nil
+}
+
+// sequenceElements returns the elements of v, which should be a SEQUENCE.
+// A context-specific tag is taken to be EXPLICIT if it holds a single
+// SEQUENCE, and IMPLICIT otherwise. If compound is set, every element must
+// be constructed; this resolves an IMPLICIT SEQUENCE OF with one element.
+func sequenceElements(v asn1.RawValue, compound bool) ([]asn1.RawValue, error) {
+	var candidates [][]byte
+	switch {
+	case v.Class == asn1.ClassUniversal && v.Tag == asn1.TagSequence && v.IsCompound:
+		candidates = [][]byte{v.Bytes}
+	case v.Class == asn1.ClassContextSpecific && v.IsCompound:
+		var inner asn1.RawValue
+		if rest, err := asn1.Unmarshal(v.Bytes, &inner); err == nil && len(rest) == 0 &&
+			inner.Class == asn1.ClassUniversal && inner.Tag == asn1.TagSequence && inner.IsCompound {
+			candidates = append(candidates, inner.Bytes)
+		}
+		candidates = append(candidates, v.Bytes)
+	default:
+		return nil, errorAt(v.FullBytes, fmt.Errorf("expected SEQUENCE, got class %d tag %d", v.Class, v.Tag))
+	}
+
+	var err error
+	for _, c := range candidates {
+		var elements []asn1.RawValue
+		if elements, err = rawElements(c, compound); err == nil {
+			return elements, nil
+		}
+	}
+	return nil, err
+}
+
+func rawElements(data []byte, compound bool) ([]asn1.RawValue, error) {
+	elements := make([]asn1.RawValue, 0)
+	for len(data) != 0 {
+		var e asn1.RawValue
+		rest, err := asn1.Unmarshal(data, &e)
+		if err != nil {
+			return nil, errorAt(data, err)
+		}
+		if compound && !e.IsCompound {
+			return nil, errorAt(e.FullBytes, fmt.Errorf("expected constructed element, got class %d tag %d", e.Class, e.Tag))
+		}
+		elements = append(elements, e)
+		data = rest
+	}
+	return elements, nil
+}
+
+// decodeString decodes a UTF8String, PrintableString, IA5String or an
+// implicitly tagged string. ok is false if v is not a string or is not valid
+// UTF-8. Constructed strings are not allowed in DER and are an error.
+func decodeString(v asn1.RawValue) (s string, ok bool, err error) {
+	switch {
+	case v.Class == asn1.ClassUniversal:
+		switch v.Tag {
+		case asn1.TagUTF8String, asn1.TagPrintableString, asn1.TagIA5String:
+		default:
+			return "", false, nil
+		}
+	case v.Class != asn1.ClassContextSpecific:
+		return "", false, nil
+	}
+	if v.IsCompound {
+		return "", false, fmt.Errorf("constructed string with tag %d", v.Tag)
+	}
+	if !utf8.Valid(v.Bytes) {
+		return "", false, nil
+	}
+	return string(v.Bytes), true, nil
+}
+
+// decodeOID decodes an OBJECT IDENTIFIER, which may be implicitly tagged.
+func decodeOID(v asn1.RawValue) (asn1.ObjectIdentifier, error) {
+	if v.IsCompound || !(v.Class == asn1.ClassContextSpecific || v.Class == asn1.ClassUniversal && v.Tag == asn1.TagOID) {
+		return nil, fmt.Errorf("expected OBJECT IDENTIFIER, got class %d tag %d", v.Class, v.Tag)
+	}
+	d, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagOID, Bytes: v.Bytes})
+	if err != nil {
+		return nil, err
+	}
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(d, &oid); err != nil {
+		return nil, err
+	}
+	return oid, nil
+}
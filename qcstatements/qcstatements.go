@@ -7,6 +7,13 @@ import (
 	"encoding/asn1"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Role represents the role of the Payment Service Provider (PSP).
@@ -20,26 +27,188 @@ const (
 	RolePaymentInstruments Role = "PSP_IC"
 )
 
+// ParseRoles converts role strings, such as those read from CSV or command
+// line input, to Roles. Surrounding whitespace is ignored and an error is
+// returned for any string that is not a known role.
+func ParseRoles(roles []string) ([]Role, error) {
+	r := make([]Role, len(roles))
+	for i, s := range roles {
+		r[i] = Role(strings.TrimSpace(s))
+		if !isKnownRole(r[i]) {
+			return nil, fmt.Errorf("unknown role: %q", s)
+		}
+	}
+	return r, nil
+}
+
+// AllRoles returns every role accepted by Serialize, ordered by their numeric
+// code.
+func AllRoles() []Role {
+	roleRegistry.RLock()
+	roles := make([]Role, 0, len(roleRegistry.m))
+	for r := range roleRegistry.m {
+		roles = append(roles, r)
+	}
+	roleRegistry.RUnlock()
+	sortRoles(roles)
+	return roles
+}
+
+// RolesEqual reports whether a and b contain the same roles, ignoring order and
+// duplicates.
+func RolesEqual(a, b []Role) bool {
+	added, removed := RolesDiff(a, b)
+	return len(added) == 0 && len(removed) == 0
+}
+
+// RolesDiff returns the roles present in updated but not in original, and
+// those present in original but not in updated. Duplicates are ignored and
+// each result is sorted.
+func RolesDiff(original, updated []Role) (added []Role, removed []Role) {
+	inOriginal := make(map[Role]bool)
+	for _, r := range original {
+		inOriginal[r] = true
+	}
+	inUpdated := make(map[Role]bool)
+	for _, r := range updated {
+		inUpdated[r] = true
+	}
+	for r := range inUpdated {
+		if !inOriginal[r] {
+			added = append(added, r)
+		}
+	}
+	for r := range inOriginal {
+		if !inUpdated[r] {
+			removed = append(removed, r)
+		}
+	}
+	sortRoles(added)
+	sortRoles(removed)
+	return added, removed
+}
+
+// sortRoles sorts roles by their numeric code, with unknown roles last.
+func sortRoles(roles []Role) {
+	sort.Slice(roles, func(i, j int) bool {
+		ci, oki := lookupRole(roles[i])
+		cj, okj := lookupRole(roles[j])
+		if oki != okj {
+			return oki
+		}
+		if ci.code != cj.code {
+			return ci.code < cj.code
+		}
+		return roles[i] < roles[j]
+	})
+}
+
 // CompetentAuthority under PSD2.
 type CompetentAuthority struct {
 	// Name of the authority, e.g. "Financial Conduct Authority".
 	Name string
 	// NCA identifier of the authority, e.g. "GB-FCA".
 	ID string
+	// Unverified is set when the authority was derived from an identifier
+	// rather than found in a known list, in which case Name is empty.
+	Unverified bool
+}
+
+// maxCompetentAuthorityLength is the most characters TS 119 495 allows in
+// the NCAName and NCAId of a PSD2 statement.
+const maxCompetentAuthorityLength = 256
+
+// Validate checks that ca can be encoded in a PSD2 statement. ID must be an
+// NCA identifier as defined by ETSI TS 119 495: an ISO 3166-1 alpha-2
+// country code, a hyphen and 2 to 8 upper case letters, e.g. "GB-FCA". Name
+// must be 1 to 256 characters, unless ca is Unverified, when it may be
+// empty.
+func (ca CompetentAuthority) Validate() error {
+	if !isNCAID(ca.ID) {
+		return fmt.Errorf("invalid competent authority ID %q: expected country code, hyphen and 2 to 8 letters, e.g. GB-FCA", ca.ID)
+	}
+	if ca.Name == "" && !ca.Unverified {
+		return fmt.Errorf("competent authority %s has no name", ca.ID)
+	}
+	if !utf8.ValidString(ca.Name) || utf8.RuneCountInString(ca.Name) > maxCompetentAuthorityLength {
+		return fmt.Errorf("competent authority %s name must be at most %d characters of UTF-8", ca.ID, maxCompetentAuthorityLength)
+	}
+	return nil
+}
+
+// isNCAID reports whether id is a country code, a hyphen and 2 to 8 upper
+// case letters.
+func isNCAID(id string) bool {
+	if len(id) < 5 || len(id) > 11 || id[2] != '-' {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if i != 2 && (id[i] < 'A' || id[i] > 'Z') {
+			return false
+		}
+	}
+	return true
 }
 
+// DeriveCompetentAuthority returns an unverified CompetentAuthority for an
+// NCA that is not in the built-in list, built from the country code and NCA
+// identifier of an organizationIdentifier, e.g. "GB" and "FCA".
+func DeriveCompetentAuthority(countryCode, nca string) (*CompetentAuthority, error) {
+	if len(countryCode) != 2 || !isNCAID(countryCode+"-"+nca) {
+		return nil, fmt.Errorf("cannot derive competent authority from %q and %q", countryCode, nca)
+	}
+	return &CompetentAuthority{
+		ID:         countryCode + "-" + nca,
+		Unverified: true,
+	}, nil
+}
+
+// CompetentAuthorityResolver looks up the competent authority for a country.
+type CompetentAuthorityResolver interface {
+	// For returns the competent authority for an ISO-3166-1 alpha-2 country
+	// code, or an error if there is none.
+	For(countryCode string) (*CompetentAuthority, error)
+}
+
+// CompetentAuthorityMap is a CompetentAuthorityResolver backed by a map from
+// ISO-3166-1 alpha-2 country codes. It is safe for concurrent use as long as
+// the map is not modified.
+type CompetentAuthorityMap map[string]*CompetentAuthority
+
+// For implements CompetentAuthorityResolver. It returns a copy, so that
+// callers cannot modify the map's entries.
+func (m CompetentAuthorityMap) For(code string) (*CompetentAuthority, error) {
+	if ca, ok := m[code]; ok {
+		c := *ca
+		return &c, nil
+	}
+	return nil, fmt.Errorf("unknown country code: %s", code)
+}
+
+// DefaultResolver resolves competent authorities from the built-in list.
+var DefaultResolver CompetentAuthorityResolver = caMap
+
 // CompetentAuthorityForCountryCode returns the correct competent authority
 // string, e.g., "GB-FCA", based on the given country code.
 func CompetentAuthorityForCountryCode(code string) (*CompetentAuthority, error) {
-	if ca, ok := caMap[code]; ok {
-		return ca, nil
+	return caMap.For(code)
+}
+
+// CompetentAuthorityForID returns the competent authority in the built-in
+// list with the given NCA identifier, e.g. "GB-FCA".
+func CompetentAuthorityForID(id string) (*CompetentAuthority, error) {
+	for _, ca := range caMap {
+		if ca.ID == id {
+			c := *ca
+			return &c, nil
+		}
 	}
-	return nil, fmt.Errorf("unknown country code: %s", code)
+	return nil, fmt.Errorf("unknown competent authority: %s", id)
 }
 
 // Maps ISO-3166-1 alpha-2 codes to a CompetentAuthority.
 // See ETSI TS 119 495 V1.2.1 (2018-11) Annex D.
-var caMap = map[string]*CompetentAuthority{
+var caMap = CompetentAuthorityMap{
 	"AT": {
 		ID:   "AT-FMA",
 		Name: "Austria Financial Market Authority",
@@ -166,35 +335,210 @@ var caMap = map[string]*CompetentAuthority{
 	},
 }
 
-var roleMap = map[Role]int{
-	RoleAccountServicing:   1,
-	RolePaymentInitiation:  2,
-	RoleAccountInformation: 3,
-	RolePaymentInstruments: 4,
+// roleInfo is what is known about a registered role.
+type roleInfo struct {
+	code        int
+	description string
+}
+
+// roleRegistry holds the standard roles, with their names from ETSI TS 119
+// 495 section 5.1, and any added with RegisterRole.
+var roleRegistry = struct {
+	sync.RWMutex
+	m map[Role]roleInfo
+}{
+	m: map[Role]roleInfo{
+		RoleAccountServicing:   {1, "Account Servicing Payment Service Provider"},
+		RolePaymentInitiation:  {2, "Payment Initiation Service Provider"},
+		RoleAccountInformation: {3, "Account Information Service Provider"},
+		RolePaymentInstruments: {4, "Payment Service Provider issuing card-based payment instruments"},
+	},
+}
+
+func lookupRole(r Role) (roleInfo, bool) {
+	roleRegistry.RLock()
+	defer roleRegistry.RUnlock()
+	info, ok := roleRegistry.m[r]
+	return info, ok
+}
+
+func isKnownRole(r Role) bool {
+	_, ok := lookupRole(r)
+	return ok
+}
+
+// RegisterRole adds a role beyond the four standard ones, identified by the
+// object identifier 0.4.0.19495.1.code, so that it is accepted by Serialize
+// and ParseRoles and recognized by Extract. It is an error to register a code
+// or role that is already taken.
+func RegisterRole(code int, role Role) error {
+	if code <= 0 {
+		return fmt.Errorf("invalid role code: %d", code)
+	}
+	if role == "" {
+		return fmt.Errorf("role for code %d has no name", code)
+	}
+	roleRegistry.Lock()
+	defer roleRegistry.Unlock()
+	if _, ok := roleRegistry.m[role]; ok {
+		return fmt.Errorf("role %s is already registered", role)
+	}
+	for r, info := range roleRegistry.m {
+		if info.code == code {
+			return fmt.Errorf("role code %d is already taken by %s", code, r)
+		}
+	}
+	roleRegistry.m[role] = roleInfo{code: code}
+	return nil
+}
+
+// Code returns the numeric code of the role, N in the role's object
+// identifier 0.4.0.19495.1.N, or 0 for an unknown role.
+func (r Role) Code() int {
+	info, _ := lookupRole(r)
+	return info.code
+}
+
+// RoleFromCode returns the role with the given numeric code.
+func RoleFromCode(n int) (Role, error) {
+	roleRegistry.RLock()
+	defer roleRegistry.RUnlock()
+	for r, info := range roleRegistry.m {
+		if info.code == n {
+			return r, nil
+		}
+	}
+	return "", fmt.Errorf("unknown role code: %d", n)
+}
+
+// Description returns the descriptive name of the role, e.g. "Account
+// Information Service Provider", or an empty string for an unknown role or
+// one added with RegisterRole.
+func (r Role) Description() string {
+	info, _ := lookupRole(r)
+	return info.description
+}
+
+var oidSemanticsLegal = asn1.ObjectIdentifier{0, 4, 0, 194121, 1, 2}
+
+// Statement identifiers from ETSI EN 319 412-5 and TS 119 495.
+var (
+	// QcComplianceOID identifies the QcCompliance statement.
+	QcComplianceOID = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 1}
+	// QcPDSOID identifies the QcPDS statement.
+	QcPDSOID = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 5}
+	// QcTypeOID identifies the QcType statement. The QC types, ESignType,
+	// QSEALType and QWACType, are under this arc.
+	QcTypeOID = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 6}
+	// PSD2OID identifies the PSD2 statement holding the roles and competent
+	// authority.
+	PSD2OID = asn1.ObjectIdentifier{0, 4, 0, 19495, 2}
+	// RoleOIDArc is the arc of the role identifiers: the role with code N is
+	// identified by RoleOIDArc followed by N.
+	RoleOIDArc = asn1.ObjectIdentifier{0, 4, 0, 19495, 1}
+	// PKIXQCSyntaxV2OID identifies the RFC 3739 semantics information
+	// statement, id-qcs-pkixQCSyntax-v2, which WithLegalPersonSemantics adds.
+	PKIXQCSyntaxV2OID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 11, 2}
+)
+
+// statementNames are human-readable names for the statements we know about.
+var statementNames = []struct {
+	OID  asn1.ObjectIdentifier
+	Name string
+}{
+	{QcComplianceOID, "QcCompliance"},
+	{QcTypeOID, "QcType"},
+	{PSD2OID, "PSD2 RolesInfo"},
+	{QcPDSOID, "QcPDS"},
+}
+
+// statement is a generic QCStatement as defined in RFC 3739. Raw is set to
+// the encoding of a decoded statement.
+type statement struct {
+	Raw  asn1.RawContent
+	OID  asn1.ObjectIdentifier
+	Info asn1.RawValue `asn1:"optional"`
+}
+
+type semanticsStatement struct {
+	OID       asn1.ObjectIdentifier
+	Semantics semanticsInformation
+}
+
+type semanticsInformation struct {
+	Identifier asn1.ObjectIdentifier
 }
 
-type root struct {
-	QcType      qcType
-	QcStatement qcStatement
+type qcCompliance struct {
+	OID asn1.ObjectIdentifier
 }
 
+// qcType is the QcType statement. Its statementInfo is
+// "QcType ::= SEQUENCE OF OBJECT IDENTIFIER" (EN 319 412-5 Annex B), which
+// encoding/asn1 produces for the Detail slice.
 type qcType struct {
 	OID    asn1.ObjectIdentifier
 	Detail []asn1.ObjectIdentifier
 }
 
 var (
+	// ESignType is the ASN.1 object identifier, id-etsi-qct-esign, for
+	// certificates for electronic signatures by natural persons.
+	ESignType = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 6, 1}
 	// QSEALType is the ASN.1 object identifier for QSeal certificates.
 	QSEALType = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 6, 2}
 	// QWACType is the ASN.1 object identifier for QWA certificates.
 	QWACType = asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 6, 3}
 )
 
+// QCType is a recognized QC type.
+type QCType string
+
+// Recognized QC types.
+const (
+	QCTypeUnknown QCType = ""
+	QCTypeESign   QCType = "ESIGN"
+	QCTypeQSEAL   QCType = "QSEAL"
+	QCTypeQWAC    QCType = "QWAC"
+)
+
+// AllQCTypes returns the object identifiers of every QC type supported by
+// Serialize. Use QCTypeForOID for their names.
+func AllQCTypes() []asn1.ObjectIdentifier {
+	return []asn1.ObjectIdentifier{ESignType, QSEALType, QWACType}
+}
+
+// QCTypeForOID returns the QCType identified by oid, or QCTypeUnknown.
+func QCTypeForOID(oid asn1.ObjectIdentifier) QCType {
+	switch {
+	case oid.Equal(ESignType):
+		return QCTypeESign
+	case oid.Equal(QSEALType):
+		return QCTypeQSEAL
+	case oid.Equal(QWACType):
+		return QCTypeQWAC
+	}
+	return QCTypeUnknown
+}
+
+type qcPDS struct {
+	OID       asn1.ObjectIdentifier
+	Locations []pdsLocation
+}
+
+type pdsLocation struct {
+	URL      string `asn1:"ia5"`
+	Language string `asn1:"printable"`
+}
+
 type qcStatement struct {
 	OID       asn1.ObjectIdentifier
 	RolesInfo rolesInfo
 }
 
+// rolesInfo is the PSD2 statement info, as encoded by Serialize. Statements
+// are decoded by parseRolesInfo, which also skips any fields some issuers
+// append after CAID.
 type rolesInfo struct {
 	Roles  []role
 	CAName string `asn1:"utf8"`
@@ -207,55 +551,262 @@ type role struct {
 	Role Role
 }
 
-// Serialize will serialize the given roles and CA information into a DER encoded ASN.1 qualified statement. qcType should be one of QWACType or QSEALType.
-func Serialize(roles []Role, ca CompetentAuthority, t asn1.ObjectIdentifier) ([]byte, error) {
+// PDSLocation is the location of a PKI Disclosure Statement.
+type PDSLocation struct {
+	// URL of the disclosure statement, e.g. "https://example.com/pds_en.pdf".
+	URL string
+	// Language is the ISO 639-1 language code of the statement, e.g. "en".
+	Language string
+}
+
+// validate checks that l can be encoded with the string types required by
+// EN 319 412-5: an IA5String URL and a two letter PrintableString language.
+// Non-ASCII URLs must be percent-encoded by the caller.
+func (l PDSLocation) validate() error {
+	if l.URL == "" {
+		return fmt.Errorf("PDS location has no URL")
+	}
+	for _, c := range l.URL {
+		if c > 0x7f {
+			return fmt.Errorf("PDS URL %q is not a valid IA5String", l.URL)
+		}
+	}
+	if len(l.Language) != 2 || !isLetter(l.Language[0]) || !isLetter(l.Language[1]) {
+		return fmt.Errorf("PDS language %q is not a two letter ISO 639-1 code", l.Language)
+	}
+	return nil
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// Option adds optional statements to those built by Serialize.
+type Option func(*options)
+
+type options struct {
+	legalSemantics    bool
+	compliance        bool
+	pds               []PDSLocation
+	preserveRoleOrder bool
+	omitPSD2          bool
+	roleOIDArc        asn1.ObjectIdentifier
+	additional        [][]byte
+}
+
+// WithLegalPersonSemantics adds an RFC 3739 semantics statement asserting
+// id-etsi-qcs-semanticsId-Legal from ETSI EN 319 412-1, declaring that the
+// subject's organizationIdentifier identifies a legal person.
+func WithLegalPersonSemantics() Option {
+	return func(o *options) {
+		o.legalSemantics = true
+	}
+}
+
+// WithCompliance adds the QcCompliance statement, declaring the certificate
+// to be an EU qualified certificate.
+func WithCompliance() Option {
+	return func(o *options) {
+		o.compliance = true
+	}
+}
+
+// WithPDS adds a QcPDS statement listing the given PKI Disclosure Statements.
+func WithPDS(locations ...PDSLocation) Option {
+	return func(o *options) {
+		o.pds = append(o.pds, locations...)
+	}
+}
+
+// WithAdditionalStatements appends pre-encoded QCStatements, each the DER
+// encoding of a complete QCStatement SEQUENCE, after the statements Serialize
+// builds itself. It allows statements this package does not support to be
+// included. Serialize only checks that each is a well-formed statement; the
+// caller is responsible for its content.
+func WithAdditionalStatements(statements ...[]byte) Option {
+	return func(o *options) {
+		o.additional = append(o.additional, statements...)
+	}
+}
+
+// WithRoleOIDArc makes Serialize identify the role with code N by arc
+// followed by N, rather than RoleOIDArc, 0.4.0.19495.1, followed by N. Every
+// published version of ETSI TS 119 495, from V1.1.1 on, uses RoleOIDArc, so
+// another arc is only for verifiers that expect a non-standard one during a
+// transition, e.g. one following a draft or a national profile. The role
+// names are unchanged, so Extract still reads such roles, but CheckRoleOIDs
+// reports them.
+func WithRoleOIDArc(arc asn1.ObjectIdentifier) Option {
+	return func(o *options) {
+		o.roleOIDArc = append(asn1.ObjectIdentifier{}, arc...)
+	}
+}
+
+// PreserveRoleOrder makes Serialize encode roles in the order given rather
+// than sorting them.
+func PreserveRoleOrder() Option {
+	return func(o *options) {
+		o.preserveRoleOrder = true
+	}
+}
+
+// OmitPSD2 leaves out the PSD2 statement, for qualified certificates that are
+// not used for PSD2. No roles may be given, and the CompetentAuthority is
+// ignored.
+func OmitPSD2() Option {
+	return func(o *options) {
+		o.omitPSD2 = true
+	}
+}
+
+// Serialize will serialize the given roles and CA information into a DER encoded ASN.1 qualified statement. qcType should be one of QWACType, QSEALType or ESignType.
+// The result is the flat QCStatements SEQUENCE OF QCStatement from RFC 3739,
+// in which QcType is a statement of its own alongside QcCompliance, QcPDS and
+// the PSD2 statement.
+// Roles are sorted by their numeric code as defined in ETSI TS 119 495 so that
+// the output does not depend on the order they are given in, unless the
+// PreserveRoleOrder option is used.
+func Serialize(roles []Role, ca CompetentAuthority, t asn1.ObjectIdentifier, opts ...Option) ([]byte, error) {
+	e, err := NewEncoder(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return e.Encode(roles, ca, t)
+}
+
+// encodeRoles builds the encoded form of roles, identifying each by arc
+// followed by its code.
+func encodeRoles(roles []Role, arc asn1.ObjectIdentifier) ([]role, error) {
 	r := make([]role, len(roles))
 	for i, rv := range roles {
-		if _, ok := roleMap[rv]; !ok {
+		if !isKnownRole(rv) {
 			return nil, fmt.Errorf("Unknown role: %s", rv)
 		}
-		oid := asn1.ObjectIdentifier([]int{0, 4, 0, 19495, 1, roleMap[rv]})
-
 		r[i] = role{
-			OID:  oid,
+			OID:  append(append(asn1.ObjectIdentifier{}, arc...), rv.Code()),
 			Role: rv,
 		}
 	}
+	return r, nil
+}
 
-	fin, err := asn1.Marshal(root{
-		qcType{
-			OID:    asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 6},
-			Detail: []asn1.ObjectIdentifier{t},
-		},
-		qcStatement{
-			OID: asn1.ObjectIdentifier{0, 4, 0, 19495, 2},
-			RolesInfo: rolesInfo{
-				Roles:  r,
-				CAName: ca.Name,
-				CAID:   ca.ID,
-			},
-		},
-	})
+func parseStatements(data []byte) ([]statement, error) {
+	return appendStatements(nil, data)
+}
+
+// RawStatement is a single QCStatement in its original encoding.
+type RawStatement struct {
+	// OID identifies the statement, e.g. 0.4.0.19495.2 for PSD2.
+	OID asn1.ObjectIdentifier
+	// Raw is the DER encoding of the whole statement, including its OID.
+	Raw []byte
+}
+
+// ExtractRaw splits an encoded qualified statement into its individual
+// statements, in order, without interpreting them.
+func ExtractRaw(data []byte) ([]RawStatement, error) {
+	parsed, err := parseStatements(data)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to marshal eIDAS: %v", err)
+		return nil, err
+	}
+	statements := make([]RawStatement, len(parsed))
+	for i, st := range parsed {
+		statements[i] = RawStatement{OID: st.OID, Raw: st.Raw}
+	}
+	return statements, nil
+}
+
+// CheckMandatory returns an error listing any statements that ETSI EN 319 412-5
+// and ETSI TS 119 495 make mandatory for a PSD2 qualified certificate but which
+// are missing from an encoded qualified statement: QcCompliance, QcType and the
+// PSD2 RolesInfo. If requirePDS is set, QcPDS is also required.
+func CheckMandatory(data []byte, requirePDS bool) error {
+	return checkMandatory(data, true, requirePDS)
+}
+
+// CheckMandatoryQualified is like CheckMandatory but for qualified
+// certificates outside PSD2, which need not carry the PSD2 RolesInfo.
+func CheckMandatoryQualified(data []byte, requirePDS bool) error {
+	return checkMandatory(data, false, requirePDS)
+}
+
+func checkMandatory(data []byte, requirePSD2 bool, requirePDS bool) error {
+	statements, err := parseStatements(data)
+	if err != nil {
+		return err
 	}
-	return fin, nil
+	var missing []string
+	for _, n := range statementNames {
+		if n.OID.Equal(QcPDSOID) && !requirePDS {
+			continue
+		}
+		if n.OID.Equal(PSD2OID) && !requirePSD2 {
+			continue
+		}
+		found := false
+		for _, st := range statements {
+			if st.OID.Equal(n.OID) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, n.Name)
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("missing mandatory statements: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// SerializeForCountry is like Serialize but resolves the CompetentAuthority
+// from an ISO-3166-1 alpha-2 country code using the built-in list.
+func SerializeForCountry(roles []Role, countryCode string, t asn1.ObjectIdentifier, opts ...Option) ([]byte, error) {
+	ca, err := CompetentAuthorityForCountryCode(countryCode)
+	if err != nil {
+		return nil, err
+	}
+	return Serialize(roles, *ca, t, opts...)
 }
 
 // Dump outputs to stdout a human-readable representation of an encoded qualified statement.
 func Dump(d []byte) error {
+	return dump(os.Stdout, d, false)
+}
+
+// DumpVerbose is like Dump but prints each role with its description, e.g.
+// "PSP_AI (Account Information Service Provider)".
+func DumpVerbose(d []byte) error {
+	return dump(os.Stdout, d, true)
+}
+
+func dump(w io.Writer, d []byte, verbose bool) error {
 	roles, name, id, err := Extract(d)
 	if err != nil {
 		return fmt.Errorf("eidas: %v", err)
 	}
 
-	fmt.Printf("CA { Name: %s ID: %s } Roles: %v\n", name, id, roles)
+	if !verbose {
+		fmt.Fprintf(w, "CA { Name: %s ID: %s } Roles: %v\n", name, id, roles)
+		return nil
+	}
+	described := make([]string, len(roles))
+	for i, r := range roles {
+		described[i] = string(r)
+		if desc := r.Description(); desc != "" {
+			described[i] = fmt.Sprintf("%s (%s)", r, desc)
+		}
+	}
+	fmt.Fprintf(w, "CA { Name: %s ID: %s } Roles: [%s]\n", name, id, strings.Join(described, ", "))
 	return nil
 }
 
 // DumpFromHex outputs to stdout a human-readable representation of a hex encoded qualified statement.
+// The hex may be split by whitespace or colons, as in openssl output, and may
+// have a leading "0x".
 func DumpFromHex(h string) error {
-	d, err := hex.DecodeString(h)
+	d, err := decodeHex(h)
 	if err != nil {
 		return fmt.Errorf("Failed to decode hex: %v", err)
 	}
@@ -263,18 +814,302 @@ func DumpFromHex(h string) error {
 	return Dump(d)
 }
 
+func decodeHex(h string) ([]byte, error) {
+	h = strings.TrimSpace(h)
+	if strings.HasPrefix(h, "0x") || strings.HasPrefix(h, "0X") {
+		h = h[2:]
+	}
+	h = strings.Map(func(r rune) rune {
+		if r == ':' || unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, h)
+	return hex.DecodeString(h)
+}
+
 // Extract returns the roles, CA name and CA ID from an encoded qualified statement.
+// If the encoding is malformed the error is a *DecodeError locating the
+// problem.
 func Extract(data []byte) ([]Role, string, string, error) {
-	var root root
-	_, err := asn1.Unmarshal(data, &root)
+	var d Decoder
+	st, err := d.Decode(data)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return st.Roles, st.CAName, st.CAID, nil
+}
+
+// RoleSource records which part of an encoded role a Role was read from.
+type RoleSource int
+
+const (
+	// RoleSourceName means the role was read from its name string.
+	RoleSourceName RoleSource = iota
+	// RoleSourceOID means the name was missing or unrecognized, so the role
+	// was derived from the code in its object identifier.
+	RoleSourceOID
+)
+
+// ExtractedRole is a role read from an encoded qualified statement.
+type ExtractedRole struct {
+	Role   Role
+	Source RoleSource
+}
+
+// parsedRole is a role decoded by parseRolesInfo. HasName is false if the
+// role had no name or its name was not a string.
+type parsedRole struct {
+	raw     []byte
+	OID     asn1.ObjectIdentifier
+	Name    string
+	HasName bool
+}
+
+func (r parsedRole) extract() (ExtractedRole, error) {
+	name := Role(r.Name)
+	if isKnownRole(name) && r.HasName {
+		return ExtractedRole{Role: name, Source: RoleSourceName}, nil
+	}
+
+	if len(r.OID) == len(RoleOIDArc)+1 && r.OID[:len(RoleOIDArc)].Equal(RoleOIDArc) {
+		if role, err := RoleFromCode(r.OID[len(RoleOIDArc)]); err == nil {
+			return ExtractedRole{Role: role, Source: RoleSourceOID}, nil
+		}
+	}
+	if r.HasName {
+		return ExtractedRole{Role: name, Source: RoleSourceName}, nil
+	}
+	return ExtractedRole{}, fmt.Errorf("role %v has no name and an unknown OID", r.OID)
+}
+
+// ExtractRoles is like Extract but also reports where each role was read
+// from. A role is normally read from its name, but if that is missing or not
+// a known role, the role is derived from its OID, 0.4.0.19495.1.N, instead.
+// Unknown names with an unknown OID are returned as they are.
+func ExtractRoles(data []byte) ([]ExtractedRole, string, string, error) {
+	statements, err := parseStatements(data)
 	if err != nil {
-		return nil, "", "", fmt.Errorf("failed to decode eIDAS: %v", err)
+		return nil, "", "", err
 	}
 
-	roles := make([]Role, 0)
-	for _, role := range root.QcStatement.RolesInfo.Roles {
-		roles = append(roles, role.Role)
+	for _, st := range statements {
+		if !st.OID.Equal(PSD2OID) {
+			continue
+		}
+		info, err := parseRolesInfo(st.Info)
+		if err != nil {
+			return nil, "", "", decodeError(data, st.Raw, err)
+		}
+
+		roles := make([]ExtractedRole, 0, len(info.Roles))
+		for _, r := range info.Roles {
+			role, err := r.extract()
+			if err != nil {
+				return nil, "", "", decodeError(data, r.raw, err)
+			}
+			roles = append(roles, role)
+		}
+		return roles, info.CAName, info.CAID, nil
 	}
+	return nil, "", "", fmt.Errorf("failed to decode eIDAS: no PSD2 statement")
+}
 
-	return roles, root.QcStatement.RolesInfo.CAName, root.QcStatement.RolesInfo.CAID, nil
+// RoleMismatch is a role in a PSD2 statement whose name and object
+// identifier disagree, e.g. a name of PSP_PI with the OID of PSP_AI.
+type RoleMismatch struct {
+	// Index is the position of the role in the statement.
+	Index int
+	OID   asn1.ObjectIdentifier
+	Name  Role
+}
+
+func (m RoleMismatch) String() string {
+	return fmt.Sprintf("role %d is named %s but has OID %v", m.Index, m.Name, m.OID)
+}
+
+// CheckRoleOIDs returns every role in the PSD2 statement of an encoded
+// qualified statement whose name is a known role but whose OID is not
+// 0.4.0.19495.1.N for that role's code. Such a statement was misissued or
+// tampered with; Extract trusts the name. Roles without a known name are not
+// checked, as ExtractRoles reads them from their OID.
+func CheckRoleOIDs(data []byte) ([]RoleMismatch, error) {
+	statements, err := parseStatements(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range statements {
+		if !st.OID.Equal(PSD2OID) {
+			continue
+		}
+		info, err := parseRolesInfo(st.Info)
+		if err != nil {
+			return nil, decodeError(data, st.Raw, err)
+		}
+
+		var mismatches []RoleMismatch
+		for i, r := range info.Roles {
+			name := Role(r.Name)
+			if !isKnownRole(name) || !r.HasName {
+				continue
+			}
+			expected := append(append(asn1.ObjectIdentifier{}, RoleOIDArc...), name.Code())
+			if !r.OID.Equal(expected) {
+				mismatches = append(mismatches, RoleMismatch{Index: i, OID: r.OID, Name: name})
+			}
+		}
+		return mismatches, nil
+	}
+	return nil, fmt.Errorf("failed to decode eIDAS: no PSD2 statement")
+}
+
+// ExtractCompetentAuthority returns the competent authority named by the PSD2
+// statement of an encoded qualified statement. Some issuers leave the CA name
+// empty; it is then looked up from the CA ID in the built-in list, and
+// nameDerived is set. The name is left empty if the CA ID is not in the list.
+func ExtractCompetentAuthority(data []byte) (ca *CompetentAuthority, nameDerived bool, err error) {
+	_, name, id, err := ExtractRoles(data)
+	if err != nil {
+		return nil, false, err
+	}
+	ca = &CompetentAuthority{Name: name, ID: id}
+	if name == "" && id != "" {
+		if known, err := CompetentAuthorityForID(id); err == nil {
+			ca.Name = known.Name
+			nameDerived = true
+		}
+	}
+	return ca, nameDerived, nil
+}
+
+// ReplaceCompetentAuthority re-encodes a qualified statement with ca in place
+// of the competent authority of its PSD2 statement, e.g. when an institution
+// is re-authorized by a different NCA. Only the CA name and ID change: the
+// roles, any fields following the CA ID and every other statement, including
+// the QC type, are kept byte for byte.
+func ReplaceCompetentAuthority(data []byte, ca CompetentAuthority) ([]byte, error) {
+	if err := ca.Validate(); err != nil {
+		return nil, err
+	}
+	statements, err := parseStatements(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range statements {
+		if !st.OID.Equal(PSD2OID) {
+			continue
+		}
+		if _, err := parseRolesInfo(st.Info); err != nil {
+			return nil, decodeError(data, st.Raw, err)
+		}
+		return replaceCompetentAuthority(data, st, ca)
+	}
+	return nil, fmt.Errorf("no PSD2 statement")
+}
+
+// replaceCompetentAuthority splices the encoding of ca over the CA name and ID
+// of the PSD2 statement st in data, re-encoding the lengths of the elements
+// holding them.
+func replaceCompetentAuthority(data []byte, st statement, ca CompetentAuthority) ([]byte, error) {
+	name, err := asn1.MarshalWithParams(ca.Name, "utf8")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal eIDAS: %v", err)
+	}
+	id, err := asn1.MarshalWithParams(ca.ID, "utf8")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal eIDAS: %v", err)
+	}
+
+	// The RolesInfo may be wrapped in an EXPLICIT tag; see sequenceElements.
+	fields, err := sequenceElements(st.Info, false)
+	if err != nil {
+		return nil, err
+	}
+	info, wrapped := st.Info, false
+	if info.Class == asn1.ClassContextSpecific {
+		var inner asn1.RawValue
+		if _, err := asn1.Unmarshal(info.Bytes, &inner); err == nil && sameStart(inner.Bytes, fields[0].FullBytes) {
+			info, wrapped = inner, true
+		}
+	}
+	start, _ := offsetIn(info.Bytes, fields[1].FullBytes)
+	end, _ := offsetIn(info.Bytes, fields[2].FullBytes)
+	end += len(fields[2].FullBytes)
+
+	rolesInfo, err := splice(info.FullBytes, info.Bytes[start:end], append(name, id...))
+	if err != nil {
+		return nil, err
+	}
+	if wrapped {
+		if rolesInfo, err = splice(st.Info.FullBytes, info.FullBytes, rolesInfo); err != nil {
+			return nil, err
+		}
+	}
+	psd2, err := splice(st.Raw, st.Info.FullBytes, rolesInfo)
+	if err != nil {
+		return nil, err
+	}
+	return splice(data, st.Raw, psd2)
+}
+
+// splice re-encodes the element encoded in container with old, a subslice of
+// its contents, replaced by replacement.
+func splice(container, old, replacement []byte) ([]byte, error) {
+	var v asn1.RawValue
+	if _, err := asn1.Unmarshal(container, &v); err != nil {
+		return nil, err
+	}
+	start, ok := offsetIn(v.Bytes, old)
+	if !ok {
+		return nil, fmt.Errorf("element is not within its container")
+	}
+	content := make([]byte, 0, len(v.Bytes)-len(old)+len(replacement))
+	content = append(content, v.Bytes[:start]...)
+	content = append(content, replacement...)
+	content = append(content, v.Bytes[start+len(old):]...)
+	d, err := asn1.Marshal(asn1.RawValue{Class: v.Class, Tag: v.Tag, IsCompound: v.IsCompound, Bytes: content})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal eIDAS: %v", err)
+	}
+	return d, nil
+}
+
+// sameStart reports whether a and b begin at the same place in memory.
+func sameStart(a, b []byte) bool {
+	return len(a) != 0 && len(b) != 0 && &a[0] == &b[0]
+}
+
+// ExtractType returns the QC type, e.g. QWACType or QSEALType, from an encoded
+// qualified statement. If several types are declared the first is returned;
+// see ExtractTypes.
+func ExtractType(data []byte) (asn1.ObjectIdentifier, error) {
+	types, err := ExtractTypes(data)
+	if err != nil {
+		return nil, err
+	}
+	return types[0], nil
+}
+
+// ExtractTypes returns all of the QC types declared in the QcType statement of
+// an encoded qualified statement. Use QCTypeForOID to recognize them.
+func ExtractTypes(data []byte) ([]asn1.ObjectIdentifier, error) {
+	statements, err := parseStatements(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, st := range statements {
+		if !st.OID.Equal(QcTypeOID) {
+			continue
+		}
+		var detail []asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(st.Info.FullBytes, &detail); err != nil {
+			return nil, fmt.Errorf("failed to decode QcType: %v", err)
+		}
+		if len(detail) == 0 {
+			return nil, fmt.Errorf("failed to decode QcType: no type given")
+		}
+		return detail, nil
+	}
+	return nil, fmt.Errorf("failed to decode eIDAS: no QcType statement")
 }
@@ -1,8 +1,13 @@
 package qcstatements
 
 import (
+	"bytes"
+	"encoding/asn1"
 	"encoding/hex"
 	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -157,3 +162,1224 @@ func TestQSEAL(t *testing.T) {
 		}
 	}
 }
+
+func TestOptionalStatements(t *testing.T) {
+	d, err := Serialize([]Role{RoleAccountInformation}, defaultCA, QWACType,
+		WithCompliance(), WithPDS(PDSLocation{URL: "https://example.com/pds_en.pdf", Language: "en"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statements, err := parseStatements(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []asn1.ObjectIdentifier{QcComplianceOID, QcTypeOID, QcPDSOID, PSD2OID}
+	if len(statements) != len(expected) {
+		t.Fatalf("Expected %d statements but got %d", len(expected), len(statements))
+	}
+	for i, st := range statements {
+		if !st.OID.Equal(expected[i]) {
+			t.Errorf("Expected statement %d to be %v but got %v", i, expected[i], st.OID)
+		}
+	}
+
+	roles, name, id, err := Extract(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 1 || roles[0] != RoleAccountInformation {
+		t.Errorf("Expected roles: [%s] but got %v", RoleAccountInformation, roles)
+	}
+	if name != defaultCA.Name {
+		t.Errorf("Expected CA name: %s but got %s", defaultCA.Name, name)
+	}
+	if id != defaultCA.ID {
+		t.Errorf("Expected CA id: %s but got %s", defaultCA.ID, id)
+	}
+}
+
+func TestCheckMandatory(t *testing.T) {
+	pds := PDSLocation{URL: "https://example.com/pds_en.pdf", Language: "en"}
+	for _, tc := range []struct {
+		name       string
+		opts       []Option
+		requirePDS bool
+		missing    string
+	}{
+		{name: "no compliance", missing: "QcCompliance"},
+		{name: "compliance", opts: []Option{WithCompliance()}},
+		{name: "no pds", opts: []Option{WithCompliance()}, requirePDS: true, missing: "QcPDS"},
+		{name: "no compliance or pds", requirePDS: true, missing: "QcCompliance, QcPDS"},
+		{name: "pds", opts: []Option{WithCompliance(), WithPDS(pds)}, requirePDS: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := Serialize([]Role{RoleAccountInformation}, defaultCA, QWACType, tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = CheckMandatory(d, tc.requirePDS)
+			if tc.missing == "" {
+				if err != nil {
+					t.Errorf("Expected no error but got %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != "missing mandatory statements: "+tc.missing {
+				t.Errorf("Expected missing %s but got %v", tc.missing, err)
+			}
+		})
+	}
+}
+
+func TestExtractType(t *testing.T) {
+	for _, qcType := range []asn1.ObjectIdentifier{QWACType, QSEALType} {
+		d, err := Serialize([]Role{RoleAccountInformation}, defaultCA, qcType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		extracted, err := ExtractType(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !extracted.Equal(qcType) {
+			t.Errorf("Expected QC type: %v but got %v", qcType, extracted)
+		}
+	}
+}
+
+func TestRoleOrder(t *testing.T) {
+	sorted, err := Serialize([]Role{RoleAccountServicing, RolePaymentInitiation}, defaultCA, QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsorted, err := Serialize([]Role{RolePaymentInitiation, RoleAccountServicing}, defaultCA, QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(sorted) != hex.EncodeToString(unsorted) {
+		t.Errorf("Expected role order not to affect output: %x != %x", sorted, unsorted)
+	}
+
+	preserved, err := Serialize([]Role{RolePaymentInitiation, RoleAccountServicing}, defaultCA, QWACType, PreserveRoleOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles, _, _, err := Extract(preserved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 2 || roles[0] != RolePaymentInitiation || roles[1] != RoleAccountServicing {
+		t.Errorf("Expected caller role order but got %v", roles)
+	}
+}
+
+func TestExtractTrailingFields(t *testing.T) {
+	type extendedRolesInfo struct {
+		Roles  []role
+		CAName string `asn1:"utf8"`
+		CAID   string `asn1:"utf8"`
+		Extra  string `asn1:"utf8,explicit,tag:0"`
+	}
+	type extendedStatement struct {
+		OID       asn1.ObjectIdentifier
+		RolesInfo extendedRolesInfo
+	}
+	psd2, err := asn1.Marshal(extendedStatement{
+		OID: PSD2OID,
+		RolesInfo: extendedRolesInfo{
+			Roles:  []role{{OID: asn1.ObjectIdentifier{0, 4, 0, 19495, 1, 3}, Role: RoleAccountInformation}},
+			CAName: defaultCA.Name,
+			CAID:   defaultCA.ID,
+			Extra:  "extension",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := asn1.Marshal([]asn1.RawValue{{FullBytes: psd2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roles, name, id, err := Extract(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 1 || roles[0] != RoleAccountInformation {
+		t.Errorf("Expected roles: [%s] but got %v", RoleAccountInformation, roles)
+	}
+	if name != defaultCA.Name {
+		t.Errorf("Expected CA name: %s but got %s", defaultCA.Name, name)
+	}
+	if id != defaultCA.ID {
+		t.Errorf("Expected CA id: %s but got %s", defaultCA.ID, id)
+	}
+}
+
+func TestRolesDiff(t *testing.T) {
+	for _, tc := range []struct {
+		original, updated []Role
+		added, removed    []Role
+	}{
+		{
+			original: []Role{RoleAccountInformation, RolePaymentInitiation},
+			updated:  []Role{RolePaymentInitiation, RoleAccountInformation, RoleAccountInformation},
+		},
+		{
+			original: []Role{RoleAccountInformation},
+			updated:  []Role{RolePaymentInstruments, RolePaymentInitiation},
+			added:    []Role{RolePaymentInitiation, RolePaymentInstruments},
+			removed:  []Role{RoleAccountInformation},
+		},
+		{
+			original: nil,
+			updated:  []Role{"PSP_XX", RoleAccountServicing},
+			added:    []Role{RoleAccountServicing, "PSP_XX"},
+		},
+	} {
+		t.Run(fmt.Sprint(tc.original, tc.updated), func(t *testing.T) {
+			added, removed := RolesDiff(tc.original, tc.updated)
+			if fmt.Sprint(added) != fmt.Sprint(tc.added) {
+				t.Errorf("Expected added: %v but got %v", tc.added, added)
+			}
+			if fmt.Sprint(removed) != fmt.Sprint(tc.removed) {
+				t.Errorf("Expected removed: %v but got %v", tc.removed, removed)
+			}
+			equal := len(tc.added) == 0 && len(tc.removed) == 0
+			if RolesEqual(tc.original, tc.updated) != equal {
+				t.Errorf("Expected RolesEqual to be %v", equal)
+			}
+		})
+	}
+}
+
+func TestOmitPSD2(t *testing.T) {
+	d, err := Serialize(nil, CompetentAuthority{}, QSEALType, WithCompliance(), OmitPSD2())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckMandatoryQualified(d, false); err != nil {
+		t.Error(err)
+	}
+	if err := CheckMandatory(d, false); err == nil {
+		t.Error("Expected PSD2 RolesInfo to be missing")
+	}
+
+	if _, err := Serialize([]Role{RoleAccountInformation}, defaultCA, QSEALType, OmitPSD2()); err == nil {
+		t.Error("Expected error for roles without a PSD2 statement")
+	}
+}
+
+func TestExtractTypes(t *testing.T) {
+	unknown := asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 6, 9}
+	d, err := asn1.Marshal([]interface{}{
+		qcType{OID: QcTypeOID, Detail: []asn1.ObjectIdentifier{QWACType, QSEALType, ESignType, unknown}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	types, err := ExtractTypes(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []QCType{QCTypeQWAC, QCTypeQSEAL, QCTypeESign, QCTypeUnknown}
+	if len(types) != len(expected) {
+		t.Fatalf("Expected %d types but got %v", len(expected), types)
+	}
+	for i, oid := range types {
+		if QCTypeForOID(oid) != expected[i] {
+			t.Errorf("Expected type %q for %v but got %q", expected[i], oid, QCTypeForOID(oid))
+		}
+	}
+}
+
+func TestSerializeForCountry(t *testing.T) {
+	d, err := SerializeForCountry([]Role{RoleAccountServicing}, "GB", QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := Serialize([]Role{RoleAccountServicing}, defaultCA, QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(d) != hex.EncodeToString(expected) {
+		t.Errorf("Mismatch with Serialize: %x != %x", d, expected)
+	}
+
+	if _, err := SerializeForCountry([]Role{RoleAccountServicing}, "XX", QWACType); err == nil {
+		t.Error("Expected error for unknown country code")
+	}
+}
+
+func TestAllRolesAndTypes(t *testing.T) {
+	roles := AllRoles()
+	expected := []Role{RoleAccountServicing, RolePaymentInitiation, RoleAccountInformation, RolePaymentInstruments}
+	if fmt.Sprint(roles) != fmt.Sprint(expected) {
+		t.Errorf("Expected roles: %v but got %v", expected, roles)
+	}
+
+	for _, qcType := range AllQCTypes() {
+		if QCTypeForOID(qcType) == QCTypeUnknown {
+			t.Errorf("Expected a name for QC type %v", qcType)
+		}
+		if _, err := Serialize(roles, defaultCA, qcType); err != nil {
+			t.Errorf("Expected Serialize to accept %v and %v: %v", roles, qcType, err)
+		}
+	}
+}
+
+func TestExtractRaw(t *testing.T) {
+	d, err := Serialize([]Role{RoleAccountServicing}, defaultCA, QWACType, WithCompliance())
+	if err != nil {
+		t.Fatal(err)
+	}
+	statements, err := ExtractRaw(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		OID asn1.ObjectIdentifier
+		Raw string
+	}{
+		{QcComplianceOID, "3008060604008e460101"},
+		{QcTypeOID, "3013060604008e4601063009060704008e46010603"},
+		{PSD2OID, "30440606040081982702303a301330110607040081982701010c065053505f41530c1b46696e616e6369616c20436f6e6475637420417574686f726974790c0647422d464341"},
+	}
+	if len(statements) != len(expected) {
+		t.Fatalf("Expected %d statements but got %d", len(expected), len(statements))
+	}
+	for i, st := range statements {
+		if !st.OID.Equal(expected[i].OID) {
+			t.Errorf("Expected statement %d to be %v but got %v", i, expected[i].OID, st.OID)
+		}
+		if hex.EncodeToString(st.Raw) != expected[i].Raw {
+			t.Errorf("Mismatch with statement %d: %x != %s", i, st.Raw, expected[i].Raw)
+		}
+	}
+
+	if _, err := ExtractRaw([]byte{0x30, 0x03, 0x02, 0x01, 0x01}); err == nil {
+		t.Error("Expected error for a statement that is not a SEQUENCE")
+	}
+}
+
+func TestParseRoles(t *testing.T) {
+	roles, err := ParseRoles([]string{"PSP_AI", " PSP_PI "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roles) != 2 || roles[0] != RoleAccountInformation || roles[1] != RolePaymentInitiation {
+		t.Errorf("Expected roles: [PSP_AI PSP_PI] but got %v", roles)
+	}
+
+	if _, err := ParseRoles([]string{"PSP_AI", "PSP_XX"}); err == nil {
+		t.Error("Expected error for unknown role")
+	}
+}
+
+func TestDeriveCompetentAuthority(t *testing.T) {
+	ca, err := DeriveCompetentAuthority("GB", "FCA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.ID != "GB-FCA" || ca.Name != "" || !ca.Unverified {
+		t.Errorf("Unexpected competent authority: %+v", ca)
+	}
+
+	if _, err := DeriveCompetentAuthority("GBR", "FCA"); err == nil {
+		t.Error("Expected error for invalid country code")
+	}
+}
+
+func TestRoleDescription(t *testing.T) {
+	for _, r := range AllRoles() {
+		if r.Description() == "" {
+			t.Errorf("Expected description for role %s", r)
+		}
+	}
+	if d := Role("PSP_XX").Description(); d != "" {
+		t.Errorf("Expected no description for unknown role but got %q", d)
+	}
+}
+
+func TestDump(t *testing.T) {
+	d, err := Serialize([]Role{RoleAccountInformation}, defaultCA, QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := dump(&buf, d, false); err != nil {
+		t.Fatal(err)
+	}
+	expected := "CA { Name: Financial Conduct Authority ID: GB-FCA } Roles: [PSP_AI]\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q but got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := dump(&buf, d, true); err != nil {
+		t.Fatal(err)
+	}
+	expected = "CA { Name: Financial Conduct Authority ID: GB-FCA } Roles: [PSP_AI (Account Information Service Provider)]\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q but got %q", expected, buf.String())
+	}
+}
+
+func TestPDSStringTypes(t *testing.T) {
+	d, err := Serialize([]Role{RoleAccountInformation}, defaultCA, QWACType,
+		WithPDS(PDSLocation{URL: "https://example.com/pds_en.pdf", Language: "en"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	statements, err := ExtractRaw(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pds struct {
+		OID       asn1.ObjectIdentifier
+		Locations []struct {
+			URL      asn1.RawValue
+			Language asn1.RawValue
+		}
+	}
+	found := false
+	for _, st := range statements {
+		if st.OID.Equal(QcPDSOID) {
+			found = true
+			if _, err := asn1.Unmarshal(st.Raw, &pds); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if !found || len(pds.Locations) != 1 {
+		t.Fatalf("Expected one PDS location but got %+v", pds)
+	}
+	if tag := pds.Locations[0].URL.Tag; tag != asn1.TagIA5String {
+		t.Errorf("Expected URL tag %d but got %d", asn1.TagIA5String, tag)
+	}
+	if tag := pds.Locations[0].Language.Tag; tag != asn1.TagPrintableString {
+		t.Errorf("Expected language tag %d but got %d", asn1.TagPrintableString, tag)
+	}
+
+	for _, l := range []PDSLocation{
+		{URL: "https://example.com/pds_é.pdf", Language: "en"},
+		{URL: "", Language: "en"},
+		{URL: "https://example.com/pds_en.pdf", Language: "eng"},
+		{URL: "https://example.com/pds_en.pdf", Language: "e1"},
+	} {
+		if _, err := Serialize([]Role{RoleAccountInformation}, defaultCA, QWACType, WithPDS(l)); err == nil {
+			t.Errorf("Expected error for PDS location %+v", l)
+		}
+	}
+}
+
+func TestExtractConstructedRole(t *testing.T) {
+	// A role whose UTF8String is encoded as a constructed value wrapping the
+	// primitive string, which DER does not allow.
+	roleValue := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagUTF8String, IsCompound: true, Bytes: []byte{0x0c, 0x06, 'P', 'S', 'P', '_', 'A', 'I'}}
+	role, err := asn1.Marshal(struct {
+		OID  asn1.ObjectIdentifier
+		Role asn1.RawValue
+	}{asn1.ObjectIdentifier{0, 4, 0, 19495, 1, 3}, roleValue})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := asn1.Marshal(struct {
+		Roles  []asn1.RawValue
+		CAName string `asn1:"utf8"`
+		CAID   string `asn1:"utf8"`
+	}{[]asn1.RawValue{{FullBytes: role}}, defaultCA.Name, defaultCA.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := asn1.Marshal([]statement{{OID: PSD2OID, Info: asn1.RawValue{FullBytes: info}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := Extract(d); err == nil {
+		t.Error("Expected error for constructed role string")
+	}
+}
+
+func TestRoleCode(t *testing.T) {
+	for i, r := range AllRoles() {
+		if r.Code() != i+1 {
+			t.Errorf("Expected code %d for role %s but got %d", i+1, r, r.Code())
+		}
+		got, err := RoleFromCode(r.Code())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != r {
+			t.Errorf("Expected role %s for code %d but got %s", r, r.Code(), got)
+		}
+	}
+	if c := Role("PSP_XX").Code(); c != 0 {
+		t.Errorf("Expected code 0 for unknown role but got %d", c)
+	}
+	for _, n := range []int{0, 5} {
+		if _, err := RoleFromCode(n); err == nil {
+			t.Errorf("Expected error for code %d", n)
+		}
+	}
+}
+
+// serializeRawRoles encodes a PSD2 statement with the given role encodings.
+func serializeRawRoles(t *testing.T, roles ...interface{}) []byte {
+	raw := make([]asn1.RawValue, len(roles))
+	for i, r := range roles {
+		d, err := asn1.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw[i] = asn1.RawValue{FullBytes: d}
+	}
+	info, err := asn1.Marshal(struct {
+		Roles  []asn1.RawValue
+		CAName string `asn1:"utf8"`
+		CAID   string `asn1:"utf8"`
+	}{raw, defaultCA.Name, defaultCA.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := asn1.Marshal([]statement{{OID: PSD2OID, Info: asn1.RawValue{FullBytes: info}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestExtractRoles(t *testing.T) {
+	type oidOnly struct {
+		OID asn1.ObjectIdentifier
+	}
+	type ia5Name struct {
+		OID  asn1.ObjectIdentifier
+		Name string `asn1:"ia5"`
+	}
+	type integerName struct {
+		OID  asn1.ObjectIdentifier
+		Name int
+	}
+	aiOID := asn1.ObjectIdentifier{0, 4, 0, 19495, 1, 3}
+	piOID := asn1.ObjectIdentifier{0, 4, 0, 19495, 1, 2}
+
+	for _, tc := range []struct {
+		name     string
+		roles    []interface{}
+		expected []ExtractedRole
+	}{
+		{
+			name:     "name",
+			roles:    []interface{}{role{OID: aiOID, Role: RoleAccountInformation}},
+			expected: []ExtractedRole{{RoleAccountInformation, RoleSourceName}},
+		},
+		{
+			name:     "missing name",
+			roles:    []interface{}{oidOnly{aiOID}},
+			expected: []ExtractedRole{{RoleAccountInformation, RoleSourceOID}},
+		},
+		{
+			name:     "unknown name",
+			roles:    []interface{}{role{OID: piOID, Role: "PSP_??"}},
+			expected: []ExtractedRole{{RolePaymentInitiation, RoleSourceOID}},
+		},
+		{
+			name:     "IA5String name",
+			roles:    []interface{}{ia5Name{aiOID, "PSP_AI"}},
+			expected: []ExtractedRole{{RoleAccountInformation, RoleSourceName}},
+		},
+		{
+			name:     "name that is not a string",
+			roles:    []interface{}{integerName{aiOID, 3}},
+			expected: []ExtractedRole{{RoleAccountInformation, RoleSourceOID}},
+		},
+		{
+			name:     "unknown name and OID",
+			roles:    []interface{}{role{OID: asn1.ObjectIdentifier{1, 2, 3}, Role: "PSP_XX"}},
+			expected: []ExtractedRole{{"PSP_XX", RoleSourceName}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			roles, name, id, err := ExtractRoles(serializeRawRoles(t, tc.roles...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(roles, tc.expected) {
+				t.Errorf("Expected roles %v but got %v", tc.expected, roles)
+			}
+			if name != defaultCA.Name || id != defaultCA.ID {
+				t.Errorf("Unexpected CA %s (%s)", name, id)
+			}
+		})
+	}
+
+	if _, _, _, err := ExtractRoles(serializeRawRoles(t, oidOnly{asn1.ObjectIdentifier{0, 4, 0, 19495, 1, 9}})); err == nil {
+		t.Error("Expected error for role with no name and an unknown OID")
+	}
+}
+
+func TestRegisterRole(t *testing.T) {
+	const custom Role = "PSP_XY"
+	if err := RegisterRole(9, custom); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		roleRegistry.Lock()
+		delete(roleRegistry.m, custom)
+		roleRegistry.Unlock()
+	}()
+
+	if custom.Code() != 9 {
+		t.Errorf("Expected code 9 but got %d", custom.Code())
+	}
+	if r, err := RoleFromCode(9); err != nil || r != custom {
+		t.Errorf("Expected %s for code 9 but got %s (%v)", custom, r, err)
+	}
+	if roles, err := ParseRoles([]string{"PSP_XY"}); err != nil || !RolesEqual(roles, []Role{custom}) {
+		t.Errorf("Failed to parse registered role: %v (%v)", roles, err)
+	}
+	if all := AllRoles(); all[len(all)-1] != custom {
+		t.Errorf("Expected registered role last in %v", all)
+	}
+
+	d, err := Serialize([]Role{custom, RoleAccountInformation}, defaultCA, QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extracted, err := ExtractRaw(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oid, err := asn1.Marshal(asn1.ObjectIdentifier{0, 4, 0, 19495, 1, 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(extracted[len(extracted)-1].Raw, oid) {
+		t.Errorf("Expected OID 0.4.0.19495.1.9 in %x", extracted[len(extracted)-1].Raw)
+	}
+	roles, _, _, err := Extract(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(roles, []Role{RoleAccountInformation, custom}) {
+		t.Errorf("Unexpected roles %v", roles)
+	}
+	extractedRoles, _, _, err := ExtractRoles(serializeRawRoles(t, struct {
+		OID asn1.ObjectIdentifier
+	}{asn1.ObjectIdentifier{0, 4, 0, 19495, 1, 9}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(extractedRoles, []ExtractedRole{{custom, RoleSourceOID}}) {
+		t.Errorf("Unexpected roles %v", extractedRoles)
+	}
+
+	for _, tc := range []struct {
+		code int
+		role Role
+	}{
+		{9, "PSP_ZZ"},
+		{3, "PSP_ZZ"},
+		{10, custom},
+		{10, RoleAccountInformation},
+		{0, "PSP_ZZ"},
+		{10, ""},
+	} {
+		if err := RegisterRole(tc.code, tc.role); err == nil {
+			t.Errorf("Expected error registering %q with code %d", tc.role, tc.code)
+		}
+	}
+}
+
+func TestCheckRoleOIDs(t *testing.T) {
+	type oidOnly struct {
+		OID asn1.ObjectIdentifier
+	}
+	aiOID := asn1.ObjectIdentifier{0, 4, 0, 19495, 1, 3}
+	piOID := asn1.ObjectIdentifier{0, 4, 0, 19495, 1, 2}
+
+	for _, tc := range []struct {
+		name     string
+		roles    []interface{}
+		expected []RoleMismatch
+	}{
+		{
+			name:  "consistent",
+			roles: []interface{}{role{OID: piOID, Role: RolePaymentInitiation}, role{OID: aiOID, Role: RoleAccountInformation}},
+		},
+		{
+			name:     "name disagrees with OID",
+			roles:    []interface{}{role{OID: piOID, Role: RolePaymentInitiation}, role{OID: aiOID, Role: RolePaymentInitiation}},
+			expected: []RoleMismatch{{Index: 1, OID: aiOID, Name: RolePaymentInitiation}},
+		},
+		{
+			name:     "OID outside the role arc",
+			roles:    []interface{}{role{OID: asn1.ObjectIdentifier{1, 2, 3}, Role: RoleAccountInformation}},
+			expected: []RoleMismatch{{Index: 0, OID: asn1.ObjectIdentifier{1, 2, 3}, Name: RoleAccountInformation}},
+		},
+		{
+			name:  "unknown name",
+			roles: []interface{}{role{OID: piOID, Role: "PSP_??"}},
+		},
+		{
+			name:  "missing name",
+			roles: []interface{}{oidOnly{aiOID}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mismatches, err := CheckRoleOIDs(serializeRawRoles(t, tc.roles...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(mismatches, tc.expected) {
+				t.Errorf("Expected mismatches %v but got %v", tc.expected, mismatches)
+			}
+		})
+	}
+
+	m := RoleMismatch{Index: 1, OID: aiOID, Name: RolePaymentInitiation}
+	if s := m.String(); s != "role 1 is named PSP_PI but has OID 0.4.0.19495.1.3" {
+		t.Errorf("Unexpected string %q", s)
+	}
+	noPSD2, err := Serialize(nil, CompetentAuthority{}, QWACType, OmitPSD2())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CheckRoleOIDs(noPSD2); err == nil {
+		t.Error("Expected error for statement without PSD2")
+	}
+}
+
+func TestQcTypeEncoding(t *testing.T) {
+	d, err := Serialize([]Role{RoleAccountInformation}, defaultCA, QSEALType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statements, err := parseStatements(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range statements {
+		if !st.OID.Equal(QcTypeOID) {
+			continue
+		}
+		// statementInfo must be a SEQUENCE OF OBJECT IDENTIFIER, not a bare OID.
+		if st.Info.Class != asn1.ClassUniversal || st.Info.Tag != asn1.TagSequence || !st.Info.IsCompound {
+			t.Fatalf("Expected statementInfo to be a SEQUENCE but got class %d tag %d", st.Info.Class, st.Info.Tag)
+		}
+		// SEQUENCE { OBJECT IDENTIFIER 0.4.0.1862.1.6.2 }, as in the
+		// psd2_csr_profiles_qseal_* reference vectors.
+		expected := "3009060704008e46010602"
+		if got := hex.EncodeToString(st.Info.FullBytes); got != expected {
+			t.Errorf("Expected statementInfo %s but got %s", expected, got)
+		}
+		return
+	}
+	t.Fatal("No QcType statement")
+}
+
+// tlv encodes a single DER element.
+func tlv(t *testing.T, class, tag int, compound bool, content ...[]byte) []byte {
+	d, err := asn1.Marshal(asn1.RawValue{Class: class, Tag: tag, IsCompound: compound, Bytes: bytes.Join(content, nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestExtractTaggingVariants(t *testing.T) {
+	seq := func(content ...[]byte) []byte { return tlv(t, asn1.ClassUniversal, asn1.TagSequence, true, content...) }
+	utf8String := func(s string) []byte { return tlv(t, asn1.ClassUniversal, asn1.TagUTF8String, false, []byte(s)) }
+	roleOID, err := asn1.Marshal(asn1.ObjectIdentifier{0, 4, 0, 19495, 1, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	role := seq(roleOID, utf8String("PSP_AI"))
+	caName := utf8String(defaultCA.Name)
+	caID := utf8String(defaultCA.ID)
+	statement := func(info []byte) []byte {
+		psd2, err := asn1.Marshal(PSD2OID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return seq(seq(psd2, info))
+	}
+
+	for _, tc := range []struct {
+		name string
+		info []byte
+	}{
+		{
+			name: "EXPLICIT tagged rolesInfo",
+			info: tlv(t, asn1.ClassContextSpecific, 0, true, seq(seq(role), caName, caID)),
+		},
+		{
+			name: "IMPLICIT tagged rolesInfo",
+			info: tlv(t, asn1.ClassContextSpecific, 0, true, seq(role), caName, caID),
+		},
+		{
+			name: "IMPLICIT tagged roles with one role",
+			info: seq(tlv(t, asn1.ClassContextSpecific, 0, true, role), caName, caID),
+		},
+		{
+			name: "EXPLICIT tagged roles",
+			info: seq(tlv(t, asn1.ClassContextSpecific, 0, true, seq(role)), caName, caID),
+		},
+		{
+			name: "IMPLICIT tagged role fields",
+			info: seq(seq(seq(
+				tlv(t, asn1.ClassContextSpecific, 0, false, roleOID[2:]),
+				tlv(t, asn1.ClassContextSpecific, 1, false, []byte("PSP_AI")),
+			)), caName, caID),
+		},
+		{
+			name: "PrintableString CA name and ID",
+			info: seq(seq(role),
+				tlv(t, asn1.ClassUniversal, asn1.TagPrintableString, false, []byte(defaultCA.Name)),
+				tlv(t, asn1.ClassUniversal, asn1.TagPrintableString, false, []byte(defaultCA.ID))),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			roles, name, id, err := Extract(statement(tc.info))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !RolesEqual(roles, []Role{RoleAccountInformation}) || len(roles) != 1 {
+				t.Errorf("Expected roles [PSP_AI] but got %v", roles)
+			}
+			if name != defaultCA.Name || id != defaultCA.ID {
+				t.Errorf("Unexpected CA %s (%s)", name, id)
+			}
+		})
+	}
+
+	if _, _, _, err := Extract(statement(seq(seq(role), caName))); err == nil {
+		t.Error("Expected error for statement without a CA ID")
+	}
+}
+
+func TestOIDs(t *testing.T) {
+	for _, qcType := range AllQCTypes() {
+		if !qcType[:len(QcTypeOID)].Equal(QcTypeOID) {
+			t.Errorf("Expected QC type %v to be under %v", qcType, QcTypeOID)
+		}
+	}
+
+	if _, err := Serialize(AllRoles(), defaultCA, QWACType); err != nil {
+		t.Fatal(err)
+	}
+	if !RoleOIDArc.Equal(asn1.ObjectIdentifier{0, 4, 0, 19495, 1}) {
+		t.Errorf("RoleOIDArc was modified: %v", RoleOIDArc)
+	}
+}
+
+func TestAdditionalStatements(t *testing.T) {
+	// QcSSCD, 0.4.0.1862.1.4, which has no statementInfo.
+	sscd, err := asn1.Marshal(statement{OID: asn1.ObjectIdentifier{0, 4, 0, 1862, 1, 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := Serialize([]Role{RoleAccountInformation}, defaultCA, QSEALType, WithAdditionalStatements(sscd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	statements, err := ExtractRaw(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := statements[len(statements)-1]
+	if !bytes.Equal(last.Raw, sscd) {
+		t.Errorf("Expected last statement %x but got %x", sscd, last.Raw)
+	}
+	if _, _, _, err := Extract(d); err != nil {
+		t.Errorf("Expected PSD2 statement to still be readable but got %v", err)
+	}
+
+	for name, blob := range map[string][]byte{
+		"not a statement": {0x04, 0x01, 0x00},
+		"truncated":       sscd[:len(sscd)-1],
+		"trailing data":   append(append([]byte{}, sscd...), 0x00),
+		"empty":           nil,
+	} {
+		if _, err := Serialize([]Role{RoleAccountInformation}, defaultCA, QSEALType, WithAdditionalStatements(blob)); err == nil {
+			t.Errorf("Expected error for %s additional statement", name)
+		}
+	}
+}
+
+func TestDecodeHex(t *testing.T) {
+	expected := []byte{0x30, 0x13, 0x06, 0x06}
+	for _, in := range []string{
+		"30130606",
+		"0x30130606",
+		"0X30130606",
+		"30 13 06 06",
+		"  30 13\n\t06 06\n",
+		"30:13:06:06",
+		"0x30 13 06 06",
+	} {
+		d, err := decodeHex(in)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", in, err)
+			continue
+		}
+		if !bytes.Equal(d, expected) {
+			t.Errorf("Expected %x for %q but got %x", expected, in, d)
+		}
+	}
+
+	for _, in := range []string{"30 1", "0xzz", "30-13"} {
+		if _, err := decodeHex(in); err == nil {
+			t.Errorf("Expected error for %q", in)
+		}
+	}
+}
+
+func TestConcurrentSerialize(t *testing.T) {
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ca, err := CompetentAuthorityForCountryCode("GB")
+			if err != nil {
+				errs <- err
+				return
+			}
+			ca.Unverified = true
+			d, err := Serialize([]Role{RoleAccountInformation, RolePaymentInitiation}, *ca, QWACType)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if _, _, _, err := Extract(d); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	ca, err := CompetentAuthorityForCountryCode("GB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.Unverified {
+		t.Error("Expected modifying a resolved authority not to change the built-in list")
+	}
+}
+
+func TestCompetentAuthorityForID(t *testing.T) {
+	ca, err := CompetentAuthorityForID("GB-FCA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.Name != defaultCA.Name {
+		t.Errorf("Expected CA name: %s but got %s", defaultCA.Name, ca.Name)
+	}
+	if _, err := CompetentAuthorityForID("XX-NONE"); err == nil {
+		t.Error("Expected error for an unknown CA ID")
+	}
+}
+
+func TestExtractCompetentAuthority(t *testing.T) {
+	for _, tc := range []struct {
+		ca          CompetentAuthority
+		name        string
+		nameDerived bool
+	}{
+		{defaultCA, defaultCA.Name, false},
+		{CompetentAuthority{ID: "GB-FCA"}, defaultCA.Name, true},
+		{CompetentAuthority{ID: "XX-NONE"}, "", false},
+		{CompetentAuthority{Name: "Some Authority"}, "Some Authority", false},
+	} {
+		// Encoded directly, as Serialize rejects the incomplete authorities
+		// some issuers put in their statements.
+		r, err := encodeRoles([]Role{RoleAccountInformation}, RoleOIDArc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := asn1.Marshal([]qcStatement{{
+			OID:       PSD2OID,
+			RolesInfo: rolesInfo{Roles: r, CAName: tc.ca.Name, CAID: tc.ca.ID},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ca, nameDerived, err := ExtractCompetentAuthority(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ca.Name != tc.name || ca.ID != tc.ca.ID || nameDerived != tc.nameDerived {
+			t.Errorf("%+v: got %+v, name derived: %t", tc.ca, ca, nameDerived)
+		}
+	}
+
+	if _, _, err := ExtractCompetentAuthority([]byte{0x30, 0x00}); err == nil {
+		t.Error("Expected error for a statement without PSD2")
+	}
+}
+
+func TestReplaceCompetentAuthority(t *testing.T) {
+	pds := PDSLocation{URL: "https://example.com/pds_en.pdf", Language: "en"}
+	roles := []Role{RolePaymentInitiation, RoleAccountInformation}
+	d, err := Serialize(roles, defaultCA, QSEALType, WithCompliance(), WithPDS(pds), PreserveRoleOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ie := CompetentAuthority{Name: "Central Bank of Ireland", ID: "IE-CBI"}
+
+	replaced, err := ReplaceCompetentAuthority(d, ie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Serialize(roles, ie, QSEALType, WithCompliance(), WithPDS(pds), PreserveRoleOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(replaced, want) {
+		t.Errorf("Mismatch:\n got: %x\nwant: %x", replaced, want)
+	}
+
+	qcType, err := ExtractType(replaced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !qcType.Equal(QSEALType) {
+		t.Errorf("Expected QC type %v but got %v", QSEALType, qcType)
+	}
+
+	noPSD2, err := Serialize(nil, CompetentAuthority{}, QWACType, OmitPSD2())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReplaceCompetentAuthority(noPSD2, ie); err == nil {
+		t.Error("Expected error for a statement without PSD2")
+	}
+}
+
+func TestReplaceCompetentAuthorityPreservesEncoding(t *testing.T) {
+	ie := CompetentAuthority{Name: "Central Bank of Ireland", ID: "IE-CBI"}
+	arc := asn1.ObjectIdentifier{0, 4, 0, 19495, 9}
+	roles, err := encodeRoles([]Role{RoleAccountInformation, RolePaymentInitiation}, arc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type extendedRolesInfo struct {
+		Roles  []role
+		CAName string `asn1:"utf8"`
+		CAID   string `asn1:"utf8"`
+		Extra  string `asn1:"utf8"`
+	}
+	type extendedStatement struct {
+		OID  asn1.ObjectIdentifier
+		Info extendedRolesInfo
+	}
+	type explicitStatement struct {
+		OID  asn1.ObjectIdentifier
+		Info rolesInfo `asn1:"explicit,tag:0"`
+	}
+	compliance := struct{ OID asn1.ObjectIdentifier }{QcComplianceOID}
+
+	for _, tc := range []struct {
+		name      string
+		statement func(ca CompetentAuthority) interface{}
+	}{
+		{"non-default role arc", func(ca CompetentAuthority) interface{} {
+			return qcStatement{OID: PSD2OID, RolesInfo: rolesInfo{Roles: roles, CAName: ca.Name, CAID: ca.ID}}
+		}},
+		{"fields after the CA ID", func(ca CompetentAuthority) interface{} {
+			return extendedStatement{PSD2OID, extendedRolesInfo{roles, ca.Name, ca.ID, "extra"}}
+		}},
+		{"explicitly tagged RolesInfo", func(ca CompetentAuthority) interface{} {
+			return explicitStatement{PSD2OID, rolesInfo{Roles: roles, CAName: ca.Name, CAID: ca.ID}}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := asn1.Marshal([]interface{}{compliance, tc.statement(defaultCA)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := asn1.Marshal([]interface{}{compliance, tc.statement(ie)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReplaceCompetentAuthority(d, ie)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("Mismatch:\n got: %x\nwant: %x", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	d, err := Serialize([]Role{RoleAccountInformation}, defaultCA, QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roleOID, err := asn1.Marshal(append(append(asn1.ObjectIdentifier{}, RoleOIDArc...), RoleAccountInformation.Code()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caID := append([]byte{asn1.TagUTF8String, byte(len(defaultCA.ID))}, defaultCA.ID...)
+
+	for _, tc := range []struct {
+		name    string
+		element []byte
+		tag     byte
+		message string
+	}{
+		{"CA ID", caID, asn1.TagInteger, "CA ID is not a string"},
+		{"role OID", roleOID, asn1.TagOctetString, "expected OBJECT IDENTIFIER"},
+	} {
+		offset := bytes.Index(d, tc.element)
+		if offset < 0 {
+			t.Fatalf("%s: element not found in %x", tc.name, d)
+		}
+		corrupt := append([]byte{}, d...)
+		corrupt[offset] = tc.tag
+
+		_, _, _, err := Extract(corrupt)
+		de, ok := err.(*DecodeError)
+		if !ok {
+			t.Fatalf("%s: expected a DecodeError, got %v", tc.name, err)
+		}
+		if de.Offset != offset {
+			t.Errorf("%s: expected offset %d, got %d", tc.name, offset, de.Offset)
+		}
+		context := fmt.Sprintf("| % x", corrupt[offset:offset+4])
+		if msg := de.Error(); !strings.Contains(msg, tc.message) || !strings.Contains(msg, context) {
+			t.Errorf("%s: expected %q and %q in %q", tc.name, tc.message, context, msg)
+		}
+
+		if _, _, _, err := ExtractRoles(corrupt); err == nil || err.Error() != de.Error() {
+			t.Errorf("%s: expected ExtractRoles to fail like Extract, got %v", tc.name, err)
+		}
+	}
+
+	_, _, _, err = Extract(d[:len(d)-1])
+	if de, ok := err.(*DecodeError); !ok || de.Offset != 0 {
+		t.Errorf("Expected a DecodeError at offset 0 for truncated data, got %v", err)
+	}
+}
+
+func TestValidateCompetentAuthority(t *testing.T) {
+	for code, ca := range caMap {
+		if err := ca.Validate(); err != nil {
+			t.Errorf("Expected built-in authority for %s to be valid: %v", code, err)
+		}
+	}
+	derived, err := DeriveCompetentAuthority("GB", "FCA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := derived.Validate(); err != nil {
+		t.Errorf("Expected derived authority to be valid: %v", err)
+	}
+
+	for _, ca := range []CompetentAuthority{
+		{Name: defaultCA.Name},
+		{Name: defaultCA.Name, ID: "GB"},
+		{Name: defaultCA.Name, ID: "GBFCA"},
+		{Name: defaultCA.Name, ID: "gb-fca"},
+		{Name: defaultCA.Name, ID: "GB-F"},
+		{Name: defaultCA.Name, ID: "GB-FCA-1"},
+		{Name: defaultCA.Name, ID: "GB-ABCDEFGHI"},
+		{Name: defaultCA.Name, ID: "GBR-FCA"},
+		{ID: "GB-FCA"},
+		{Name: strings.Repeat("x", 257), ID: "GB-FCA"},
+		{Name: "\xff", ID: "GB-FCA"},
+	} {
+		if err := ca.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", ca)
+		}
+		if _, err := Serialize([]Role{RoleAccountInformation}, ca, QWACType); err == nil {
+			t.Errorf("Expected Serialize to reject %+v", ca)
+		}
+	}
+
+	d, err := Serialize([]Role{RoleAccountInformation}, defaultCA, QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReplaceCompetentAuthority(d, CompetentAuthority{Name: "Some Authority", ID: "GB FCA"}); err == nil {
+		t.Error("Expected ReplaceCompetentAuthority to reject a malformed ID")
+	}
+	if _, err := DeriveCompetentAuthority("GB", "F-CA"); err == nil {
+		t.Error("Expected DeriveCompetentAuthority to reject a malformed NCA")
+	}
+}
+
+func TestRoleOIDArc(t *testing.T) {
+	roleOIDs := func(d []byte) []asn1.ObjectIdentifier {
+		statements, err := parseStatements(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, st := range statements {
+			if !st.OID.Equal(PSD2OID) {
+				continue
+			}
+			info, err := parseRolesInfo(st.Info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var oids []asn1.ObjectIdentifier
+			for _, r := range info.Roles {
+				oids = append(oids, r.OID)
+			}
+			return oids
+		}
+		t.Fatal("No PSD2 statement")
+		return nil
+	}
+	roles := []Role{RolePaymentInitiation, RoleAccountInformation}
+
+	d, err := Serialize(roles, defaultCA, QWACType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []asn1.ObjectIdentifier{{0, 4, 0, 19495, 1, 2}, {0, 4, 0, 19495, 1, 3}}
+	if got := roleOIDs(d); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected default role OIDs %v, got %v", expected, got)
+	}
+
+	arc := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+	d, err = Serialize(roles, defaultCA, QWACType, WithRoleOIDArc(arc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []asn1.ObjectIdentifier{append(append(asn1.ObjectIdentifier{}, arc...), 2), append(append(asn1.ObjectIdentifier{}, arc...), 3)}
+	if got := roleOIDs(d); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected role OIDs %v, got %v", expected, got)
+	}
+	extracted, _, _, err := Extract(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(extracted, roles) {
+		t.Errorf("Expected roles %v, got %v", roles, extracted)
+	}
+	mismatches, err := CheckRoleOIDs(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != len(roles) {
+		t.Errorf("Expected CheckRoleOIDs to report every role, got %v", mismatches)
+	}
+
+	if _, err := Serialize(roles, defaultCA, QWACType, WithRoleOIDArc(nil)); err == nil {
+		t.Error("Expected an error for an empty arc")
+	}
+}
@@ -0,0 +1,133 @@
+package eidas
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"sync"
+
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+// registeredQCType is a QC type known to GenerateCSR, with the key usages and
+// extended key usages a certificate of that type must have.
+type registeredQCType struct {
+	oid         asn1.ObjectIdentifier
+	name        string
+	keyUsage    []x509.KeyUsage
+	extKeyUsage []asn1.ObjectIdentifier
+}
+
+var qcTypes = struct {
+	sync.RWMutex
+	types []registeredQCType
+}{
+	types: []registeredQCType{
+		{
+			oid:      qcstatements.QWACType,
+			name:     string(qcstatements.QCTypeQWAC),
+			keyUsage: []x509.KeyUsage{x509.KeyUsageDigitalSignature},
+			extKeyUsage: []asn1.ObjectIdentifier{
+				tLSWWWServerAuthUsage,
+				tLSWWWClientAuthUsage,
+			},
+		},
+		{
+			oid:  qcstatements.QSEALType,
+			name: string(qcstatements.QCTypeQSEAL),
+			keyUsage: []x509.KeyUsage{
+				x509.KeyUsageDigitalSignature,
+				x509.KeyUsageContentCommitment, // Also known as NonRepudiation.
+			},
+			extKeyUsage: []asn1.ObjectIdentifier{},
+		},
+		{
+			// EN 319 412-2 recommends only nonRepudiation for certificates
+			// for qualified electronic signatures.
+			oid:         qcstatements.ESignType,
+			name:        string(qcstatements.QCTypeESign),
+			keyUsage:    []x509.KeyUsage{x509.KeyUsageContentCommitment},
+			extKeyUsage: []asn1.ObjectIdentifier{},
+		},
+	},
+}
+
+// RegisterQCType makes a QC type beyond QWACType, QSEALType and ESignType,
+// e.g. a national variant, known to GenerateCSR and the checks on issued
+// certificates. Certificates of the type are requested with keyUsages and
+// ekus. name identifies the type, e.g. to the CLI's -type flag. It is an
+// error to register an OID or name twice.
+func RegisterQCType(oid asn1.ObjectIdentifier, keyUsages []x509.KeyUsage, ekus []asn1.ObjectIdentifier, name string) error {
+	if len(oid) == 0 || name == "" {
+		return fmt.Errorf("eidas: QC type needs an OID and a name")
+	}
+	if len(keyUsages) == 0 {
+		return fmt.Errorf("eidas: QC type %s has no key usages", name)
+	}
+	for _, usage := range keyUsages {
+		if _, ok := keyUsageNames[usage]; !ok {
+			return fmt.Errorf("eidas: QC type %s has unknown key usage %d", name, usage)
+		}
+	}
+	for _, usage := range ekus {
+		if !isKnownExtKeyUsage(usage) {
+			return fmt.Errorf("eidas: QC type %s has unknown extended key usage %v", name, usage)
+		}
+	}
+
+	qcTypes.Lock()
+	defer qcTypes.Unlock()
+	for _, t := range qcTypes.types {
+		if t.oid.Equal(oid) || t.name == name {
+			return fmt.Errorf("eidas: QC type %s (%v) is already registered as %s (%v)", name, oid, t.name, t.oid)
+		}
+	}
+	qcTypes.types = append(qcTypes.types, registeredQCType{
+		oid:         append(asn1.ObjectIdentifier{}, oid...),
+		name:        name,
+		keyUsage:    append([]x509.KeyUsage{}, keyUsages...),
+		extKeyUsage: append([]asn1.ObjectIdentifier{}, ekus...),
+	})
+	return nil
+}
+
+// QCTypeByName returns the OID of the registered QC type with the given name,
+// e.g. "QWAC".
+func QCTypeByName(name string) (asn1.ObjectIdentifier, error) {
+	qcTypes.RLock()
+	defer qcTypes.RUnlock()
+	for _, t := range qcTypes.types {
+		if t.name == name {
+			return t.oid, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown QC type: %s", name)
+}
+
+// lookupQCType returns the registered QC type identified by oid.
+func lookupQCType(oid asn1.ObjectIdentifier) (registeredQCType, error) {
+	qcTypes.RLock()
+	defer qcTypes.RUnlock()
+	for _, t := range qcTypes.types {
+		if t.oid.Equal(oid) {
+			return t, nil
+		}
+	}
+	return registeredQCType{}, fmt.Errorf("unknown QC type: %v", oid)
+}
+
+func keyUsageForType(t asn1.ObjectIdentifier) ([]x509.KeyUsage, error) {
+	registered, err := lookupQCType(t)
+	if err != nil {
+		return nil, err
+	}
+	return append([]x509.KeyUsage{}, registered.keyUsage...), nil
+}
+
+func extendedKeyUsageForType(t asn1.ObjectIdentifier) ([]asn1.ObjectIdentifier, error) {
+	registered, err := lookupQCType(t)
+	if err != nil {
+		return nil, err
+	}
+	return append([]asn1.ObjectIdentifier{}, registered.extKeyUsage...), nil
+}
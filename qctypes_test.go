@@ -0,0 +1,87 @@
+package eidas
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRegisterQCType(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+	// An OID under the private enterprise arc, standing in for a national
+	// variant.
+	custom := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+	codeSigning := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 3}
+
+	Convey("built-in types are registered", t, func() {
+		qwac, err := QCTypeByName("QWAC")
+		So(err, ShouldBeNil)
+		So(qwac.Equal(qcstatements.QWACType), ShouldBeTrue)
+		qseal, err := QCTypeByName("QSEAL")
+		So(err, ShouldBeNil)
+		So(qseal.Equal(qcstatements.QSEALType), ShouldBeTrue)
+		_, err = QCTypeByName("QESIG")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("esign type", t, func() {
+		esign, err := QCTypeByName("ESIGN")
+		So(err, ShouldBeNil)
+		So(esign.Equal(qcstatements.ESignType), ShouldBeTrue)
+
+		data, _, err := GenerateCSR("GB", "Foo Org", "", "Foo Name", nil, esign, WithoutPSD2())
+		So(err, ShouldBeNil)
+		cert, err := ReadCertificate(issueCertificate(t, data, nil))
+		So(err, ShouldBeNil)
+		So(cert.KeyUsage, ShouldEqual, x509.KeyUsageContentCommitment)
+		So(cert.ExtKeyUsage, ShouldBeEmpty)
+		So(cert.UnknownExtKeyUsage, ShouldBeEmpty)
+		So(ValidateKeyUsage(cert), ShouldBeNil)
+		types, err := CertificateQCTypes(cert)
+		So(err, ShouldBeNil)
+		So(types, ShouldResemble, []asn1.ObjectIdentifier{qcstatements.ESignType})
+
+		So(ValidateRolesForType(roles, esign, []asn1.ObjectIdentifier{tLSWWWClientAuthUsage}), ShouldResemble,
+			[]string{"ESIGN has TLS extended key usage 1.3.6.1.5.5.7.3.2"})
+	})
+
+	Convey("unregistered type", t, func() {
+		_, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 2})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("registered type", t, func() {
+		So(RegisterQCType(custom, []x509.KeyUsage{x509.KeyUsageDigitalSignature, x509.KeyUsageKeyAgreement}, []asn1.ObjectIdentifier{codeSigning}, "TEST"), ShouldBeNil)
+
+		oid, err := QCTypeByName("TEST")
+		So(err, ShouldBeNil)
+		So(oid.Equal(custom), ShouldBeTrue)
+
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, custom)
+		So(err, ShouldBeNil)
+		cert, err := ReadCertificate(issueCertificate(t, data, nil))
+		So(err, ShouldBeNil)
+		So(cert.KeyUsage, ShouldEqual, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyAgreement)
+		So(cert.ExtKeyUsage, ShouldResemble, []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning})
+		So(ValidateKeyUsage(cert), ShouldBeNil)
+		So(ValidateRolesForType(roles, custom, []asn1.ObjectIdentifier{codeSigning}), ShouldBeEmpty)
+
+		Convey("cannot be registered twice", func() {
+			So(RegisterQCType(custom, []x509.KeyUsage{x509.KeyUsageDigitalSignature}, nil, "OTHER"), ShouldNotBeNil)
+			So(RegisterQCType(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 3}, []x509.KeyUsage{x509.KeyUsageDigitalSignature}, nil, "TEST"), ShouldNotBeNil)
+			So(RegisterQCType(qcstatements.QWACType, []x509.KeyUsage{x509.KeyUsageDigitalSignature}, nil, "QWAC2"), ShouldNotBeNil)
+		})
+	})
+
+	Convey("invalid registrations", t, func() {
+		other := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 4}
+		So(RegisterQCType(nil, []x509.KeyUsage{x509.KeyUsageDigitalSignature}, nil, "NOOID"), ShouldNotBeNil)
+		So(RegisterQCType(other, []x509.KeyUsage{x509.KeyUsageDigitalSignature}, nil, ""), ShouldNotBeNil)
+		So(RegisterQCType(other, nil, nil, "NOUSAGE"), ShouldNotBeNil)
+		So(RegisterQCType(other, []x509.KeyUsage{x509.KeyUsage(1 << 12)}, nil, "BADUSAGE"), ShouldNotBeNil)
+		So(RegisterQCType(other, []x509.KeyUsage{x509.KeyUsageDigitalSignature}, []asn1.ObjectIdentifier{{1, 2, 3}}, "BADEKU"), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,52 @@
+package eidas
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"time"
+)
+
+// The validity of certificates generated with WithReproducibleSeed, unless
+// WithValidity is given. It is fixed so that two runs give the same
+// certificate, and long so that committed fixtures do not expire.
+var (
+	reproducibleNotBefore = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	reproducibleNotAfter  = time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// errReproducibleSeed is returned by the functions that do not support
+// WithReproducibleSeed.
+var errReproducibleSeed = errors.New("eidas: WithReproducibleSeed is only supported by GenerateSelfSignedCertificateWithKey")
+
+// WithReproducibleSeed makes GenerateSelfSignedCertificateWithKey
+// deterministic: the serial number is derived from seed, and unless
+// WithValidity is given the certificate is valid from 2020-01-01 to
+// 2100-01-01. With a fixed RSA key, e.g. from eidastest.ReproducibleKey, two
+// runs with the same seed and arguments produce byte-identical certificates,
+// so fixtures can be committed and unintended encoding changes show up in
+// diffs.
+//
+// It is for testing only. GenerateSelfSignedCertificate, which generates a
+// random key, and the functions that generate a CSR return an error if it is
+// given.
+func WithReproducibleSeed(seed []byte) CertificateOption {
+	return func(c *csrConfig) {
+		c.seed = append([]byte(nil), seed...)
+	}
+}
+
+// certificateSerial returns the serial number of a self-signed certificate, from
+// the seed if one was given.
+func (c *csrConfig) certificateSerial() (*big.Int, error) {
+	if c.seed == nil {
+		return randomSerial()
+	}
+	h := sha256.New()
+	h.Write(c.seed)
+	h.Write([]byte("serial"))
+	b := h.Sum(nil)[:16]
+	// Clear the top bit so the serial stays 16 bytes once DER encoded.
+	b[0] &= 0x7f
+	return new(big.Int).SetBytes(b), nil
+}
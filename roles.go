@@ -0,0 +1,68 @@
+package eidas
+
+import (
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+// ValidateRolesForType checks that PSD2 roles and extended key usages make
+// sense for a QC type, returning a description of each problem found. The
+// rules are:
+//
+//   - the QC type must be QWAC, QSEAL, ESIGN or one added with
+//     RegisterQCType;
+//   - at least one role must be given, and none more than once;
+//   - a QSEAL or ESIGN certificate is for sealing or signing data, not TLS,
+//     so must not carry the serverAuth or clientAuth extended key usages;
+//   - a QWAC authenticates a website, so if it has extended key usages they
+//     must include serverAuth.
+//
+// ETSI TS 119 495 allows every role with either QC type, so roles are not
+// otherwise restricted.
+func ValidateRolesForType(roles []qcstatements.Role, qcType asn1.ObjectIdentifier, extKeyUsage []asn1.ObjectIdentifier) []string {
+	return append(validateRoles(roles), validateUsagesForType(qcType, extKeyUsage)...)
+}
+
+func validateRoles(roles []qcstatements.Role) []string {
+	var problems []string
+	if len(roles) == 0 {
+		problems = append(problems, "no PSD2 roles given")
+	}
+	seen := make(map[qcstatements.Role]bool)
+	for _, r := range roles {
+		if seen[r] {
+			problems = append(problems, fmt.Sprintf("role %s given more than once", r))
+		}
+		seen[r] = true
+	}
+	return problems
+}
+
+func validateUsagesForType(qcType asn1.ObjectIdentifier, extKeyUsage []asn1.ObjectIdentifier) []string {
+	switch t := qcstatements.QCTypeForOID(qcType); t {
+	case qcstatements.QCTypeQSEAL, qcstatements.QCTypeESign:
+		var problems []string
+		for _, usage := range extKeyUsage {
+			if usage.Equal(tLSWWWServerAuthUsage) || usage.Equal(tLSWWWClientAuthUsage) {
+				problems = append(problems, fmt.Sprintf("%s has TLS extended key usage %v", t, usage))
+			}
+		}
+		return problems
+	case qcstatements.QCTypeQWAC:
+		if len(extKeyUsage) == 0 {
+			return nil
+		}
+		for _, usage := range extKeyUsage {
+			if usage.Equal(tLSWWWServerAuthUsage) {
+				return nil
+			}
+		}
+		return []string{"QWAC extended key usage is missing serverAuth"}
+	}
+	if _, err := lookupQCType(qcType); err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package eidas
+
+import (
+	"encoding/asn1"
+	"testing"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidateRolesForType(t *testing.T) {
+	ai := []qcstatements.Role{qcstatements.RoleAccountInformation}
+
+	Convey("valid QWAC", t, func() {
+		So(ValidateRolesForType(ai, qcstatements.QWACType, []asn1.ObjectIdentifier{tLSWWWServerAuthUsage, tLSWWWClientAuthUsage}), ShouldBeEmpty)
+	})
+
+	Convey("valid QSEAL", t, func() {
+		So(ValidateRolesForType(ai, qcstatements.QSEALType, nil), ShouldBeEmpty)
+	})
+
+	Convey("no roles", t, func() {
+		So(ValidateRolesForType(nil, qcstatements.QSEALType, nil), ShouldHaveLength, 1)
+	})
+
+	Convey("duplicate roles", t, func() {
+		problems := ValidateRolesForType(append(ai, ai...), qcstatements.QSEALType, nil)
+		So(problems, ShouldHaveLength, 1)
+		So(problems[0], ShouldContainSubstring, "PSP_AI")
+	})
+
+	Convey("QSEAL with TLS usage", t, func() {
+		So(ValidateRolesForType(ai, qcstatements.QSEALType, []asn1.ObjectIdentifier{tLSWWWServerAuthUsage}), ShouldHaveLength, 1)
+	})
+
+	Convey("QWAC without serverAuth", t, func() {
+		So(ValidateRolesForType(ai, qcstatements.QWACType, []asn1.ObjectIdentifier{tLSWWWClientAuthUsage}), ShouldHaveLength, 1)
+	})
+
+	Convey("unknown QC type", t, func() {
+		So(ValidateRolesForType(ai, asn1.ObjectIdentifier{1, 2, 3}, nil), ShouldHaveLength, 1)
+	})
+}
+
+func TestRoleValidationOptions(t *testing.T) {
+	dup := []qcstatements.Role{qcstatements.RoleAccountInformation, qcstatements.RoleAccountInformation}
+
+	Convey("duplicate roles warn by default", t, func() {
+		var warnings []string
+		_, err := NewCSRTemplate("GB", "Foo Org", "Foo Org ID", "Foo Name", dup, qcstatements.QWACType, WithWarningHandler(func(w string) {
+			warnings = append(warnings, w)
+		}))
+		So(err, ShouldBeNil)
+		So(warnings, ShouldHaveLength, 1)
+	})
+
+	Convey("duplicate roles fail with StrictRoles", t, func() {
+		_, err := NewCSRTemplate("GB", "Foo Org", "Foo Org ID", "Foo Name", dup, qcstatements.QWACType, StrictRoles())
+		So(err, ShouldNotBeNil)
+	})
+}
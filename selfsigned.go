@@ -0,0 +1,107 @@
+package eidas
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"time"
+
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+// defaultSelfSignedValidity is how long a self-signed certificate is valid
+// for unless WithValidity is given.
+const defaultSelfSignedValidity = 365 * 24 * time.Hour
+
+// WithValidity sets the notBefore and notAfter of a certificate generated by
+// GenerateSelfSignedCertificate, e.g. to mint an expired certificate for
+// testing. It has no effect on a CSR.
+func WithValidity(notBefore, notAfter time.Time) CertificateOption {
+	return func(c *csrConfig) {
+		c.notBefore = notBefore
+		c.notAfter = notAfter
+	}
+}
+
+// GenerateSelfSignedCertificate generates a key and a self-signed certificate
+// with the subject and extensions GenerateCSR would request, for testing. The
+// certificate is valid from now for a year unless WithValidity is given.
+func GenerateSelfSignedCertificate(
+	countryCode string, orgName string, orgID string, commonName string, roles []qcstatements.Role, qcType asn1.ObjectIdentifier, opts ...CertificateOption) (*x509.Certificate, *rsa.PrivateKey, error) {
+	if newCSRConfig(opts).seed != nil {
+		return nil, nil, errReproducibleSeed
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key pair: %v", err)
+	}
+	cert, err := GenerateSelfSignedCertificateWithKey(key, countryCode, orgName, orgID, commonName, roles, qcType, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// GenerateSelfSignedCertificateWithKey is like GenerateSelfSignedCertificate
+// but uses an existing key rather than generating one. RSA, ECDSA and Ed25519
+// keys are supported. With WithReproducibleSeed and an RSA key the
+// certificate is the same on every run.
+func GenerateSelfSignedCertificateWithKey(key crypto.Signer,
+	countryCode string, orgName string, orgID string, commonName string, roles []qcstatements.Role, qcType asn1.ObjectIdentifier, opts ...CertificateOption) (*x509.Certificate, error) {
+	cfg := newCSRConfig(opts)
+	notBefore, notAfter := cfg.notBefore, cfg.notAfter
+	if notBefore.IsZero() && notAfter.IsZero() {
+		if cfg.seed != nil {
+			notBefore, notAfter = reproducibleNotBefore, reproducibleNotAfter
+		} else {
+			notBefore = time.Now()
+			notAfter = notBefore.Add(defaultSelfSignedValidity)
+		}
+	}
+	if !notAfter.After(notBefore) {
+		return nil, fmt.Errorf("eidas: notAfter %v must be after notBefore %v", notAfter, notBefore)
+	}
+	if _, ok := key.Public().(*rsa.PublicKey); cfg.keyEncipherment && !ok {
+		return nil, fmt.Errorf("eidas: keyEncipherment requires an RSA key, got %T", key.Public())
+	}
+
+	req, err := NewCSRTemplate(countryCode, orgName, orgID, commonName, roles, qcType, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ski, err := cfg.subjectKeyIdentifier(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	serial, err := cfg.certificateSerial()
+	if err != nil {
+		return nil, fmt.Errorf("eidas: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:    serial,
+		RawSubject:      req.RawSubject,
+		NotBefore:       notBefore,
+		NotAfter:        notAfter,
+		DNSNames:        req.DNSNames,
+		IPAddresses:     req.IPAddresses,
+		URIs:            req.URIs,
+		EmailAddresses:  req.EmailAddresses,
+		ExtraExtensions: insertSKI(req.ExtraExtensions, ski),
+	}
+	d, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+	if err := cfg.reportKeyPin(cert.PublicKey, cert.Extensions); err != nil {
+		return nil, fmt.Errorf("eidas: %v", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,83 @@
+package eidas
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGenerateSelfSignedCertificate(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+
+	Convey("default validity", t, func() {
+		cert, key, err := GenerateSelfSignedCertificate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithDNSName("example.com"))
+		So(err, ShouldBeNil)
+		So(cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature), ShouldBeNil)
+		So(cert.PublicKey, ShouldResemble, &key.PublicKey)
+		So(cert.NotAfter.Sub(cert.NotBefore), ShouldEqual, 365*24*time.Hour)
+		So(cert.DNSNames, ShouldResemble, []string{"example.com"})
+		So(cert.Subject.CommonName, ShouldEqual, "Foo Name")
+		So(ValidateKeyUsage(cert), ShouldBeNil)
+	})
+
+	Convey("expired certificate", t, func() {
+		notBefore := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+		notAfter := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+		cert, _, err := GenerateSelfSignedCertificate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QSEALType, WithValidity(notBefore, notAfter))
+		So(err, ShouldBeNil)
+		So(cert.NotBefore.Equal(notBefore), ShouldBeTrue)
+		So(cert.NotAfter.Equal(notAfter), ShouldBeTrue)
+	})
+
+	Convey("reproducible certificate", t, func() {
+		// testdata/reproducible_key.pem was made by OpenSSL 3.0 with:
+		//
+		//	openssl genrsa -traditional -out reproducible_key.pem 2048
+		d, err := ioutil.ReadFile("testdata/reproducible_key.pem")
+		So(err, ShouldBeNil)
+		block, _ := pem.Decode(d)
+		So(block, ShouldNotBeNil)
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		So(err, ShouldBeNil)
+
+		generate := func(seed string) []byte {
+			cert, err := GenerateSelfSignedCertificateWithKey(key, "GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType,
+				WithDNSName("example.com"), WithReproducibleSeed([]byte(seed)))
+			So(err, ShouldBeNil)
+			So(cert.PublicKey, ShouldResemble, &key.PublicKey)
+			So(cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature), ShouldBeNil)
+			So(cert.NotBefore, ShouldEqual, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+			So(cert.NotAfter, ShouldEqual, time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC))
+			return cert.Raw
+		}
+		cert1 := generate("fixture")
+		So(generate("fixture"), ShouldResemble, cert1)
+		So(generate("other fixture"), ShouldNotResemble, cert1)
+
+		Convey("is rejected when the key is random", func() {
+			_, _, err := GenerateSelfSignedCertificate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithReproducibleSeed([]byte("fixture")))
+			So(err, ShouldEqual, errReproducibleSeed)
+			_, _, err = GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithReproducibleSeed([]byte("fixture")))
+			So(err, ShouldEqual, errReproducibleSeed)
+			_, err = GenerateCSRWithSigner(key, "GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithReproducibleSeed([]byte("fixture")))
+			So(err, ShouldEqual, errReproducibleSeed)
+			csr, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+			So(err, ShouldBeNil)
+			parsed, err := x509.ParseCertificateRequest(csr)
+			So(err, ShouldBeNil)
+			_, _, err = GenerateCSRWithRawSubject(parsed.RawSubject, roles, qcstatements.QWACType, WithReproducibleSeed([]byte("fixture")))
+			So(err, ShouldEqual, errReproducibleSeed)
+		})
+	})
+
+	Convey("notAfter before notBefore", t, func() {
+		now := time.Now()
+		_, _, err := GenerateSelfSignedCertificate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType, WithValidity(now, now.Add(-time.Hour)))
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,75 @@
+package eidas
+
+import (
+	"encoding/asn1"
+	"fmt"
+)
+
+// SubjectAttribute is an attribute of a distinguished name, as it was encoded.
+type SubjectAttribute struct {
+	// RDN is the index of the relative distinguished name holding the
+	// attribute. Attributes of a multi-valued RDN share an index.
+	RDN  int
+	Type asn1.ObjectIdentifier
+	// Tag is the universal ASN.1 tag of the value, e.g. asn1.TagUTF8String
+	// or asn1.TagPrintableString.
+	Tag int
+	// Value is the decoded value of string attributes, and is empty for
+	// other types.
+	Value string
+	// Raw is the DER encoding of the value.
+	Raw []byte
+}
+
+type attributeTypeAndValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// ParseSubject decodes a DER encoded distinguished name, such as the
+// RawSubject of a certificate or CSR, into its attributes in the order they
+// are encoded. Unlike pkix.Name it keeps the string type of each value.
+func ParseSubject(raw []byte) ([]SubjectAttribute, error) {
+	var rdns []asn1.RawValue
+	rest, err := asn1.Unmarshal(raw, &rdns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode subject: %v", err)
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("trailing data after subject")
+	}
+
+	var attrs []SubjectAttribute
+	for i, rdn := range rdns {
+		var set []attributeTypeAndValue
+		if _, err := asn1.UnmarshalWithParams(rdn.FullBytes, &set, "set"); err != nil {
+			return nil, fmt.Errorf("failed to decode subject RDN %d: %v", i, err)
+		}
+		for _, atv := range set {
+			attr := SubjectAttribute{
+				RDN:  i,
+				Type: atv.Type,
+				Tag:  atv.Value.Tag,
+				Raw:  atv.Value.FullBytes,
+			}
+			if atv.Value.Class == asn1.ClassUniversal && !atv.Value.IsCompound {
+				attr.Value, err = attributeString(atv.Value)
+				if err != nil {
+					return nil, fmt.Errorf("failed to decode %v value: %v", atv.Type, err)
+				}
+			}
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs, nil
+}
+
+func attributeString(v asn1.RawValue) (string, error) {
+	switch v.Tag {
+	case asn1.TagUTF8String, asn1.TagPrintableString, asn1.TagIA5String, asn1.TagT61String, 26 /* VisibleString */, asn1.TagNumericString:
+		return string(v.Bytes), nil
+	case 30: // BMPString
+		return decodeBMPString(v.Bytes)
+	}
+	return "", nil
+}
@@ -0,0 +1,70 @@
+package eidas
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseSubject(t *testing.T) {
+	Convey("subject of a generated CSR", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", []qcstatements.Role{qcstatements.RoleAccountInformation}, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		csr, err := x509.ParseCertificateRequest(data)
+		So(err, ShouldBeNil)
+
+		attrs, err := ParseSubject(csr.RawSubject)
+		So(err, ShouldBeNil)
+		So(attrs, ShouldHaveLength, 4)
+		for i, want := range []struct {
+			oid   asn1.ObjectIdentifier
+			value string
+		}{
+			{oidCountryCode, "GB"},
+			{oidOrganizationName, "Foo Org"},
+			{oidOrganizationID, "PSDGB-FCA-123456"},
+			{oidCommonName, "Foo Name"},
+		} {
+			So(attrs[i].RDN, ShouldEqual, i)
+			So(attrs[i].Type.Equal(want.oid), ShouldBeTrue)
+			So(attrs[i].Value, ShouldEqual, want.value)
+			So(attrs[i].Tag, ShouldEqual, asn1.TagPrintableString)
+		}
+	})
+
+	Convey("multi-valued RDNs and string types", t, func() {
+		unknown := asn1.ObjectIdentifier{1, 2, 3, 4}
+		raw, err := asn1.Marshal(pkix.RDNSequence{
+			{{Type: oidCommonName, Value: "Foo Name"}},
+			{
+				{Type: oidOrganizationName, Value: asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte("Föo Org")}},
+				{Type: unknown, Value: 42},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		attrs, err := ParseSubject(raw)
+		So(err, ShouldBeNil)
+		So(attrs, ShouldHaveLength, 3)
+		// DER sorts the members of a SET, so the unknown OID comes first.
+		So(attrs[1].RDN, ShouldEqual, 1)
+		So(attrs[1].Type.Equal(unknown), ShouldBeTrue)
+		So(attrs[1].Tag, ShouldEqual, asn1.TagInteger)
+		So(attrs[1].Value, ShouldEqual, "")
+		So(attrs[1].Raw, ShouldResemble, []byte{asn1.TagInteger, 1, 42})
+		So(attrs[2].RDN, ShouldEqual, 1)
+		So(attrs[2].Tag, ShouldEqual, asn1.TagUTF8String)
+		So(attrs[2].Value, ShouldEqual, "Föo Org")
+	})
+
+	Convey("invalid subject", t, func() {
+		_, err := ParseSubject([]byte{0x30, 0x03, 0x02, 0x01, 0x00})
+		So(err, ShouldNotBeNil)
+		_, err = ParseSubject([]byte{0x30, 0x00, 0x00})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,148 @@
+package eidas
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+// Fingerprint returns the hex encoded SHA-256 digest of a DER encoded CSR or
+// certificate.
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// CSRSummary describes a generated CSR, for logging a record of its
+// provenance.
+type CSRSummary struct {
+	Fingerprint string `json:"fingerprint"`
+	// Algorithm is the public key algorithm, e.g. "RSA".
+	Algorithm string `json:"algorithm"`
+	// KeySize is the size of the key in bits.
+	KeySize int                 `json:"key_size"`
+	Subject string              `json:"subject"`
+	Roles   []qcstatements.Role `json:"roles,omitempty"`
+	CAName  string              `json:"ca_name,omitempty"`
+	CAID    string              `json:"ca_id,omitempty"`
+}
+
+// SummarizeCSR returns a summary of a DER encoded CSR, checking that it was
+// signed by key. The roles and competent authority are only set if the CSR
+// has a PSD2 statement.
+func SummarizeCSR(csrDER []byte, key crypto.Signer) (*CSRSummary, error) {
+	if err := VerifyCSRKey(csrDER, key); err != nil {
+		return nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse csr: %v", err)
+	}
+	var subject pkix.RDNSequence
+	if _, err := asn1.Unmarshal(csr.RawSubject, &subject); err != nil {
+		return nil, fmt.Errorf("failed to decode subject: %v", err)
+	}
+
+	summary := &CSRSummary{
+		Fingerprint: Fingerprint(csrDER),
+		Algorithm:   csr.PublicKeyAlgorithm.String(),
+		KeySize:     keySize(csr.PublicKey),
+		Subject:     subject.String(),
+	}
+	if qc := findQCStatements(csr.Extensions); qc != nil {
+		roles, name, id, err := qcstatements.Extract(qc)
+		if err == nil {
+			summary.Roles, summary.CAName, summary.CAID = roles, name, id
+		}
+	}
+	return summary, nil
+}
+
+func keySize(pub crypto.PublicKey) int {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 8 * ed25519.PublicKeySize
+	}
+	return 0
+}
+
+// CertificateSummary describes a received certificate, for auditing the
+// certificates of counterparties.
+type CertificateSummary struct {
+	Fingerprint    string `json:"fingerprint"`
+	Organization   string `json:"organization,omitempty"`
+	OrganizationID string `json:"organization_id,omitempty"`
+	// Types are the names of the declared QC types, e.g. "QWAC", or the
+	// dotted OID of types that are not registered.
+	Types    []string            `json:"types,omitempty"`
+	Roles    []qcstatements.Role `json:"roles,omitempty"`
+	CAName   string              `json:"ca_name,omitempty"`
+	CAID     string              `json:"ca_id,omitempty"`
+	NotAfter time.Time           `json:"not_after"`
+}
+
+// SummarizeCertificate returns a summary of a certificate. The types are only
+// set if it has a QcType statement, and the roles and competent authority if
+// it has a PSD2 statement. It fails if the QCStatements extension cannot be
+// decoded; use LintCertificate to check a certificate in full.
+func SummarizeCertificate(cert *x509.Certificate) (*CertificateSummary, error) {
+	summary := &CertificateSummary{
+		Fingerprint: Fingerprint(cert.Raw),
+		NotAfter:    cert.NotAfter,
+	}
+	if len(cert.Subject.Organization) > 0 {
+		summary.Organization = cert.Subject.Organization[0]
+	}
+	summary.OrganizationID, _ = organizationIDFromName(cert.Subject)
+
+	qc := findQCStatements(cert.Extensions)
+	if qc == nil {
+		return summary, nil
+	}
+	statements, err := qcstatements.ExtractRaw(qc)
+	if err != nil {
+		return nil, fmt.Errorf("eidas: %v", err)
+	}
+	for _, st := range statements {
+		switch {
+		case st.OID.Equal(qcstatements.QcTypeOID):
+			types, err := qcstatements.ExtractTypes(qc)
+			if err != nil {
+				return nil, fmt.Errorf("eidas: %v", err)
+			}
+			for _, t := range types {
+				summary.Types = append(summary.Types, qcTypeName(t))
+			}
+		case st.OID.Equal(qcstatements.PSD2OID):
+			roles, name, id, err := qcstatements.Extract(qc)
+			if err != nil {
+				return nil, fmt.Errorf("eidas: %v", err)
+			}
+			summary.Roles, summary.CAName, summary.CAID = roles, name, id
+		}
+	}
+	return summary, nil
+}
+
+// qcTypeName returns the registered name of a QC type, or its dotted OID if it
+// is not registered.
+func qcTypeName(oid asn1.ObjectIdentifier) string {
+	if registered, err := lookupQCType(oid); err == nil {
+		return registered.name
+	}
+	return oid.String()
+}
@@ -0,0 +1,102 @@
+package eidas
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"testing"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSummarizeCSR(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation}
+
+	Convey("RSA key", t, func() {
+		data, key, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+
+		summary, err := SummarizeCSR(data, key)
+		So(err, ShouldBeNil)
+		So(summary.Fingerprint, ShouldEqual, fmt.Sprintf("%x", sha256.Sum256(data)))
+		So(summary.Algorithm, ShouldEqual, "RSA")
+		So(summary.KeySize, ShouldEqual, 2048)
+		So(summary.Subject, ShouldContainSubstring, "CN=Foo Name")
+		So(summary.Roles, ShouldResemble, roles)
+		So(summary.CAID, ShouldEqual, "GB-FCA")
+		So(summary.CAName, ShouldEqual, "Financial Conduct Authority")
+	})
+
+	Convey("ECDSA key", t, func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		data, err := GenerateCSRWithSigner(key, "GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QSEALType)
+		So(err, ShouldBeNil)
+
+		summary, err := SummarizeCSR(data, key)
+		So(err, ShouldBeNil)
+		So(summary.Algorithm, ShouldEqual, "ECDSA")
+		So(summary.KeySize, ShouldEqual, 256)
+	})
+
+	Convey("key that did not sign the CSR", t, func() {
+		data, _, err := GenerateCSR("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+		other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+
+		_, err = SummarizeCSR(data, other)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestSummarizeCertificate(t *testing.T) {
+	roles := []qcstatements.Role{qcstatements.RoleAccountInformation, qcstatements.RolePaymentInitiation}
+
+	Convey("QWAC", t, func() {
+		cert, _, err := GenerateSelfSignedCertificate("GB", "Foo Org", "PSDGB-FCA-123456", "Foo Name", roles, qcstatements.QWACType)
+		So(err, ShouldBeNil)
+
+		summary, err := SummarizeCertificate(cert)
+		So(err, ShouldBeNil)
+		So(summary.Fingerprint, ShouldEqual, Fingerprint(cert.Raw))
+		So(summary.Organization, ShouldEqual, "Foo Org")
+		So(summary.OrganizationID, ShouldEqual, "PSDGB-FCA-123456")
+		So(summary.Types, ShouldResemble, []string{"QWAC"})
+		So(summary.Roles, ShouldResemble, []qcstatements.Role{qcstatements.RolePaymentInitiation, qcstatements.RoleAccountInformation})
+		So(summary.CAID, ShouldEqual, "GB-FCA")
+		So(summary.CAName, ShouldEqual, "Financial Conduct Authority")
+		So(summary.NotAfter, ShouldEqual, cert.NotAfter)
+	})
+
+	Convey("unregistered QC type", t, func() {
+		d, err := asn1.Marshal([]qcTypeTestStatement{{qcstatements.QcTypeOID, []asn1.ObjectIdentifier{{1, 2, 3}}}})
+		So(err, ShouldBeNil)
+		summary, err := SummarizeCertificate(&x509.Certificate{Extensions: []pkix.Extension{qcStatementsExtension(d)}})
+		So(err, ShouldBeNil)
+		So(summary.Types, ShouldResemble, []string{"1.2.3"})
+		So(summary.Roles, ShouldBeEmpty)
+	})
+
+	Convey("certificate without QCStatements", t, func() {
+		summary, err := SummarizeCertificate(&x509.Certificate{})
+		So(err, ShouldBeNil)
+		So(summary.Types, ShouldBeEmpty)
+	})
+
+	Convey("malformed QCStatements", t, func() {
+		_, err := SummarizeCertificate(&x509.Certificate{Extensions: []pkix.Extension{qcStatementsExtension([]byte{0x30, 0x05})}})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+type qcTypeTestStatement struct {
+	OID    asn1.ObjectIdentifier
+	Detail []asn1.ObjectIdentifier
+}
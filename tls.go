@@ -0,0 +1,58 @@
+package eidas
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/creditkudos/eidas/qcstatements"
+)
+
+// PeerStatement is the PSD2 statement carried by a TLS peer's certificate.
+type PeerStatement struct {
+	// Roles granted to the peer, e.g. qcstatements.RoleAccountInformation.
+	Roles []qcstatements.Role
+	// CAName is the name of the peer's competent authority.
+	CAName string
+	// CAID is the identifier of the peer's competent authority, e.g. "GB-FCA".
+	CAID string
+	// Chain is the verified chain the statement was read from, starting with
+	// the peer's leaf certificate.
+	Chain []*x509.Certificate
+}
+
+// PeerStatementFromTLS returns the PSD2 statement of the peer's leaf
+// certificate from a TLS connection, e.g. http.Request.TLS. The peer's chain
+// must have been verified by the TLS stack; unverified peer certificates are
+// rejected.
+func PeerStatementFromTLS(cs *tls.ConnectionState) (*PeerStatement, error) {
+	if cs == nil {
+		return nil, fmt.Errorf("eidas: not a TLS connection")
+	}
+	return PeerStatementFromChains(cs.VerifiedChains)
+}
+
+// PeerStatementFromChains returns the PSD2 statement of the leaf certificate
+// of the first verified chain. It takes the verifiedChains argument of
+// tls.Config.VerifyPeerCertificate, or tls.ConnectionState.VerifiedChains.
+func PeerStatementFromChains(verifiedChains [][]*x509.Certificate) (*PeerStatement, error) {
+	if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+		return nil, fmt.Errorf("eidas: no verified peer certificate")
+	}
+	chain := verifiedChains[0]
+
+	qc := findQCStatements(chain[0].Extensions)
+	if qc == nil {
+		return nil, fmt.Errorf("eidas: peer certificate has no QCStatements extension")
+	}
+	roles, caName, caID, err := qcstatements.Extract(qc)
+	if err != nil {
+		return nil, fmt.Errorf("eidas: %v", err)
+	}
+	return &PeerStatement{
+		Roles:  roles,
+		CAName: caName,
+		CAID:   caID,
+		Chain:  chain,
+	}, nil
+}
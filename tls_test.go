@@ -0,0 +1,43 @@
+package eidas
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+
+	"github.com/creditkudos/eidas/qcstatements"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPeerStatementFromTLS(t *testing.T) {
+	Convey("verified QWAC peer", t, func() {
+		leaf := selfSignedCertificate(t, qcstatements.QWACType, x509.KeyUsageDigitalSignature)
+		ps, err := PeerStatementFromTLS(&tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{leaf},
+			VerifiedChains:   [][]*x509.Certificate{{leaf}},
+		})
+		So(err, ShouldBeNil)
+		So(ps.Roles, ShouldResemble, []qcstatements.Role{qcstatements.RoleAccountInformation})
+		So(ps.CAName, ShouldEqual, "Financial Conduct Authority")
+		So(ps.CAID, ShouldEqual, "GB-FCA")
+		So(ps.Chain, ShouldHaveLength, 1)
+	})
+
+	Convey("unverified peer", t, func() {
+		leaf := selfSignedCertificate(t, qcstatements.QWACType, x509.KeyUsageDigitalSignature)
+		_, err := PeerStatementFromTLS(&tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{leaf},
+		})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("not a TLS connection", t, func() {
+		_, err := PeerStatementFromTLS(nil)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("peer without QCStatements", t, func() {
+		_, err := PeerStatementFromChains([][]*x509.Certificate{{{}}})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,145 @@
+package eidas
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Functions that may touch the network take a context.Context as their first
+// argument and an injectable *http.Client, and support an offline mode that
+// relies only on trust material supplied by the caller.
+
+// maxFetchedIssuers limits how many issuer certificates VerifyCertificate
+// fetches while building a chain.
+const maxFetchedIssuers = 5
+
+// maxFetchedCertificateSize limits the size of a fetched issuer certificate.
+const maxFetchedCertificateSize = 1 << 20
+
+// VerifyOptions configures VerifyCertificate.
+type VerifyOptions struct {
+	// Roots are the trusted root certificates. If nil the system roots are
+	// used, unless Offline is set, in which case Roots are required.
+	Roots *x509.CertPool
+	// Intermediates are untrusted certificates that may be used to build a
+	// chain to one of the Roots.
+	Intermediates []*x509.Certificate
+	// Offline prevents any network requests, so that only Roots and
+	// Intermediates are used.
+	Offline bool
+	// HTTPClient is used to fetch issuer certificates from the URLs in their
+	// subjects' Authority Information Access extensions. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+	// Now returns the time at which the certificates must be valid. If nil,
+	// time.Now is used.
+	Now func() time.Time
+}
+
+func (opts VerifyOptions) now() time.Time {
+	if opts.Now == nil {
+		return time.Now()
+	}
+	return opts.Now()
+}
+
+// VerifyCertificate builds and verifies chains from cert to the trusted roots,
+// returning the verified chains. Unless opts.Offline is set, missing issuer
+// certificates are fetched over HTTP from the Authority Information Access
+// URLs of the certificates in the chain, honouring ctx for timeout and
+// cancellation.
+func VerifyCertificate(ctx context.Context, cert *x509.Certificate, opts VerifyOptions) ([][]*x509.Certificate, error) {
+	if opts.Offline && opts.Roots == nil {
+		return nil, fmt.Errorf("eidas: offline verification requires roots")
+	}
+	now := opts.now()
+	if err := checkValidityPeriod(cert, now); err != nil {
+		return nil, fmt.Errorf("eidas: failed to verify certificate: %v", err)
+	}
+	client := opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range opts.Intermediates {
+		intermediates.AddCert(c)
+	}
+	verifyOpts := x509.VerifyOptions{
+		Roots:         opts.Roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		CurrentTime:   now,
+	}
+
+	issuee := cert
+	for i := 0; ; i++ {
+		chains, err := cert.Verify(verifyOpts)
+		if err == nil {
+			return chains, nil
+		}
+		if _, ok := err.(x509.UnknownAuthorityError); !ok || opts.Offline || i == maxFetchedIssuers {
+			return nil, fmt.Errorf("eidas: failed to verify certificate: %v", err)
+		}
+		if len(issuee.IssuingCertificateURL) == 0 {
+			return nil, fmt.Errorf("eidas: failed to verify certificate: %v", err)
+		}
+		issuer, fetchErr := fetchCertificate(ctx, client, issuee.IssuingCertificateURL[0])
+		if fetchErr != nil {
+			return nil, fmt.Errorf("eidas: failed to fetch issuer certificate: %v", fetchErr)
+		}
+		intermediates.AddCert(issuer)
+		issuee = issuer
+	}
+}
+
+// checkValidityPeriod reports whether cert is expired or not yet valid at now.
+func checkValidityPeriod(cert *x509.Certificate, now time.Time) error {
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("certificate is not valid until %s", cert.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("certificate expired at %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// fetchCertificate retrieves a PEM or DER encoded certificate from an http or
+// https URL. The URL comes from the untrusted certificate being verified, so
+// other schemes are refused and the response may be at most
+// maxFetchedCertificateSize bytes.
+func fetchCertificate(ctx context.Context, client *http.Client, rawURL string) (*x509.Certificate, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("refusing to fetch %s: scheme must be http or https", rawURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching %s: %s", rawURL, resp.Status)
+	}
+	d, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxFetchedCertificateSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(d) > maxFetchedCertificateSize {
+		return nil, fmt.Errorf("response fetching %s exceeds %d bytes", rawURL, maxFetchedCertificateSize)
+	}
+	return ReadCertificate(d)
+}
@@ -0,0 +1,111 @@
+package eidas
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestVerifyCertificate(t *testing.T) {
+	root := newTestCA(t, "Test Root", nil)
+	intermediate := newTestCA(t, "Test Intermediate", root)
+
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		_, _ = w.Write(intermediate.cert.Raw)
+	}))
+	defer server.Close()
+
+	leaf := intermediate.issue(t, server.URL+"/intermediate.crt")
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	Convey("offline with supplied intermediates", t, func() {
+		fetches = 0
+		chains, err := VerifyCertificate(context.Background(), leaf, VerifyOptions{
+			Roots:         roots,
+			Intermediates: []*x509.Certificate{intermediate.cert},
+			Offline:       true,
+		})
+		So(err, ShouldBeNil)
+		So(chains, ShouldHaveLength, 1)
+		So(chains[0], ShouldHaveLength, 3)
+		So(fetches, ShouldEqual, 0)
+	})
+
+	Convey("offline without intermediates", t, func() {
+		fetches = 0
+		_, err := VerifyCertificate(context.Background(), leaf, VerifyOptions{Roots: roots, Offline: true})
+		So(err, ShouldNotBeNil)
+		So(fetches, ShouldEqual, 0)
+	})
+
+	Convey("offline without roots", t, func() {
+		_, err := VerifyCertificate(context.Background(), leaf, VerifyOptions{Offline: true})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("fetching the intermediate", t, func() {
+		fetches = 0
+		chains, err := VerifyCertificate(context.Background(), leaf, VerifyOptions{
+			Roots:      roots,
+			HTTPClient: server.Client(),
+		})
+		So(err, ShouldBeNil)
+		So(chains[0], ShouldHaveLength, 3)
+		So(fetches, ShouldEqual, 1)
+	})
+
+	Convey("with a clock after the certificate expired", t, func() {
+		tomorrow := func() time.Time { return leaf.NotAfter.Add(24 * time.Hour) }
+		_, err := VerifyCertificate(context.Background(), leaf, VerifyOptions{
+			Roots:         roots,
+			Intermediates: []*x509.Certificate{intermediate.cert},
+			Offline:       true,
+			Now:           tomorrow,
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "expired")
+	})
+
+	Convey("with a clock before the certificate is valid", t, func() {
+		yesterday := func() time.Time { return leaf.NotBefore.Add(-24 * time.Hour) }
+		_, err := VerifyCertificate(context.Background(), leaf, VerifyOptions{
+			Roots:         roots,
+			Intermediates: []*x509.Certificate{intermediate.cert},
+			Offline:       true,
+			Now:           yesterday,
+		})
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "not valid until")
+	})
+
+	Convey("cancelled context", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := VerifyCertificate(ctx, leaf, VerifyOptions{Roots: roots, HTTPClient: server.Client()})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("oversized issuer response", t, func() {
+		large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(make([]byte, maxFetchedCertificateSize+1))
+		}))
+		defer large.Close()
+		_, err := fetchCertificate(context.Background(), large.Client(), large.URL)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "exceeds")
+	})
+
+	Convey("issuer URL that is not http or https", t, func() {
+		_, err := fetchCertificate(context.Background(), server.Client(), "file:///etc/passwd")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "scheme must be http or https")
+	})
+}